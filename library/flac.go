@@ -0,0 +1,147 @@
+package library
+
+// this file reads Vorbis comments and the duration of flacs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// flac metadata block types
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+)
+
+// readFLAC walks the metadata blocks at the start of a flac
+func readFLAC(f io.ReadSeeker, size int64) (tags, error) {
+	var t tags
+
+	magic, err := readAt(f, 0, 4)
+	if err != nil {
+		return t, err
+	}
+
+	if string(magic) != "fLaC" {
+		return t, fmt.Errorf("not a flac")
+	}
+
+	pos := int64(4)
+	for {
+		header, err := readAt(f, pos, 4)
+		if err != nil {
+			return t, err
+		}
+
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		blockSize := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		pos += 4
+
+		if pos+blockSize > size {
+			return t, fmt.Errorf("truncated flac metadata")
+		}
+
+		switch blockType {
+		case flacStreamInfo:
+			block, err := readAt(f, pos, int(blockSize))
+			if err != nil {
+				return t, err
+			}
+
+			t.durationMs = flacDuration(block)
+		case flacVorbisComment:
+			block, err := readAt(f, pos, int(blockSize))
+			if err != nil {
+				return t, err
+			}
+
+			readVorbisComments(block, &t)
+		}
+
+		// other blocks, like pictures, are skipped
+		pos += blockSize
+		if last {
+			return t, nil
+		}
+	}
+}
+
+// flacDuration from the STREAMINFO block
+func flacDuration(block []byte) int64 {
+	if len(block) < 18 {
+		return 0
+	}
+
+	// 20 bits of sample rate, then channels and bits per sample,
+	// then 36 bits of total samples
+	sampleRate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+	samples := int64(block[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(block[14:]))
+
+	if sampleRate == 0 {
+		return 0
+	}
+
+	return samples * 1000 / sampleRate
+}
+
+// readVorbisComments for the fields the library cares about.
+// Comments are little endian, unlike the rest of the flac.
+func readVorbisComments(block []byte, t *tags) {
+	// read a length prefixed string, ok is false if it runs off the end
+	pos := 0
+	next := func() (string, bool) {
+		if pos+4 > len(block) {
+			return "", false
+		}
+
+		n := int(binary.LittleEndian.Uint32(block[pos:]))
+		pos += 4
+		if n < 0 || pos+n > len(block) {
+			return "", false
+		}
+
+		s := string(block[pos : pos+n])
+		pos += n
+		return s, true
+	}
+
+	// vendor
+	if _, ok := next(); !ok || pos+4 > len(block) {
+		return
+	}
+
+	count := int(binary.LittleEndian.Uint32(block[pos:]))
+	pos += 4
+
+	for i := 0; i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			return
+		}
+
+		eq := strings.IndexByte(comment, '=')
+		if eq < 0 {
+			continue
+		}
+
+		// only the first of repeated fields is kept
+		value := comment[eq+1:]
+		switch strings.ToUpper(comment[:eq]) {
+		case "TITLE":
+			if t.title == "" {
+				t.title = value
+			}
+		case "ARTIST":
+			if t.artist == "" {
+				t.artist = value
+			}
+		case "ALBUM":
+			if t.album == "" {
+				t.album = value
+			}
+		}
+	}
+}
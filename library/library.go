@@ -0,0 +1,332 @@
+// Package library indexes the music under a local directory so parties
+// hosted from a laptop can play from it. A Library is an exclusive
+// party.Catalog: parties using one only accept songs in the library.
+package library
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// consts for the library
+const (
+	// bump when the index format changes, old indexes are rebuilt
+	indexVersion = 1
+
+	// source in the SongInfo of library songs
+	librarySource = "local"
+)
+
+// Library is a catalog of the audio files under a directory.
+// The files' tags are kept in an index on disk, so only new and changed
+// files are read when the library is scanned again.
+type Library struct {
+	root      string
+	indexPath string
+
+	// by id and by path relative to root
+	songs map[party.SongUID]entry
+	files map[string]entry
+	mux   *sync.RWMutex
+
+	// one scan at a time
+	scanMux *sync.Mutex
+}
+
+// entry is an indexed file.
+// A file is read again when its size or modification time changes.
+type entry struct {
+	Path    string         `json:"path"`
+	Size    int64          `json:"size"`
+	ModTime int64          `json:"modTime"`
+	Info    party.SongInfo `json:"info"`
+}
+
+// index is what's saved to disk
+type index struct {
+	Version int     `json:"version"`
+	Files   []entry `json:"files"`
+}
+
+// ScanResult counts what a scan found
+type ScanResult struct {
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Removed   int `json:"removed"`
+	Unchanged int `json:"unchanged"`
+
+	// files that couldn't be read
+	Skipped int `json:"skipped"`
+}
+
+// Changed checks if the scan changed the index
+func (sr ScanResult) Changed() bool {
+	return sr.Added > 0 || sr.Updated > 0 || sr.Removed > 0
+}
+
+// Open the library of the music under root, with its index at indexPath.
+// The saved index is loaded then root is scanned for changes.
+func Open(root string, indexPath string) (*Library, error) {
+	l := &Library{
+		root:      root,
+		indexPath: indexPath,
+		songs:     make(map[party.SongUID]entry),
+		files:     make(map[string]entry),
+		mux:       &sync.RWMutex{},
+		scanMux:   &sync.Mutex{},
+	}
+
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	if _, err := l.Scan(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// load the saved index, a missing or outdated index starts empty
+func (l *Library) load() error {
+	raw, err := ioutil.ReadFile(l.indexPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var saved index
+	if err = json.Unmarshal(raw, &saved); err != nil {
+		return fmt.Errorf("bad library index %s: %s", l.indexPath, err.Error())
+	}
+
+	if saved.Version != indexVersion {
+		return nil
+	}
+
+	l.set(saved.Files)
+	return nil
+}
+
+// set the indexed files
+func (l *Library) set(entries []entry) {
+	songs := make(map[party.SongUID]entry, len(entries))
+	files := make(map[string]entry, len(entries))
+	for _, e := range entries {
+		songs[e.Info.ID] = e
+		files[e.Path] = e
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.songs = songs
+	l.files = files
+}
+
+// Scan root for new, changed and removed files, then save the index.
+// Files that can't be read are skipped and logged.
+func (l *Library) Scan() (ScanResult, error) {
+	l.scanMux.Lock()
+	defer l.scanMux.Unlock()
+
+	// the maps are replaced, never changed, so old stays good after unlocking
+	l.mux.RLock()
+	old := l.files
+	l.mux.RUnlock()
+
+	var result ScanResult
+	entries := make([]entry, 0, len(old))
+	seen := make(map[string]struct{}, len(old))
+
+	err := filepath.Walk(l.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// the library is still good without a directory it can't read
+			if path == l.root {
+				return err
+			}
+
+			log.Printf("library: skipping %s: %s", path, err.Error())
+			return nil
+		}
+
+		if info.IsDir() || !supported(path) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		modTime := info.ModTime().UnixNano()
+
+		prev, has := old[rel]
+		if has && prev.Size == info.Size() && prev.ModTime == modTime {
+			entries = append(entries, prev)
+			seen[rel] = struct{}{}
+			result.Unchanged++
+			return nil
+		}
+
+		t, err := readFile(path)
+		if err != nil {
+			log.Printf("library: skipping %s: %s", rel, err.Error())
+			result.Skipped++
+			return nil
+		}
+
+		entries = append(entries, newEntry(rel, info.Size(), modTime, t))
+		seen[rel] = struct{}{}
+		if has {
+			result.Updated++
+		} else {
+			result.Added++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return result, err
+	}
+
+	for rel := range old {
+		if _, has := seen[rel]; !has {
+			result.Removed++
+		}
+	}
+
+	l.set(entries)
+
+	// always save the first scan so the index exists
+	if _, err = os.Stat(l.indexPath); result.Changed() || os.IsNotExist(err) {
+		if err = l.save(entries); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// ScanEvery period in the background, so the library keeps up with the disk.
+// onChange is called after scans that change the library, it may be nil.
+func (l *Library) ScanEvery(period time.Duration, onChange func()) {
+	go func(l *Library) {
+		ticker := time.NewTicker(period)
+		for _ = range ticker.C {
+			result, err := l.Scan()
+			if err != nil {
+				log.Printf("library: scan failed: %s", err.Error())
+			} else if result.Changed() && onChange != nil {
+				onChange()
+			}
+		}
+	}(l)
+}
+
+// save the index.
+// Writes to a temp file then renames so a crash never leaves a partial index.
+func (l *Library) save(entries []entry) error {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	raw, err := json.Marshal(index{Version: indexVersion, Files: entries})
+	if err != nil {
+		return err
+	}
+
+	tmp := l.indexPath + ".tmp"
+	if err = ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, l.indexPath)
+}
+
+// Lookup a song in the library
+func (l *Library) Lookup(sid party.SongUID) (party.SongInfo, error) {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+
+	e, has := l.songs[sid]
+	if !has {
+		return party.SongInfo{}, fmt.Errorf("song %s not in library", sid)
+	}
+
+	return e.Info, nil
+}
+
+// Exclusive is always true, only songs in the library can be played
+func (l *Library) Exclusive() bool {
+	return true
+}
+
+// Songs in the library, sorted by id
+func (l *Library) Songs() []party.SongInfo {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+
+	songs := make([]party.SongInfo, 0, len(l.songs))
+	for _, e := range l.songs {
+		songs = append(songs, e.Info)
+	}
+
+	sort.Slice(songs, func(i, j int) bool {
+		return songs[i].ID < songs[j].ID
+	})
+
+	return songs
+}
+
+// Len is how many songs are in the library
+func (l *Library) Len() int {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+
+	return len(l.songs)
+}
+
+// newEntry for a file at rel that was just read
+func newEntry(rel string, size int64, modTime int64, t tags) entry {
+	title := t.title
+	if title == "" {
+		base := filepath.Base(filepath.FromSlash(rel))
+		title = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+
+	return entry{
+		Path:    rel,
+		Size:    size,
+		ModTime: modTime,
+		Info: party.SongInfo{
+			ID:         songID(rel),
+			Title:      title,
+			Artist:     t.artist,
+			Album:      t.album,
+			DurationMs: t.durationMs,
+			Source:     librarySource,
+		},
+	}
+}
+
+// songID is stable for a path in the library, so ids survive rescans
+// and restarts, and retagging a file doesn't change it.
+func songID(rel string) party.SongUID {
+	sum := sha1.Sum([]byte(rel))
+	return party.SongUID(hex.EncodeToString(sum[:10]))
+}
@@ -0,0 +1,258 @@
+package library_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/me-next/menext-backend/library"
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mp3Audio is a 128kbps 44.1kHz frame header followed by a second of nothing
+func mp3Audio() []byte {
+	audio := make([]byte, 16000)
+	copy(audio, []byte{0xFF, 0xFB, 0x90, 0x64})
+	return audio
+}
+
+// id3Frame in the v2.3 layout
+func id3Frame(id string, encoding byte, text []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(id)
+	binary.Write(&buf, binary.BigEndian, uint32(len(text)+1))
+	buf.Write([]byte{0, 0, encoding})
+	buf.Write(text)
+	return buf.Bytes()
+}
+
+// makeMP3 with an ID3v2.3 tag in each of the text encodings
+func makeMP3(title, artist, album string) []byte {
+	// utf-16 with a byte order mark
+	utf16 := []byte{0xFF, 0xFE}
+	for _, r := range artist {
+		utf16 = append(utf16, byte(r), 0)
+	}
+
+	var frames bytes.Buffer
+	frames.Write(id3Frame("TIT2", 3, []byte(title)))
+	frames.Write(id3Frame("TPE1", 1, utf16))
+	frames.Write(id3Frame("TALB", 0, []byte(album)))
+
+	// padding
+	frames.Write(make([]byte, 32))
+
+	size := frames.Len()
+	header := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+
+	return append(append(header, frames.Bytes()...), mp3Audio()...)
+}
+
+// makeFLAC that runs for seconds
+func makeFLAC(title, artist string, seconds int) []byte {
+	const sampleRate = 44100
+
+	var buf bytes.Buffer
+	buf.WriteString("fLaC")
+
+	info := make([]byte, 34)
+	info[10] = byte(sampleRate >> 12)
+	info[11] = byte(sampleRate >> 4 & 0xFF)
+	info[12] = byte(sampleRate&0x0F)<<4 | 0x02
+	binary.BigEndian.PutUint32(info[14:], uint32(sampleRate*seconds))
+	buf.Write([]byte{0, 0, 0, byte(len(info))})
+	buf.Write(info)
+
+	var comments bytes.Buffer
+	writeString := func(s string) {
+		binary.Write(&comments, binary.LittleEndian, uint32(len(s)))
+		comments.WriteString(s)
+	}
+
+	writeString("test")
+	binary.Write(&comments, binary.LittleEndian, uint32(2))
+	writeString("title=" + title)
+	writeString("ARTIST=" + artist)
+
+	size := comments.Len()
+	buf.Write([]byte{0x80 | 4, byte(size >> 16), byte(size >> 8), byte(size)})
+	buf.Write(comments.Bytes())
+
+	return buf.Bytes()
+}
+
+// atom with body
+func atom(atomType string, body ...[]byte) []byte {
+	joined := bytes.Join(body, nil)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(joined)+8))
+	buf.WriteString(atomType)
+	buf.Write(joined)
+	return buf.Bytes()
+}
+
+// makeM4A with an ilst that runs for ms
+func makeM4A(title, album string, ms uint32) []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], ms)
+
+	item := func(value string) []byte {
+		return atom("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte(value))
+	}
+
+	return bytes.Join([][]byte{
+		atom("ftyp", []byte("M4A "), make([]byte, 4)),
+		atom("moov",
+			atom("mvhd", mvhd),
+			atom("udta",
+				atom("meta", make([]byte, 4),
+					atom("hdlr", make([]byte, 25)),
+					atom("ilst",
+						atom("\xa9nam", item(title)),
+						atom("\xa9alb", item(album)))))),
+		atom("mdat", make([]byte, 64)),
+	}, nil)
+}
+
+// songsByTitle in a library
+func songsByTitle(lib *library.Library) map[string]party.SongInfo {
+	songs := make(map[string]party.SongInfo)
+	for _, info := range lib.Songs() {
+		songs[info.Title] = info
+	}
+
+	return songs
+}
+
+func TestLibraryTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "library")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "music")
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "toto"), 0755))
+
+	write := func(name string, raw []byte) {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(root, name), raw, 0644))
+	}
+
+	write("toto/africa.mp3", makeMP3("Africa", "Toto", "Toto IV"))
+	write("rosanna.flac", makeFLAC("Rosanna", "Toto", 2))
+	write("hold the line.m4a", makeM4A("Hold the Line", "Toto", 3000))
+	write("untitled.MP3", mp3Audio())
+	write("broken.mp3", []byte("not really an mp3"))
+	write("notes.txt", []byte("not music"))
+
+	lib, err := library.Open(root, filepath.Join(dir, "library.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, lib.Len())
+
+	songs := songsByTitle(lib)
+
+	africa := songs["Africa"]
+	assert.Equal(t, "Toto", africa.Artist)
+	assert.Equal(t, "Toto IV", africa.Album)
+	assert.EqualValues(t, 1000, africa.DurationMs)
+	assert.Equal(t, "local", africa.Source)
+
+	rosanna := songs["Rosanna"]
+	assert.Equal(t, "Toto", rosanna.Artist)
+	assert.EqualValues(t, 2000, rosanna.DurationMs)
+
+	hold := songs["Hold the Line"]
+	assert.Equal(t, "Toto", hold.Album)
+	assert.EqualValues(t, 3000, hold.DurationMs)
+
+	// files without tags are named after the file
+	assert.Contains(t, songs, "untitled")
+
+	info, err := lib.Lookup(africa.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, africa, info)
+
+	_, err = lib.Lookup("nope")
+	assert.NotNil(t, err)
+}
+
+func TestLibraryRescan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "library")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "music")
+	assert.Nil(t, os.MkdirAll(root, 0755))
+	index := filepath.Join(dir, "library.json")
+
+	write := func(name string, raw []byte, modTime time.Time) {
+		path := filepath.Join(root, name)
+		assert.Nil(t, ioutil.WriteFile(path, raw, 0644))
+		assert.Nil(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	then := time.Now().Add(-time.Hour)
+	write("a.mp3", makeMP3("Africa", "Toto", "Toto IV"), then)
+	write("r.flac", makeFLAC("Rosanna", "Toto", 2), then)
+
+	lib, err := library.Open(root, index)
+	assert.Nil(t, err)
+	before := songsByTitle(lib)
+
+	result, err := lib.Scan()
+	assert.Nil(t, err)
+	assert.Equal(t, library.ScanResult{Unchanged: 2}, result)
+
+	// only changed files are read again
+	write("r.flac", makeFLAC("Rosanna (Live)", "Toto", 2), time.Now())
+	assert.Nil(t, os.Remove(filepath.Join(root, "a.mp3")))
+	write("h.m4a", makeM4A("Hold the Line", "Toto", 3000), then)
+
+	result, err = lib.Scan()
+	assert.Nil(t, err)
+	assert.Equal(t, library.ScanResult{Added: 1, Updated: 1, Removed: 1}, result)
+
+	// retagging keeps the id
+	after := songsByTitle(lib)
+	assert.Equal(t, before["Rosanna"].ID, after["Rosanna (Live)"].ID)
+
+	_, err = lib.Lookup(before["Africa"].ID)
+	assert.NotNil(t, err)
+
+	// reopening starts from the index
+	reopened, err := library.Open(root, index)
+	assert.Nil(t, err)
+	assert.Equal(t, lib.Songs(), reopened.Songs())
+
+	result, err = reopened.Scan()
+	assert.Nil(t, err)
+	assert.Equal(t, library.ScanResult{Unchanged: 2}, result)
+}
+
+func TestLibraryCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "library")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "a.mp3"), makeMP3("Africa", "Toto", "Toto IV"), 0644))
+
+	lib, err := library.Open(dir, filepath.Join(dir, "library.json"))
+	assert.Nil(t, err)
+	sid := lib.Songs()[0].ID
+
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+	p.SetCatalog(lib)
+
+	// only songs in the library can be queued
+	assert.NotNil(t, p.Suggest(ouid, "nope"))
+	assert.NotNil(t, p.PlayNext(ouid, "nope"))
+	assert.NotNil(t, p.AddTopPlayNext(ouid, "nope"))
+	assert.NotNil(t, p.PlayNow(ouid, "nope"))
+	assert.Nil(t, p.Suggest(ouid, sid))
+}
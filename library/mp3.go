@@ -0,0 +1,255 @@
+package library
+
+// this file reads ID3v2 tags and the duration of mp3s
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// consts for mp3s
+const (
+	id3HeaderSize = 10
+
+	// how far past the tag to look for the first mpeg frame
+	mp3SyncWindow = 64 * 1024
+)
+
+// maps can't be const in go
+var (
+	// layer III bitrates in kbps, by bitrate index
+	mpeg1Bitrates = []int64{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+	mpeg2Bitrates = []int64{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+
+	// sample rates by version bits then sample rate index
+	mpegSampleRates = map[byte][]int64{
+		3: {44100, 48000, 32000}, // MPEG 1
+		2: {22050, 24000, 16000}, // MPEG 2
+		0: {11025, 12000, 8000},  // MPEG 2.5
+	}
+)
+
+// readMP3 reads the ID3v2 tag, if there is one, then works out the
+// duration from TLEN or the first mpeg frame.
+func readMP3(f io.ReadSeeker, size int64) (tags, error) {
+	var t tags
+
+	header, err := readAt(f, 0, id3HeaderSize)
+	if err != nil {
+		return t, err
+	}
+
+	audioStart := int64(0)
+	if string(header[:3]) == "ID3" {
+		major := header[3]
+		flags := header[5]
+		tagSize := int64(syncsafe(header[6:10]))
+
+		if id3HeaderSize+tagSize > size {
+			return t, fmt.Errorf("truncated id3 tag")
+		}
+
+		body, err := readAt(f, id3HeaderSize, int(tagSize))
+		if err != nil {
+			return t, err
+		}
+
+		// v2.4 unsynchronizes each frame instead, which is rare enough to ignore
+		if flags&0x80 != 0 && major < 4 {
+			body = bytes.Replace(body, []byte{0xFF, 0x00}, []byte{0xFF}, -1)
+		}
+
+		t = readID3Frames(major, flags, body)
+
+		audioStart = id3HeaderSize + tagSize
+		if flags&0x10 != 0 {
+			// footer
+			audioStart += id3HeaderSize
+		}
+	}
+
+	if t.durationMs == 0 {
+		t.durationMs, err = mp3Duration(f, audioStart, size)
+		if err != nil {
+			return t, err
+		}
+	}
+
+	return t, nil
+}
+
+// readID3Frames for the text frames in an ID3v2.2, 2.3 or 2.4 tag
+func readID3Frames(major byte, flags byte, body []byte) tags {
+	var t tags
+
+	idSize, headerSize := 4, 10
+	if major == 2 {
+		idSize, headerSize = 3, 6
+	}
+
+	pos := 0
+	if flags&0x40 != 0 && len(body) >= 4 {
+		// skip the extended header
+		switch major {
+		case 3:
+			pos = 4 + int(binary.BigEndian.Uint32(body))
+		case 4:
+			pos = int(syncsafe(body[:4]))
+		}
+	}
+
+	for pos >= 0 && pos+headerSize <= len(body) {
+		id := string(body[pos : pos+idSize])
+		if id[0] == 0 {
+			// padding
+			break
+		}
+
+		var size int
+		switch major {
+		case 2:
+			size = int(body[pos+3])<<16 | int(body[pos+4])<<8 | int(body[pos+5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(body[pos+4:]))
+		default:
+			size = int(syncsafe(body[pos+4 : pos+8]))
+		}
+
+		pos += headerSize
+		if size < 0 || pos+size > len(body) {
+			break
+		}
+
+		frame := body[pos : pos+size]
+		pos += size
+
+		switch id {
+		case "TIT2", "TT2":
+			t.title = id3Text(frame)
+		case "TPE1", "TP1":
+			t.artist = id3Text(frame)
+		case "TALB", "TAL":
+			t.album = id3Text(frame)
+		case "TLEN", "TLE":
+			if ms, err := strconv.ParseInt(strings.TrimSpace(id3Text(frame)), 10, 64); err == nil {
+				t.durationMs = ms
+			}
+		}
+	}
+
+	return t
+}
+
+// id3Text decodes a text frame, only the first value is kept
+func id3Text(frame []byte) string {
+	if len(frame) < 1 {
+		return ""
+	}
+
+	encoding, text := frame[0], frame[1:]
+	switch encoding {
+	case 0:
+		return latin1(text)
+	case 1:
+		return decodeUTF16(text, false)
+	case 2:
+		return decodeUTF16(text, true)
+	default:
+		return cString(text)
+	}
+}
+
+// syncsafe ints only use the low 7 bits of each byte
+func syncsafe(b []byte) uint32 {
+	var n uint32
+	for _, c := range b {
+		n = n<<7 | uint32(c&0x7F)
+	}
+
+	return n
+}
+
+// mp3Duration from the first layer III frame after start.
+// VBR files say how many frames they have in a Xing or VBRI header,
+// otherwise the bitrate is assumed to be constant.
+func mp3Duration(f io.ReadSeeker, start int64, size int64) (int64, error) {
+	window := size - start
+	if window > mp3SyncWindow {
+		window = mp3SyncWindow
+	}
+
+	if window < 4 {
+		return 0, fmt.Errorf("no mpeg audio")
+	}
+
+	buf, err := readAt(f, start, int(window))
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xFF || buf[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+
+		version := (buf[i+1] >> 3) & 0x03
+		layer := (buf[i+1] >> 1) & 0x03
+		bitrateIndex := buf[i+2] >> 4
+		rateIndex := (buf[i+2] >> 2) & 0x03
+		mono := buf[i+3]>>6 == 3
+
+		rates, valid := mpegSampleRates[version]
+		if !valid || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue
+		}
+
+		sampleRate := rates[rateIndex]
+		bitrate := mpeg2Bitrates[bitrateIndex]
+		samplesPerFrame := int64(576)
+		sideInfo := 17
+		if mono {
+			sideInfo = 9
+		}
+
+		if version == 3 {
+			bitrate = mpeg1Bitrates[bitrateIndex]
+			samplesPerFrame = 1152
+			sideInfo = 32
+			if mono {
+				sideInfo = 17
+			}
+		}
+
+		if frames, has := vbrFrames(buf[i:], 4+sideInfo); has {
+			return frames * samplesPerFrame * 1000 / sampleRate, nil
+		}
+
+		// bitrate is in kbps, so bits per ms
+		return (size - start - int64(i)) * 8 / bitrate, nil
+	}
+
+	return 0, fmt.Errorf("no mpeg audio")
+}
+
+// vbrFrames from a Xing or VBRI header in the first frame
+func vbrFrames(frame []byte, xingOffset int) (int64, bool) {
+	if len(frame) >= xingOffset+12 {
+		tag := string(frame[xingOffset : xingOffset+4])
+		xingFlags := binary.BigEndian.Uint32(frame[xingOffset+4:])
+		if (tag == "Xing" || tag == "Info") && xingFlags&0x01 != 0 {
+			return int64(binary.BigEndian.Uint32(frame[xingOffset+8:])), true
+		}
+	}
+
+	// VBRI always comes 32 bytes after the frame header
+	const vbriOffset = 36
+	if len(frame) >= vbriOffset+18 && string(frame[vbriOffset:vbriOffset+4]) == "VBRI" {
+		return int64(binary.BigEndian.Uint32(frame[vbriOffset+14:])), true
+	}
+
+	return 0, false
+}
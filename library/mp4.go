@@ -0,0 +1,169 @@
+package library
+
+// this file reads iTunes style tags and the duration of m4as
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// mp4s nest atoms, these are the ones on the way to the tags
+const mp4MaxDepth = 8
+
+// mp4Reader walks the atoms in an mp4 file
+type mp4Reader struct {
+	f io.ReadSeeker
+	t tags
+}
+
+// readMP4 walks moov for the duration and the ilst tags
+func readMP4(f io.ReadSeeker, size int64) (tags, error) {
+	header, err := readAt(f, 0, 8)
+	if err != nil {
+		return tags{}, err
+	}
+
+	if string(header[4:8]) != "ftyp" {
+		return tags{}, fmt.Errorf("not an mp4")
+	}
+
+	r := mp4Reader{f: f}
+	if err = r.walk(0, size, 0); err != nil {
+		return r.t, err
+	}
+
+	return r.t, nil
+}
+
+// walk the atoms in [start, end)
+func (r *mp4Reader) walk(start int64, end int64, depth int) error {
+	if depth > mp4MaxDepth {
+		return fmt.Errorf("mp4 atoms nested too deep")
+	}
+
+	pos := start
+	for pos+8 <= end {
+		header, err := readAt(r.f, pos, 8)
+		if err != nil {
+			return err
+		}
+
+		atomSize := int64(binary.BigEndian.Uint32(header))
+		atomType := string(header[4:8])
+		headerSize := int64(8)
+
+		switch atomSize {
+		case 0:
+			// runs to the end
+			atomSize = end - pos
+		case 1:
+			large, err := readAt(r.f, pos+8, 8)
+			if err != nil {
+				return err
+			}
+
+			atomSize = int64(binary.BigEndian.Uint64(large))
+			headerSize = 16
+		}
+
+		if atomSize < headerSize || pos+atomSize > end {
+			return fmt.Errorf("bad mp4 atom %q", atomType)
+		}
+
+		body := pos + headerSize
+		if err = r.atom(atomType, body, pos+atomSize, depth); err != nil {
+			return err
+		}
+
+		pos += atomSize
+	}
+
+	return nil
+}
+
+// atom handles one atom whose body is [start, end)
+func (r *mp4Reader) atom(atomType string, start int64, end int64, depth int) error {
+	switch atomType {
+	case "moov", "udta", "ilst":
+		return r.walk(start, end, depth+1)
+	case "meta":
+		// usually a full atom with 4 bytes of version and flags,
+		// but quicktime files go straight to the children
+		peek, err := readAt(r.f, start, 8)
+		if err != nil {
+			return err
+		}
+
+		if string(peek[4:8]) != "hdlr" {
+			start += 4
+		}
+
+		return r.walk(start, end, depth+1)
+	case "mvhd":
+		return r.readMovieHeader(start, end)
+	case "\xa9nam":
+		return r.readItem(start, end, &r.t.title)
+	case "\xa9ART":
+		return r.readItem(start, end, &r.t.artist)
+	case "\xa9alb":
+		return r.readItem(start, end, &r.t.album)
+	}
+
+	return nil
+}
+
+// readMovieHeader for the duration
+func (r *mp4Reader) readMovieHeader(start int64, end int64) error {
+	if end-start < 32 {
+		return fmt.Errorf("short mvhd")
+	}
+
+	b, err := readAt(r.f, start, 32)
+	if err != nil {
+		return err
+	}
+
+	var timescale, duration int64
+	if b[0] == 1 {
+		// 64 bit times
+		timescale = int64(binary.BigEndian.Uint32(b[20:]))
+		duration = int64(binary.BigEndian.Uint64(b[24:]))
+	} else {
+		timescale = int64(binary.BigEndian.Uint32(b[12:]))
+		duration = int64(binary.BigEndian.Uint32(b[16:]))
+	}
+
+	if timescale > 0 {
+		r.t.durationMs = duration * 1000 / timescale
+	}
+
+	return nil
+}
+
+// readItem reads the text in an ilst item's data atom
+func (r *mp4Reader) readItem(start int64, end int64, value *string) error {
+	// data atom header, then 4 bytes of type and 4 of locale
+	const dataHeaderSize = 16
+
+	if end-start <= dataHeaderSize {
+		return nil
+	}
+
+	b, err := readAt(r.f, start, int(end-start))
+	if err != nil {
+		return err
+	}
+
+	if string(b[4:8]) != "data" {
+		return nil
+	}
+
+	dataSize := int(binary.BigEndian.Uint32(b))
+	if dataSize < dataHeaderSize || dataSize > len(b) {
+		return nil
+	}
+
+	*value = string(b[dataHeaderSize:dataSize])
+	return nil
+}
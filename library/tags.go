@@ -0,0 +1,131 @@
+package library
+
+// this file reads the tags the library cares about out of audio files
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// tags read from an audio file. Anything missing is left empty.
+type tags struct {
+	title      string
+	artist     string
+	album      string
+	durationMs int64
+}
+
+// tagReader reads tags from a file of size bytes
+type tagReader func(f io.ReadSeeker, size int64) (tags, error)
+
+// maps can't be const in go
+var (
+	// readers for each supported extension
+	tagReaders = map[string]tagReader{
+		".mp3":  readMP3,
+		".flac": readFLAC,
+		".m4a":  readMP4,
+	}
+)
+
+// supported checks if the library indexes a file
+func supported(path string) bool {
+	_, has := tagReaders[strings.ToLower(filepath.Ext(path))]
+	return has
+}
+
+// readFile tags
+func readFile(path string) (tags, error) {
+	reader, has := tagReaders[strings.ToLower(filepath.Ext(path))]
+	if !has {
+		return tags{}, fmt.Errorf("unsupported file type")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return tags{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return tags{}, err
+	}
+
+	return reader(f, info.Size())
+}
+
+// readAt reads exactly n bytes at pos
+func readAt(f io.ReadSeeker, pos int64, n int) ([]byte, error) {
+	if _, err := f.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// cString cuts b at the first null
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+
+	return string(b)
+}
+
+// latin1 decodes ISO-8859-1, which maps straight to the first 256 runes
+func latin1(b []byte) string {
+	runes := make([]rune, 0, len(b))
+	for _, c := range b {
+		if c == 0 {
+			break
+		}
+
+		runes = append(runes, rune(c))
+	}
+
+	return string(runes)
+}
+
+// decodeUTF16 up to the first null. A byte order mark overrides bigEndian.
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		if b[0] == 0xFF && b[1] == 0xFE {
+			bigEndian = false
+			b = b[2:]
+		} else if b[0] == 0xFE && b[1] == 0xFF {
+			bigEndian = true
+			b = b[2:]
+		}
+	}
+
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		var unit uint16
+		if bigEndian {
+			unit = binary.BigEndian.Uint16(b[i:])
+		} else {
+			unit = binary.LittleEndian.Uint16(b[i:])
+		}
+
+		if unit == 0 {
+			break
+		}
+
+		units = append(units, unit)
+	}
+
+	return string(utf16.Decode(units))
+}
@@ -1,13 +1,76 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"github.com/me-next/menext-backend/library"
+	"github.com/me-next/menext-backend/party"
+	"github.com/me-next/menext-backend/search"
 	"github.com/me-next/menext-backend/server"
+	"os"
+	"path/filepath"
+	"time"
 )
 
 func main() {
+	dataDir := flag.String("data", "data", "directory parties are saved to")
+	ownerTimeout := flag.Duration("owner-timeout", 5*time.Minute, "how long an owner can be silent before someone else takes over, 0 to never")
+	catalogPath := flag.String("catalog", "", "json or csv file of songs to search, empty for none")
+	libraryDir := flag.String("library", "", "directory of music to play from, only its songs can be suggested")
+	libraryRescan := flag.Duration("library-rescan", 5*time.Minute, "how often the library is scanned for changes")
+	flag.Parse()
+
 	fmt.Println("hello world")
-	s := server.New()
+
+	if *catalogPath != "" && *libraryDir != "" {
+		panic("use either -catalog or -library, not both")
+	}
+
+	// parties are restored with the catalog, so it's loaded first
+	var catalog party.Catalog
+	var idx *search.Index
+
+	if *catalogPath != "" {
+		var err error
+		idx, err = search.LoadFile(*catalogPath)
+		if err != nil {
+			panic(err)
+		}
+
+		catalog = idx
+	}
+
+	if *libraryDir != "" {
+		if err := os.MkdirAll(*dataDir, 0755); err != nil {
+			panic(err)
+		}
+
+		lib, err := library.Open(*libraryDir, filepath.Join(*dataDir, "library.index"))
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("library has %d songs\n", lib.Len())
+
+		// search keeps up with the library
+		idx = search.New(lib.Songs())
+		lib.ScanEvery(*libraryRescan, func() {
+			idx.Replace(lib.Songs())
+		})
+
+		catalog = lib
+	}
+
+	s, err := server.NewWithDataDir(*dataDir, catalog)
+	if err != nil {
+		panic(err)
+	}
+
+	s.SetOwnerTimeout(*ownerTimeout)
+
+	if idx != nil {
+		s.SetSearchIndex(idx)
+	}
 
 	// TODO: maybe handle this error better...
 	panic(s.Start(":8080"))
@@ -0,0 +1,254 @@
+package party
+
+// this file contains song metadata and where it comes from
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// SongInfo is the metadata for a song.
+// Clients show this instead of resolving the SongUID themselves.
+type SongInfo struct {
+	ID         SongUID `json:"id"`
+	Title      string  `json:"title"`
+	Artist     string  `json:"artist"`
+	Album      string  `json:"album,omitempty"`
+	DurationMs int64   `json:"durationMs"`
+	ArtworkURL string  `json:"artworkUrl,omitempty"`
+
+	// where the song is played from, e.g. "spotify" or "local"
+	Source string `json:"source"`
+}
+
+// Catalog looks up song metadata.
+// Parties consult their catalog when a song is suggested or played.
+// Lookups happen while the party is locked, so they should be quick.
+type Catalog interface {
+	Lookup(sid SongUID) (SongInfo, error)
+}
+
+// ExclusiveCatalog is a Catalog that has every song a party can play,
+// like a local music library. Parties using one reject songs it doesn't have.
+type ExclusiveCatalog interface {
+	Catalog
+	Exclusive() bool
+}
+
+// isExclusive checks if a catalog rejects songs it doesn't have
+func isExclusive(catalog Catalog) bool {
+	exclusive, ok := catalog.(ExclusiveCatalog)
+	return ok && exclusive.Exclusive()
+}
+
+// ListingCatalog is a Catalog that can list all of its songs,
+// so the radio can pick from it.
+type ListingCatalog interface {
+	Catalog
+	Songs() []SongInfo
+}
+
+// MemoryCatalog is a Catalog kept in memory. It's safe to share between parties.
+type MemoryCatalog struct {
+	songs map[SongUID]SongInfo
+	mux   *sync.RWMutex
+}
+
+// NewMemoryCatalog with songs
+func NewMemoryCatalog(songs ...SongInfo) *MemoryCatalog {
+	c := &MemoryCatalog{
+		songs: make(map[SongUID]SongInfo, len(songs)),
+		mux:   &sync.RWMutex{},
+	}
+
+	for _, info := range songs {
+		c.songs[info.ID] = info
+	}
+
+	return c
+}
+
+// LoadCatalogFile reads a json array of SongInfo into a MemoryCatalog
+func LoadCatalogFile(path string) (*MemoryCatalog, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var songs []SongInfo
+	if err = json.Unmarshal(raw, &songs); err != nil {
+		return nil, fmt.Errorf("bad catalog %s: %s", path, err.Error())
+	}
+
+	for _, info := range songs {
+		if info.ID == "" {
+			return nil, fmt.Errorf("bad catalog %s: song without an id", path)
+		}
+	}
+
+	return NewMemoryCatalog(songs...), nil
+}
+
+// Add a song, replacing any song with the same id
+func (c *MemoryCatalog) Add(info SongInfo) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.songs[info.ID] = info
+}
+
+// Lookup a song by id
+func (c *MemoryCatalog) Lookup(sid SongUID) (SongInfo, error) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	info, has := c.songs[sid]
+	if !has {
+		return SongInfo{}, fmt.Errorf("song %s not in catalog", sid)
+	}
+
+	return info, nil
+}
+
+// Songs in the catalog, sorted by id
+func (c *MemoryCatalog) Songs() []SongInfo {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	songs := make([]SongInfo, 0, len(c.songs))
+	for _, info := range c.songs {
+		songs = append(songs, info)
+	}
+
+	sort.Slice(songs, func(i, j int) bool {
+		return songs[i].ID < songs[j].ID
+	})
+
+	return songs
+}
+
+// songCache is the metadata a party already looked up.
+// It's a Catalog so pulls don't go back to the real one.
+type songCache map[SongUID]SongInfo
+
+// Lookup a cached song
+func (sc songCache) Lookup(sid SongUID) (SongInfo, error) {
+	info, has := sc[sid]
+	if !has {
+		return SongInfo{}, fmt.Errorf("no info for song %s", sid)
+	}
+
+	return info, nil
+}
+
+// songData is the pulled form of a song, with metadata if the catalog has it.
+// catalog may be nil.
+func songData(sid SongUID, catalog Catalog) map[string]interface{} {
+	data := map[string]interface{}{"id": sid}
+
+	if catalog != nil {
+		if info, err := catalog.Lookup(sid); err == nil {
+			data["info"] = info
+		}
+	}
+
+	return data
+}
+
+// SetCatalog the party looks songs up in. nil turns lookups off.
+// Songs already in the party are looked up too.
+func (p *Party) SetCatalog(catalog Catalog) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.catalog = catalog
+
+	// songs that are already here stay, even if the catalog doesn't have them
+	for sid := range p.songsInParty() {
+		p.learnSong(sid)
+	}
+
+	if p.nowPlaying.CurrentlyHasSong() {
+		p.nowPlaying.SetDuration(p.songDuration(p.nowPlaying.GetCurrentlyPlaying()))
+	}
+}
+
+// learnSong looks a song up in the catalog if it isn't cached yet.
+// Songs the catalog doesn't know are still allowed, they just have no info,
+// unless the catalog is exclusive.
+func (p *Party) learnSong(sid SongUID) error {
+	if _, has := p.songs[sid]; has || p.catalog == nil {
+		return nil
+	}
+
+	info, err := p.catalog.Lookup(sid)
+	if err != nil {
+		// replayed songs were in the library when they were added
+		if isExclusive(p.catalog) && !p.replaying {
+			return fmt.Errorf("song %s is not in the library", sid)
+		}
+
+		return nil
+	}
+
+	info.ID = sid
+	p.songs[sid] = info
+	return nil
+}
+
+// songsInParty is every song queued, playing or played
+func (p *Party) songsInParty() map[SongUID]struct{} {
+	inParty := make(map[SongUID]struct{})
+	for sid := range p.suggestionQueue.songs {
+		inParty[sid] = struct{}{}
+	}
+
+	for _, sid := range songsFromList(p.playNext.songs) {
+		inParty[sid] = struct{}{}
+	}
+
+	for _, sid := range songsFromList(p.previous.songs) {
+		inParty[sid] = struct{}{}
+	}
+
+	if p.nowPlaying.CurrentlyHasSong() {
+		inParty[p.nowPlaying.GetCurrentlyPlaying()] = struct{}{}
+	}
+
+	for _, sid := range p.radio.songs {
+		inParty[sid] = struct{}{}
+	}
+
+	for _, zone := range p.zones {
+		if !zone.grouped && zone.nowPlaying.CurrentlyHasSong() {
+			inParty[zone.nowPlaying.GetCurrentlyPlaying()] = struct{}{}
+		}
+	}
+
+	for _, sid := range p.vetoedSongs() {
+		inParty[sid] = struct{}{}
+	}
+
+	return inParty
+}
+
+// knownSongs that are still in the party, for snapshots
+func (p *Party) knownSongs() []SongInfo {
+	inParty := p.songsInParty()
+
+	songs := make([]SongInfo, 0, len(inParty))
+	for sid := range inParty {
+		if info, has := p.songs[sid]; has {
+			songs = append(songs, info)
+		}
+	}
+
+	sort.Slice(songs, func(i, j int) bool {
+		return songs[i].ID < songs[j].ID
+	})
+
+	return songs
+}
@@ -0,0 +1,86 @@
+package party_test
+
+import (
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCatalogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "catalog.json")
+	raw := `[{"id": "a", "title": "Africa", "artist": "Toto", "durationMs": 295000, "source": "local"}]`
+	assert.Nil(t, ioutil.WriteFile(path, []byte(raw), 0600))
+
+	c, err := party.LoadCatalogFile(path)
+	assert.Nil(t, err)
+
+	info, err := c.Lookup("a")
+	assert.Nil(t, err)
+	assert.Equal(t, "Africa", info.Title)
+	assert.EqualValues(t, 295000, info.DurationMs)
+
+	_, err = c.Lookup("b")
+	assert.NotNil(t, err)
+
+	// every song needs an id
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`[{"title": "Africa"}]`), 0600))
+	_, err = party.LoadCatalogFile(path)
+	assert.NotNil(t, err)
+}
+
+func TestPartySongInfo(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	c := party.NewMemoryCatalog(
+		party.SongInfo{ID: "a", Title: "Africa", Artist: "Toto"},
+		party.SongInfo{ID: "b", Title: "Bad", Artist: "Michael Jackson"},
+	)
+	p.SetCatalog(c)
+
+	// unknown songs still work, they just have no info
+	assert.Nil(t, p.Suggest(ouid, "a"))
+	assert.Nil(t, p.Suggest(ouid, "b"))
+	assert.Nil(t, p.PlayNext(ouid, "c"))
+
+	songs := func(data map[string]interface{}, section string) []interface{} {
+		return data[section].(map[string]interface{})["songs"].([]interface{})
+	}
+
+	raw, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	data := raw.(map[string]interface{})
+
+	playing := data[party.PullPlayingKey].(map[string]interface{})
+	assert.Equal(t, "Africa", playing[party.KCurrentSongInfo].(party.SongInfo).Title)
+
+	suggest := songs(data, party.PullSuggestKey)[0].(map[string]interface{})
+	assert.Equal(t, "Bad", suggest["info"].(party.SongInfo).Title)
+
+	playNext := songs(data, party.PullPlayNextKey)[0].(map[string]interface{})
+	assert.NotContains(t, playNext, "info")
+
+	// the played song moves to history
+	assert.Nil(t, p.Skip(ouid, "a"))
+	raw, err = p.Pull(ouid, 0)
+	assert.Nil(t, err)
+
+	history := songs(raw.(map[string]interface{}), party.PullHistoryKey)
+	assert.Len(t, history, 1)
+	assert.Equal(t, "Africa", history[0].(map[string]interface{})["info"].(party.SongInfo).Title)
+
+	// info sticks around without the catalog
+	restored := party.Restore(p.Snapshot())
+	raw, err = restored.Pull(ouid, 0)
+	assert.Nil(t, err)
+
+	suggest = songs(raw.(map[string]interface{}), party.PullSuggestKey)[0].(map[string]interface{})
+	assert.Equal(t, "Bad", suggest["info"].(party.SongInfo).Title)
+}
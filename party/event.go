@@ -0,0 +1,341 @@
+package party
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType names a mutation of a party
+type EventType string
+
+// event types, one for each mutating method on the party
+const (
+	EventAddUser             EventType = "addUser"
+	EventJoin                EventType = "join"
+	EventRemoveUser          EventType = "removeUser"
+	EventSetOwner            EventType = "setOwner"
+	EventTransferOwnership   EventType = "transferOwnership"
+	EventSetPermission       EventType = "setPermission"
+	EventSetUserPermission   EventType = "setUserPermission"
+	EventClearUserPermission EventType = "clearUserPermission"
+	EventSetRole             EventType = "setRole"
+	EventKick                EventType = "kick"
+	EventBan                 EventType = "ban"
+	EventUnban               EventType = "unban"
+	EventSetPassword         EventType = "setPassword"
+	EventSetInviteOnly       EventType = "setInviteOnly"
+	EventCreateInvite        EventType = "createInvite"
+	EventRevokeInvite        EventType = "revokeInvite"
+	EventSuggest             EventType = "suggest"
+	EventSuggestionUpvote    EventType = "suggestUp"
+	EventSuggestionDownvote  EventType = "suggestDown"
+	EventSuggestionClearvote EventType = "suggestClearvote"
+	EventPlayNext            EventType = "playNext"
+	EventAddTopPlayNext      EventType = "addTopPlayNext"
+	EventRemoveFromPlayNext  EventType = "removePlayNext"
+	EventPlayNow             EventType = "playNow"
+	EventSeek                EventType = "seek"
+	EventSongFinished        EventType = "songFinished"
+	EventAdvance             EventType = "advance"
+	EventSetSongDuration     EventType = "setSongDuration"
+	EventSkip                EventType = "skip"
+	EventPrevious            EventType = "previous"
+	EventPause               EventType = "pause"
+	EventPlay                EventType = "play"
+	EventSetVolume           EventType = "setVolume"
+	EventAddZone             EventType = "addZone"
+	EventRemoveZone          EventType = "removeZone"
+	EventSetMute             EventType = "setMute"
+	EventGroupZone           EventType = "groupZone"
+	EventUngroupZone         EventType = "ungroupZone"
+	EventSetRadio            EventType = "setRadio"
+	EventPlayRadio           EventType = "playRadio"
+	EventVoteSkip            EventType = "voteSkip"
+	EventSetSkipThreshold    EventType = "setSkipThreshold"
+	EventSetQueueOrder       EventType = "setQueueOrder"
+	EventSetSuggestionLimits EventType = "setSuggestionLimits"
+	EventSetVetoRule         EventType = "setVetoRule"
+	EventSetScoring          EventType = "setScoring"
+)
+
+// Event records a single mutation of a party.
+// Replaying a party's events in Seq order on top of a snapshot
+// taken before the first event rebuilds the party.
+type Event struct {
+	Seq      uint64    `json:"seq"`
+	Type     EventType `json:"type"`
+	Actor    UserUUID  `json:"actor"`
+	Time     time.Time `json:"time"`
+	ChangeID uint64    `json:"changeId"`
+
+	// arguments, which are set depends on the type
+	Song       SongUID  `json:"song,omitempty"`
+	Name       string   `json:"name,omitempty"`
+	Target     UserUUID `json:"target,omitempty"`
+	Role       Role     `json:"role,omitempty"`
+	Permission string   `json:"permission,omitempty"`
+	Value      bool     `json:"value,omitempty"`
+	Position   float32  `json:"position,omitempty"`
+	Volume     uint32   `json:"volume,omitempty"`
+	DurationMs int64    `json:"durationMs,omitempty"`
+
+	// playback events with a zone happened in that zone
+	Zone string `json:"zone,omitempty"`
+
+	// the radio's seed playlist
+	Songs []SongUID `json:"songs,omitempty"`
+
+	// skip votes, Count is the votes needed when a vote was cast
+	Count    int     `json:"count,omitempty"`
+	Fraction float64 `json:"fraction,omitempty"`
+
+	// users active when the skip threshold was checked again
+	Active int `json:"active,omitempty"`
+
+	Limits *SuggestionLimits `json:"limits,omitempty"`
+	Veto   *VetoRule         `json:"veto,omitempty"`
+
+	Scoring *Scoring `json:"scoring,omitempty"`
+
+	// joining, Secret is a password hash
+	Code    string    `json:"code,omitempty"`
+	Uses    int       `json:"uses,omitempty"`
+	Expires time.Time `json:"expires,omitempty"`
+	Secret  string    `json:"secret,omitempty"`
+}
+
+// SetEventHandler is called with every event the party records, to log it.
+// If logging fails, save is called with a snapshot that covers the event
+// so a log replayed on top of a snapshot never has a gap. Until a snapshot
+// is saved no more events are logged, and calls that change the party fail
+// (the change is still made in memory).
+// Both are called while the party is locked, so they must not call back
+// into the party. Events are passed in Seq order.
+func (p *Party) SetEventHandler(handler func(Event) error, save func(Snapshot) error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.onEvent = handler
+	p.onSave = save
+	p.logGap = false
+}
+
+// record an event if the call changed the party.
+// Mutating methods defer this right after locking, passing the changeID
+// from before the change. The event's time defaults to when it's
+// recorded. Calls that fail can still change the party (ie skipping the
+// last song) so those are recorded too.
+func (p *Party) record(err *error, startChangeID uint64, e Event) {
+	if *err != nil && p.changeID == startChangeID {
+		return
+	}
+
+	p.eventSeq++
+
+	// the handler already has the event if we're replaying it
+	if p.replaying || p.onEvent == nil {
+		return
+	}
+
+	e.Seq = p.eventSeq
+	if e.Time.IsZero() {
+		e.Time = p.now()
+	}
+	e.ChangeID = p.changeID
+
+	if !p.logGap {
+		if p.onEvent(e) == nil {
+			return
+		}
+
+		p.logGap = true
+	}
+
+	// the log is missing an event, the snapshot has it
+	if p.onSave != nil {
+		if saveErr := p.onSave(p.snapshot()); saveErr == nil {
+			p.logGap = false
+			return
+		}
+	}
+
+	if *err == nil {
+		*err = fmt.Errorf("failed to save the change")
+	}
+}
+
+// Apply an event to the party. This is used to replay a log on top of a snapshot.
+// Time is rewound to when the event happened so the party ends up in the
+// same state. Apply isn't meant to be used on a party that is in use.
+func (p *Party) Apply(e Event) error {
+	p.mux.Lock()
+	if e.Seq != p.eventSeq+1 {
+		p.mux.Unlock()
+		return fmt.Errorf("event %d out of order, expected %d", e.Seq, p.eventSeq+1)
+	}
+
+	p.replaying = true
+	p.replayTime = e.Time
+	p.mux.Unlock()
+
+	err := p.applyEvent(e)
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.replaying = false
+	p.replayTime = time.Time{}
+
+	// the event was logged so it must have been recorded,
+	// keep the sequence in step even if the event didn't apply cleanly
+	p.eventSeq = e.Seq
+
+	// the originals may have failed after changing the party, only report
+	// errors if we end up somewhere other than the logged state
+	if p.changeID != e.ChangeID {
+		return fmt.Errorf("replaying event %d ended on change %d, expected %d (%v)",
+			e.Seq, p.changeID, e.ChangeID, err)
+	}
+
+	return nil
+}
+
+// calls the method the event was recorded by
+func (p *Party) applyEvent(e Event) error {
+	switch e.Type {
+	case EventAddUser:
+		return p.AddUser(e.Actor, e.Name)
+	case EventJoin:
+		return p.replayJoin(e.Actor, e.Name, e.Code)
+	case EventRemoveUser:
+		return p.removeUser(e.Actor, func() int { return e.Active })
+	case EventSetOwner:
+		return p.SetOwner(e.Actor)
+	case EventTransferOwnership:
+		return p.TransferOwnership(e.Actor, e.Target)
+	case EventSetPermission:
+		return p.SetPermission(e.Permission, e.Value, e.Actor)
+	case EventSetUserPermission:
+		return p.SetUserPermission(e.Actor, e.Target, e.Permission, e.Value)
+	case EventClearUserPermission:
+		return p.ClearUserPermission(e.Actor, e.Target, e.Permission)
+	case EventSetRole:
+		return p.SetRole(e.Actor, e.Target, e.Role)
+	case EventKick:
+		return p.kick(e.Actor, e.Target, func() int { return e.Active })
+	case EventBan:
+		return p.ban(e.Actor, e.Target, func() int { return e.Active })
+	case EventUnban:
+		return p.Unban(e.Actor, e.Target)
+	case EventSetPassword:
+		return p.setPasswordHash(e.Actor, e.Secret)
+	case EventSetInviteOnly:
+		return p.SetInviteOnly(e.Actor, e.Value)
+	case EventCreateInvite:
+		return p.addInvite(e.Actor, e.Code, e.Uses, e.Expires)
+	case EventRevokeInvite:
+		return p.RevokeInvite(e.Actor, e.Code)
+	case EventSuggest:
+		return p.SuggestWithDuration(e.Actor, e.Song, e.duration())
+	case EventSuggestionUpvote:
+		return p.SuggestionUpvote(e.Actor, e.Song)
+	case EventSuggestionDownvote:
+		return p.replaySuggestionDownvote(e.Actor, e.Song, e.Count)
+	case EventSuggestionClearvote:
+		return p.SuggestionClearvote(e.Actor, e.Song)
+	case EventPlayNext:
+		return p.PlayNextWithDuration(e.Actor, e.Song, e.duration())
+	case EventAddTopPlayNext:
+		return p.AddTopPlayNextWithDuration(e.Actor, e.Song, e.duration())
+	case EventRemoveFromPlayNext:
+		return p.RemoveFromPlayNext(e.Actor, e.Song)
+	case EventPlayNow:
+		if e.Zone != "" {
+			return p.ZonePlayNowWithDuration(e.Actor, e.Zone, e.Song, e.duration())
+		}
+		return p.PlayNowWithDuration(e.Actor, e.Song, e.duration())
+	case EventSeek:
+		if e.Zone != "" {
+			return p.ZoneSeek(e.Actor, e.Zone, e.Position)
+		}
+		return p.Seek(e.Actor, e.Position)
+	case EventSongFinished:
+		return p.replaySongFinished(e.Actor, e.Song, e.duration())
+	case EventAdvance:
+		return p.replayAdvance(e.Song, e.duration())
+	case EventSetSongDuration:
+		return p.SetSongDuration(e.Actor, e.Song, e.duration())
+	case EventSkip:
+		return p.Skip(e.Actor, e.Song)
+	case EventPrevious:
+		return p.Previous(e.Actor, e.Song)
+	case EventPause:
+		if e.Zone != "" {
+			return p.ZonePause(e.Actor, e.Zone, e.Position)
+		}
+		return p.Pause(e.Actor, e.Position)
+	case EventPlay:
+		if e.Zone != "" {
+			return p.ZonePlay(e.Actor, e.Zone)
+		}
+		return p.Play(e.Actor)
+	case EventSetVolume:
+		return p.SetVolume(e.Actor, e.Zone, e.Volume)
+	case EventAddZone:
+		return p.AddZone(e.Actor, e.Zone)
+	case EventRemoveZone:
+		return p.RemoveZone(e.Actor, e.Zone)
+	case EventSetMute:
+		return p.SetMute(e.Actor, e.Zone, e.Value)
+	case EventGroupZone:
+		return p.GroupZone(e.Actor, e.Zone)
+	case EventUngroupZone:
+		return p.UngroupZone(e.Actor, e.Zone)
+	case EventSetRadio:
+		return p.SetRadio(e.Actor, RadioMode(e.Name), e.Songs)
+	case EventPlayRadio:
+		return p.playRadio(e.Song)
+	case EventVoteSkip:
+		return p.replayVoteSkip(e.Actor, e.Song, e.Count)
+	case EventSetSkipThreshold:
+		return p.replaySetSkipThreshold(e.Actor, e.Fraction, e.Count, e.Active)
+	case EventSetQueueOrder:
+		return p.SetQueueOrder(e.Actor, QueueOrder(e.Name))
+	case EventSetSuggestionLimits:
+		if e.Limits == nil {
+			return fmt.Errorf("no limits in event %d", e.Seq)
+		}
+		return p.SetSuggestionLimits(e.Actor, *e.Limits)
+	case EventSetVetoRule:
+		if e.Veto == nil {
+			return fmt.Errorf("no veto rule in event %d", e.Seq)
+		}
+		return p.SetVetoRule(e.Actor, *e.Veto)
+	case EventSetScoring:
+		if e.Scoring == nil {
+			return fmt.Errorf("no scoring in event %d", e.Seq)
+		}
+		return p.SetScoring(e.Actor, *e.Scoring)
+	}
+
+	return fmt.Errorf("unknown event type %s", e.Type)
+}
+
+// duration in the event, 0 if it has none
+func (e Event) duration() time.Duration {
+	return time.Duration(e.DurationMs) * time.Millisecond
+}
+
+// now is the party's clock. While replaying, it's the time of the
+// event being replayed.
+func (p *Party) now() time.Time {
+	if p.replaying {
+		return p.replayTime
+	}
+
+	if p.clock != nil {
+		return p.clock()
+	}
+
+	return time.Now()
+}
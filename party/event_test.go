@@ -0,0 +1,137 @@
+package party_test
+
+import (
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// captureEvents p records from now on into events.
+// Returns a snapshot to replay them on top of.
+func captureEvents(p *party.Party, events *[]party.Event) party.Snapshot {
+	base := p.Snapshot()
+	p.SetEventHandler(func(e party.Event) error {
+		*events = append(*events, e)
+		return nil
+	}, nil)
+
+	return base
+}
+
+// replay events on top of base, they all have to apply
+func replay(t *testing.T, base party.Snapshot, events []party.Event) *party.Party {
+	restored := party.Restore(base)
+	for _, e := range events {
+		assert.Nil(t, restored.Apply(e))
+	}
+
+	return restored
+}
+
+func TestEventReplay(t *testing.T) {
+	ouid := party.UserUUID("1")
+	fuid := party.UserUUID("2")
+
+	p := party.New(ouid, "bob")
+	var events []party.Event
+	base := captureEvents(p, &events)
+
+	assert.Nil(t, p.AddUser(fuid, "fred"))
+	assert.Nil(t, p.Suggest(fuid, "a"))
+	assert.Nil(t, p.Suggest(fuid, "b"))
+	assert.Nil(t, p.SuggestionDownvote(ouid, "b"))
+	assert.Nil(t, p.PlayNext(ouid, "c"))
+	assert.Nil(t, p.Seek(ouid, 3))
+	assert.Nil(t, p.SetVolume(fuid, "", 20))
+	assert.Nil(t, p.SetUserPermission(ouid, fuid, party.UserCanSkipPermission, false))
+
+	// failed calls that don't change anything aren't logged
+	assert.NotNil(t, p.Suggest("nobody", "d"))
+	assert.NotNil(t, p.SetPermission(party.UserCanSkipPermission, false, fuid))
+
+	// failed calls that change the party are
+	assert.Nil(t, p.Skip(ouid, "a"))
+	assert.Nil(t, p.Skip(ouid, "c"))
+	assert.NotNil(t, p.Skip(ouid, "b"))
+
+	// failovers replay without heartbeats
+	assert.Nil(t, p.TransferOwnership(ouid, fuid))
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, p.Heartbeat(ouid))
+	_, changed := p.FailoverOwner(5 * time.Millisecond)
+	assert.True(t, changed)
+
+	assert.Len(t, events, 13)
+	for i, e := range events {
+		assert.EqualValues(t, base.EventSeq+uint64(i)+1, e.Seq)
+	}
+	assert.Equal(t, party.EventAddUser, events[0].Type)
+	assert.Equal(t, fuid, events[0].Actor)
+
+	// replay on top of the snapshot
+	restored := replay(t, base, events)
+
+	expected := p.Snapshot()
+	actual := restored.Snapshot()
+	assert.Equal(t, expected.ChangeID, actual.ChangeID)
+	assert.Equal(t, expected.Owner, actual.Owner)
+	assert.Equal(t, expected.EventSeq, actual.EventSeq)
+	assert.Equal(t, expected.Users, actual.Users)
+	assert.Equal(t, expected.Previous, actual.Previous)
+	assert.Equal(t, expected.NowPlaying.Volume, actual.NowPlaying.Volume)
+	assert.Equal(t, expected.NowPlaying.Song, actual.NowPlaying.Song)
+
+	// events must be applied in order
+	assert.NotNil(t, restored.Apply(events[0]))
+}
+
+func TestEventLogFailure(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	failLog, failSave := false, false
+	var events []party.Event
+	var saved []party.Snapshot
+	p.SetEventHandler(func(e party.Event) error {
+		if failLog {
+			return fmt.Errorf("disk full")
+		}
+
+		events = append(events, e)
+		return nil
+	}, func(snap party.Snapshot) error {
+		if failSave {
+			return fmt.Errorf("disk full")
+		}
+
+		saved = append(saved, snap)
+		return nil
+	})
+
+	assert.Nil(t, p.Suggest(ouid, "a"))
+
+	// the snapshot covers what the log missed
+	failLog = true
+	assert.Nil(t, p.Suggest(ouid, "b"))
+	assert.Len(t, saved, 1)
+	assert.Equal(t, p.Snapshot().EventSeq, saved[0].EventSeq)
+
+	// changes fail until something covers them
+	failSave = true
+	assert.NotNil(t, p.Suggest(ouid, "c"))
+
+	failLog, failSave = false, false
+	assert.Nil(t, p.Suggest(ouid, "d"))
+	assert.Len(t, saved, 2)
+	assert.Len(t, events, 1)
+
+	// logging picks back up after the snapshot
+	assert.Nil(t, p.Suggest(ouid, "e"))
+	assert.Len(t, events, 2)
+
+	restored := party.Restore(saved[1])
+	assert.Nil(t, restored.Apply(events[1]))
+	assert.Equal(t, p.Snapshot().Suggestions.AddCounter, restored.Snapshot().Suggestions.AddCounter)
+}
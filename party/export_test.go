@@ -0,0 +1,15 @@
+package party
+
+// exposes internals to the party_test package
+
+import (
+	"time"
+)
+
+// SetClock the party runs on, so tests don't have to sleep
+func (p *Party) SetClock(clock func() time.Time) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.clock = clock
+}
@@ -0,0 +1,385 @@
+package party
+
+// this file contains the rules for joining a party: passwords and invites
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"sort"
+	"time"
+)
+
+// consts for joining
+const (
+	inviteCodeSize = 8
+
+	// wrong passwords a user can try in joinFailureWindow before the party
+	// stops checking theirs, so passwords can't be guessed quickly
+	maxJoinFailures   = 5
+	joinFailureWindow = time.Minute
+)
+
+// Invite lets users into a locked party a limited number of times.
+type Invite struct {
+	Code      string    `json:"code"`
+	Uses      int       `json:"uses"`
+	Expires   time.Time `json:"expires"`
+	CreatedBy UserUUID  `json:"createdBy"`
+}
+
+// expired checks if the invite can't be used anymore
+func (i Invite) expired(now time.Time) bool {
+	return i.Uses <= 0 || !now.Before(i.Expires)
+}
+
+// Join the party. If the party is locked the user needs the password or
+// a valid invite. The password is checked first so invites aren't used up
+// when they don't need to be. After too many wrong passwords the party
+// stops checking the user's for a while, invites still work.
+func (p *Party) Join(uid UserUUID, name string, password string, invite string) error {
+
+	// bcrypt is slow, so the password is checked without the party locked
+	hash, attempt, err := p.passwordToCheck(uid, password, invite)
+	if err != nil {
+		return err
+	}
+
+	matched := hash != "" && checkPassword(hash, password)
+	return p.join(uid, name, hash, matched, attempt, invite)
+}
+
+// passwordToCheck is the hash to check a join's password against, empty
+// if there's nothing to check. The check counts as a failure until it
+// passes, so guesses made at the same time can't all get past the limit.
+// attempt is when that failure was counted. Fails if the party isn't
+// checking the user's passwords right now and there's no invite to fall
+// back on.
+func (p *Party) passwordToCheck(uid UserUUID, password string, invite string) (hash string, attempt time.Time, err error) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.password == "" || password == "" {
+		return "", attempt, nil
+	}
+
+	now := p.now()
+	p.pruneJoinFailures(now)
+
+	if len(p.joinFailures[uid]) >= maxJoinFailures {
+		if invite == "" {
+			return "", attempt, fmt.Errorf("too many wrong passwords, try again later")
+		}
+
+		return "", attempt, nil
+	}
+
+	p.joinFailures[uid] = append(p.joinFailures[uid], now)
+	return p.password, now, nil
+}
+
+// pruneJoinFailures that are outside the window
+func (p *Party) pruneJoinFailures(now time.Time) {
+	for uid, failures := range p.joinFailures {
+		for len(failures) > 0 && now.Sub(failures[0]) >= joinFailureWindow {
+			failures = failures[1:]
+		}
+
+		if len(failures) == 0 {
+			delete(p.joinFailures, uid)
+		} else {
+			p.joinFailures[uid] = failures
+		}
+	}
+}
+
+// forgetJoinFailure counted at attempt, the password was right
+func (p *Party) forgetJoinFailure(uid UserUUID, attempt time.Time) {
+	failures := p.joinFailures[uid]
+	for i, at := range failures {
+		if at.Equal(attempt) {
+			failures = append(failures[:i], failures[i+1:]...)
+			break
+		}
+	}
+
+	if len(failures) == 0 {
+		delete(p.joinFailures, uid)
+	} else {
+		p.joinFailures[uid] = failures
+	}
+}
+
+// join once the password was checked against hash
+func (p *Party) join(uid UserUUID, name string, hash string, matched bool, attempt time.Time, invite string) (err error) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	// only the invite that was used is logged, the password never is
+	now := p.now()
+	e := Event{Type: EventJoin, Actor: uid, Name: name, Time: now}
+	startChangeID := p.changeID
+	defer func() {
+		p.record(&err, startChangeID, e)
+	}()
+
+	if matched {
+		p.forgetJoinFailure(uid, attempt)
+	}
+
+	// check before using up an invite
+	if err = p.canJoin(uid); err != nil {
+		return err
+	}
+
+	// the password could have changed while it was being checked
+	if p.locked() && !(matched && hash == p.password) {
+		if err = p.useInvite(invite, now); err != nil {
+			return err
+		}
+
+		e.Code = invite
+	}
+
+	return p.doAddUser(uid, name, now)
+}
+
+// replayJoin is Join without the password check, which already passed.
+func (p *Party) replayJoin(uid UserUUID, name string, invite string) (err error) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	now := p.now()
+	defer p.record(&err, p.changeID, Event{Type: EventJoin, Actor: uid, Name: name, Code: invite, Time: now})
+
+	if invite != "" {
+		if err = p.useInvite(invite, now); err != nil {
+			return err
+		}
+	}
+
+	return p.doAddUser(uid, name, now)
+}
+
+// locked checks if users need a password or an invite to join
+func (p *Party) locked() bool {
+	return p.password != "" || p.inviteOnly
+}
+
+// useInvite takes one use off of an invite, removing it once it's used up.
+func (p *Party) useInvite(code string, now time.Time) error {
+	if code == "" {
+		return fmt.Errorf("party needs a password or an invite to join")
+	}
+
+	invite, has := p.invites[code]
+	if !has || invite.expired(now) {
+		return fmt.Errorf("invite is not valid")
+	}
+
+	invite.Uses--
+	if invite.Uses == 0 {
+		delete(p.invites, code)
+	}
+
+	p.setUpdated(PullPermissionKey)
+	return nil
+}
+
+// SetPassword for joining the party. An empty password removes it.
+// Passwords are hashed with bcrypt, so they can be at most 72 bytes.
+// uid of person trying to set the password.
+func (p *Party) SetPassword(uid UserUUID, password string) error {
+	hash := ""
+	if password != "" {
+		raw, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("failed to hash password: %s", err.Error())
+		}
+
+		hash = string(raw)
+	}
+
+	return p.setPasswordHash(uid, hash)
+}
+
+// setPasswordHash is split from SetPassword so only the hash is logged
+func (p *Party) setPasswordHash(uid UserUUID, hash string) (err error) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetPassword, Actor: uid, Secret: hash})
+
+	if can, err := p.canUserDo(uid, CapabilityManageInvites); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't change how users join")
+	}
+
+	if hash == "" && p.password == "" {
+		return fmt.Errorf("party has no password")
+	}
+
+	p.password = hash
+	p.setUpdated(PullPermissionKey)
+
+	return nil
+}
+
+// SetInviteOnly so users need an invite or the password to join.
+// uid of person trying to change it.
+func (p *Party) SetInviteOnly(uid UserUUID, inviteOnly bool) (err error) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetInviteOnly, Actor: uid, Value: inviteOnly})
+
+	if can, err := p.canUserDo(uid, CapabilityManageInvites); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't change how users join")
+	}
+
+	if p.inviteOnly == inviteOnly {
+		return fmt.Errorf("not changing anything")
+	}
+
+	p.inviteOnly = inviteOnly
+	p.setUpdated(PullPermissionKey)
+
+	return nil
+}
+
+// CreateInvite that can be used uses times before ttl passes.
+// uid of person creating the invite. Returns the invite's code.
+func (p *Party) CreateInvite(uid UserUUID, uses int, ttl time.Duration) (string, error) {
+	if uses <= 0 {
+		return "", fmt.Errorf("invites need at least one use")
+	}
+
+	if ttl <= 0 {
+		return "", fmt.Errorf("invites need to last a while")
+	}
+
+	raw := make([]byte, inviteCodeSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate invite")
+	}
+
+	code := hex.EncodeToString(raw)
+	if err := p.addInvite(uid, code, uses, p.now().Add(ttl)); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// addInvite is split from CreateInvite so the code and expiration are logged
+func (p *Party) addInvite(uid UserUUID, code string, uses int, expires time.Time) (err error) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventCreateInvite, Actor: uid, Code: code, Uses: uses, Expires: expires})
+
+	if can, err := p.canUserDo(uid, CapabilityManageInvites); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't create invites")
+	}
+
+	if _, has := p.invites[code]; has {
+		return fmt.Errorf("invite already exists")
+	}
+
+	// a good time to drop the old ones
+	p.pruneInvites()
+
+	p.invites[code] = &Invite{
+		Code:      code,
+		Uses:      uses,
+		Expires:   expires,
+		CreatedBy: uid,
+	}
+
+	p.setUpdated(PullPermissionKey)
+
+	return nil
+}
+
+// RevokeInvite so it can't be used anymore.
+// uid of person trying to revoke the invite.
+func (p *Party) RevokeInvite(uid UserUUID, code string) (err error) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventRevokeInvite, Actor: uid, Code: code})
+
+	if can, err := p.canUserDo(uid, CapabilityManageInvites); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't revoke invites")
+	}
+
+	if _, has := p.invites[code]; !has {
+		return fmt.Errorf("no such invite")
+	}
+
+	delete(p.invites, code)
+	p.setUpdated(PullPermissionKey)
+
+	return nil
+}
+
+// pruneInvites that expired
+func (p *Party) pruneInvites() {
+	now := p.now()
+	for code, invite := range p.invites {
+		if invite.expired(now) {
+			delete(p.invites, code)
+		}
+	}
+}
+
+// activeInvites sorted by expiration so pulls are stable
+func (p *Party) activeInvites() []Invite {
+	now := p.now()
+
+	invites := make([]Invite, 0, len(p.invites))
+	for _, invite := range p.invites {
+		if !invite.expired(now) {
+			invites = append(invites, *invite)
+		}
+	}
+
+	sort.Slice(invites, func(i, j int) bool {
+		if invites[i].Expires.Equal(invites[j].Expires) {
+			return invites[i].Code < invites[j].Code
+		}
+
+		return invites[i].Expires.Before(invites[j].Expires)
+	})
+
+	return invites
+}
+
+// joinData describes how users join, for pulls
+func (p *Party) joinData() map[string]interface{} {
+	return map[string]interface{}{
+		"password":   p.password != "",
+		"inviteOnly": p.inviteOnly,
+		"invites":    p.activeInvites(),
+	}
+}
+
+// checkPassword against a party's hash
+func checkPassword(hash string, password string) bool {
+	if hash == "" || password == "" {
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
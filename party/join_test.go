@@ -0,0 +1,166 @@
+package party_test
+
+import (
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPartyJoinPassword(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	// open parties let anyone in
+	assert.Nil(t, p.Join("2", "fred", "", ""))
+	assert.NotNil(t, p.Join("2", "fred", "", ""))
+
+	// only managers set passwords
+	assert.NotNil(t, p.SetPassword("2", "hunter2"))
+	assert.NotNil(t, p.SetPassword(ouid, ""))
+	assert.Nil(t, p.SetPassword(ouid, "hunter2"))
+
+	assert.NotNil(t, p.Join("3", "greg", "", ""))
+	assert.NotNil(t, p.Join("3", "greg", "hunter3", ""))
+	assert.Nil(t, p.Join("3", "greg", "hunter2", ""))
+
+	// the password is never pulled
+	raw, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	join := raw.(map[string]interface{})[party.PullJoinKey].(map[string]interface{})
+	assert.Equal(t, true, join["password"])
+
+	raw, err = p.Pull("2", 0)
+	assert.Nil(t, err)
+	assert.NotContains(t, raw, party.PullJoinKey)
+
+	// clearing opens it back up
+	assert.Nil(t, p.SetPassword(ouid, ""))
+	assert.Nil(t, p.Join("4", "hank", "", ""))
+
+	// bans still apply
+	assert.Nil(t, p.Ban(ouid, "5"))
+	assert.NotNil(t, p.Join("5", "ivan", "", ""))
+}
+
+func TestPartyJoinThrottle(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.SetPassword(ouid, "hunter2"))
+
+	invite, err := p.CreateInvite(ouid, 1, time.Hour)
+	assert.Nil(t, err)
+
+	// guesses at the same time count before they're checked
+	errs := make(chan error)
+	for i := 0; i < 20; i++ {
+		go func() {
+			errs <- p.Join("2", "fred", "guess", "")
+		}()
+	}
+
+	throttled := 0
+	for i := 0; i < 20; i++ {
+		if err := <-errs; assert.NotNil(t, err) && strings.Contains(err.Error(), "too many") {
+			throttled++
+		}
+	}
+	assert.Equal(t, 15, throttled)
+
+	// too many guesses, even the right password is turned away for a while
+	assert.NotNil(t, p.Join("2", "fred", "hunter2", ""))
+
+	// other users aren't locked out
+	assert.Nil(t, p.Join("3", "greg", "hunter2", ""))
+
+	// invites still work
+	assert.Nil(t, p.Join("2", "fred", "hunter2", invite))
+}
+
+func TestPartyJoinInvite(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.Join("2", "fred", "", ""))
+
+	assert.NotNil(t, p.SetInviteOnly("2", true))
+	assert.Nil(t, p.SetInviteOnly(ouid, true))
+	assert.NotNil(t, p.SetInviteOnly(ouid, true))
+
+	// bad invites
+	_, err := p.CreateInvite("2", 1, time.Hour)
+	assert.NotNil(t, err)
+	_, err = p.CreateInvite(ouid, 0, time.Hour)
+	assert.NotNil(t, err)
+	_, err = p.CreateInvite(ouid, 1, 0)
+	assert.NotNil(t, err)
+
+	single, err := p.CreateInvite(ouid, 1, time.Hour)
+	assert.Nil(t, err)
+	double, err := p.CreateInvite(ouid, 2, time.Hour)
+	assert.Nil(t, err)
+	short, err := p.CreateInvite(ouid, 5, 10*time.Millisecond)
+	assert.Nil(t, err)
+
+	assert.NotNil(t, p.Join("3", "greg", "", ""))
+	assert.NotNil(t, p.Join("3", "greg", "", "bad"))
+
+	// single use
+	assert.Nil(t, p.Join("3", "greg", "", single))
+	assert.NotNil(t, p.Join("4", "hank", "", single))
+
+	// n use
+	assert.Nil(t, p.Join("4", "hank", "", double))
+	assert.Nil(t, p.Join("5", "ivan", "", double))
+	assert.NotNil(t, p.Join("6", "jack", "", double))
+
+	// expired
+	time.Sleep(20 * time.Millisecond)
+	assert.NotNil(t, p.Join("6", "jack", "", short))
+
+	// revoked
+	revoked, err := p.CreateInvite(ouid, 1, time.Hour)
+	assert.Nil(t, err)
+	assert.NotNil(t, p.RevokeInvite("2", revoked))
+	assert.Nil(t, p.RevokeInvite(ouid, revoked))
+	assert.NotNil(t, p.RevokeInvite(ouid, revoked))
+	assert.NotNil(t, p.Join("6", "jack", "", revoked))
+
+	// managers see what's left
+	left, err := p.CreateInvite(ouid, 3, time.Hour)
+	assert.Nil(t, err)
+
+	raw, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	join := raw.(map[string]interface{})[party.PullJoinKey].(map[string]interface{})
+	assert.Equal(t, true, join["inviteOnly"])
+	invites := join["invites"].([]party.Invite)
+	assert.Len(t, invites, 1)
+	assert.Equal(t, left, invites[0].Code)
+	assert.Equal(t, 3, invites[0].Uses)
+}
+
+func TestPartyJoinReplay(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+	var events []party.Event
+	base := captureEvents(p, &events)
+
+	assert.Nil(t, p.SetPassword(ouid, "hunter2"))
+	invite, err := p.CreateInvite(ouid, 2, time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Join("2", "fred", "hunter2", invite))
+	assert.Nil(t, p.Join("3", "greg", "", invite))
+
+	// the password isn't in the log
+	for _, e := range events {
+		assert.NotContains(t, e.Secret, "hunter2")
+	}
+
+	restored := replay(t, base, events)
+
+	// the password carried over and the invite has one use left
+	assert.Nil(t, restored.Join("4", "hank", "hunter2", ""))
+	assert.Nil(t, restored.Join("5", "ivan", "", invite))
+	assert.NotNil(t, restored.Join("6", "jack", "", invite))
+}
@@ -0,0 +1,120 @@
+package party
+
+import (
+	"sync"
+	"time"
+)
+
+// notifier wakes everyone waiting on a party when it changes or ends.
+// It has its own lock so waiters never need to hold the party's.
+type notifier struct {
+	mux *sync.Mutex
+
+	// closed on the next change, then replaced
+	ch    chan struct{}
+	ended bool
+
+	// copy of the party's change id so waiters can check it
+	changeID uint64
+}
+
+func newNotifier(changeID uint64) *notifier {
+	return &notifier{
+		mux:      &sync.Mutex{},
+		ch:       make(chan struct{}),
+		changeID: changeID,
+	}
+}
+
+// wait returns a channel that is closed at the next change.
+// If the party already ended the channel is closed.
+func (n *notifier) wait() <-chan struct{} {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return n.ch
+}
+
+// notify everyone waiting that there was a change
+func (n *notifier) notify(changeID uint64) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.changeID = changeID
+	if n.ended {
+		return
+	}
+
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
+
+// end wakes everyone for the last time
+func (n *notifier) end() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if n.ended {
+		return
+	}
+
+	n.ended = true
+	close(n.ch)
+}
+
+func (n *notifier) isEnded() bool {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return n.ended
+}
+
+// state gets the channel for the next change along with the current change id
+func (n *notifier) state() (<-chan struct{}, uint64, bool) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return n.ch, n.changeID, n.ended
+}
+
+// Changed returns a channel that is closed the next time the party changes
+// or when it ends. Get the channel before pulling so no change is missed.
+// This doesn't take the party lock.
+func (p *Party) Changed() <-chan struct{} {
+	return p.changes.wait()
+}
+
+// End the party, waking everyone waiting on it for good.
+func (p *Party) End() {
+	p.changes.end()
+}
+
+// Ended checks if the party was ended.
+func (p *Party) Ended() bool {
+	return p.changes.isEnded()
+}
+
+// WaitForChange blocks until the party's change id differs from cid,
+// the party ends or the timeout passes. Returns true if there is something
+// new to pull. This doesn't take the party lock.
+func (p *Party) WaitForChange(cid uint64, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		changed, changeID, ended := p.changes.state()
+		if changeID != cid {
+			return true
+		}
+
+		if ended {
+			return false
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		}
+	}
+}
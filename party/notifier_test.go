@@ -0,0 +1,76 @@
+package party_test
+
+import (
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// checks if a channel is closed without blocking
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestPartyChanged(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	changed := p.Changed()
+	assert.False(t, isClosed(changed))
+
+	// failed changes don't wake anyone
+	assert.NotNil(t, p.Seek("bad", 1))
+	assert.False(t, isClosed(changed))
+
+	assert.Nil(t, p.Suggest(ouid, "a"))
+	assert.True(t, isClosed(changed))
+
+	// new waiters wait for the next change
+	changed = p.Changed()
+	assert.False(t, isClosed(changed))
+	assert.False(t, p.Ended())
+
+	p.End()
+	assert.True(t, isClosed(changed))
+	assert.True(t, p.Ended())
+
+	// stays ended
+	assert.True(t, isClosed(p.Changed()))
+	assert.Nil(t, p.Suggest(ouid, "b"))
+	assert.True(t, isClosed(p.Changed()))
+}
+
+func TestPartyWaitForChange(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	// nothing happens
+	assert.False(t, p.WaitForChange(0, 10*time.Millisecond))
+
+	// already behind
+	assert.Nil(t, p.Suggest(ouid, "a"))
+	assert.True(t, p.WaitForChange(0, time.Second))
+
+	// change while waiting
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Suggest(ouid, "b")
+	}()
+	assert.True(t, p.WaitForChange(1, time.Second))
+
+	// ending wakes waiters without a change
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.End()
+	}()
+
+	start := time.Now()
+	assert.False(t, p.WaitForChange(2, time.Second))
+	assert.True(t, time.Since(start) < time.Second)
+}
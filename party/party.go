@@ -2,6 +2,7 @@ package party
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 )
@@ -20,9 +21,68 @@ type Party struct {
 	previous        PreviousStack
 
 	lastChangeT time.Time
+	changes     *notifier
+
+	// recent changes for delta pulls, oldest first
+	history []changeRecord
 
 	// map of the permission to bools of if a user can use them
 	permMap map[string]bool
+
+	// users who can't join
+	bans map[UserUUID]struct{}
+
+	// song metadata, see catalog.go
+	catalog Catalog
+	songs   songCache
+
+	// durations clients gave for songs the catalog doesn't know
+	durations map[SongUID]time.Duration
+
+	// output zones by name, see zone.go
+	zones map[string]*Zone
+
+	// fills in when the queues are empty, see radio.go
+	radio        radio
+	likedArtists map[string]int
+
+	// votes to skip the current song, see voteSkip.go
+	skipVotes    map[UserUUID]struct{}
+	skipFraction float64
+	skipCount    int
+
+	// suggestion limits and what they're counted against, see quota.go
+	limits         SuggestionLimits
+	lastSuggested  map[UserUUID]time.Time
+	suggestedPlays map[UserUUID][]time.Time
+
+	// takes unwanted songs out of the suggestion queue, see veto.go
+	veto   VetoRule
+	vetoed map[SongUID]time.Time
+
+	// how the suggestion queue weighs votes, see scoring.go
+	scoring Scoring
+
+	// join rules, see join.go
+	password   string
+	inviteOnly bool
+	invites    map[string]*Invite
+
+	// when each user tried wrong passwords, oldest first
+	joinFailures map[UserUUID][]time.Time
+
+	// event log, see event.go
+	eventSeq   uint64
+	onEvent    func(Event) error
+	onSave     func(Snapshot) error
+	replaying  bool
+	replayTime time.Time
+
+	// an event couldn't be logged and no snapshot covers it yet
+	logGap bool
+
+	// the party's clock, nil means time.Now
+	clock func() time.Time
 }
 
 // New party
@@ -38,8 +98,28 @@ func New(ownerUUID UserUUID, ownerName string) *Party {
 		previous:        NewPreviousStack(),
 
 		lastChangeT: time.Now(),
+		changes:     newNotifier(0),
 
 		permMap: make(map[string]bool),
+		bans:    make(map[UserUUID]struct{}),
+		invites: make(map[string]*Invite),
+		songs:   make(songCache),
+
+		joinFailures: make(map[UserUUID][]time.Time),
+
+		durations: make(map[SongUID]time.Duration),
+		zones:     make(map[string]*Zone),
+
+		radio:        radio{mode: RadioOff},
+		likedArtists: make(map[string]int),
+
+		skipVotes:    make(map[UserUUID]struct{}),
+		skipFraction: defaultSkipFraction,
+
+		lastSuggested:  make(map[UserUUID]time.Time),
+		suggestedPlays: make(map[UserUUID][]time.Time),
+
+		vetoed: make(map[SongUID]time.Time),
 	}
 
 	// initially set true for all permissions
@@ -47,63 +127,324 @@ func New(ownerUUID UserUUID, ownerName string) *Party {
 		p.permMap[key] = true
 	}
 
+	p.nowPlaying.clock = p.now
+	p.suggestionQueue.clock = p.now
+	p.suggestionQueue.SetScorer(p.scorer())
+
 	p.AddUser(ownerUUID, ownerName)
-	p.SetOwner(ownerUUID)
 	return &p
 }
 
 // AddUser to the party, applies default permissions
-func (p *Party) AddUser(userUUID UserUUID, name string) error {
+func (p *Party) AddUser(userUUID UserUUID, name string) (err error) {
 
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
+	// joined at the time of the event so replays match
+	now := p.now()
+	defer p.record(&err, p.changeID, Event{Type: EventAddUser, Actor: userUUID, Name: name, Time: now})
+
+	return p.doAddUser(userUUID, name, now)
+}
+
+// doAddUser that joined at now
+func (p *Party) doAddUser(userUUID UserUUID, name string, now time.Time) error {
+	if err := p.canJoin(userUUID); err != nil {
+		return err
+	}
+
 	user := NewUser(name)
+	user.joined = now
+	user.lastSeen = now
+	p.users[userUUID] = user
+	return nil
+}
+
+// canJoin checks that the user isn't already here or banned
+func (p *Party) canJoin(userUUID UserUUID) error {
 	if _, has := p.getUser(userUUID); has == nil {
 		return fmt.Errorf("party already contains user %s", userUUID)
 	}
 
-	p.setDefaultPermission(user)
-	p.users[userUUID] = user
+	if _, banned := p.bans[userUUID]; banned {
+		return fmt.Errorf("user %s is banned from the party", userUUID)
+	}
+
 	return nil
 }
 
-// RemoveUser from the party
+// RemoveUser from the party.
+// If the owner leaves, ownership goes to the next in line (see successor).
+// The last user can't leave, the party should be ended instead.
 func (p *Party) RemoveUser(userUUID UserUUID) error {
+	return p.removeUser(userUUID, p.activeUsers)
+}
+
+// removeUser with active counting the users active once they're gone.
+// Who's active isn't logged, so replays count from the event.
+func (p *Party) removeUser(userUUID UserUUID, active func() int) (err error) {
 
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
-	if userUUID == p.ownerUUID {
-		// TODO: should terminate instead...
-		return fmt.Errorf("removing owner from party")
+	e := Event{Type: EventRemoveUser, Actor: userUUID}
+	startChangeID := p.changeID
+	defer func() {
+		p.record(&err, startChangeID, e)
+	}()
+
+	if err = p.doRemoveUser(userUUID); err != nil {
+		return err
 	}
 
-	if _, has := p.getUser(userUUID); has != nil {
+	e.Active = active()
+	p.checkSkipVotes(e.Active)
+	return nil
+}
+
+// doRemoveUser handles the owner leaving and cleaning up what's pulled.
+func (p *Party) doRemoveUser(userUUID UserUUID) error {
+	user, err := p.getUser(userUUID)
+	if err != nil {
 		return fmt.Errorf("user %s not in the party", userUUID)
 	}
 
+	if userUUID == p.ownerUUID {
+		// don't care who's active, it has to be deterministic for replays
+		successor, found := p.successor(0)
+		if !found {
+			return fmt.Errorf("owner is the last user, end the party instead")
+		}
+
+		p.setOwner(successor)
+	}
+
+	// roles and overrides are pulled, so they change when the user goes
+	if user.role != RoleGuest || len(user.permissions) > 0 {
+		p.setUpdated(PullPermissionKey)
+	}
+
+	// their skip vote goes with them
+	if _, voted := p.skipVotes[userUUID]; voted {
+		delete(p.skipVotes, userUUID)
+		p.setUpdated(PullPlayingKey)
+	}
+
 	delete(p.users, userUUID)
+	p.rolesChanged()
 	return nil
 }
 
+// Kick a user out of the party. They can join again.
+// uid of person kicking, target is who gets kicked.
+func (p *Party) Kick(uid UserUUID, target UserUUID) error {
+	return p.kick(uid, target, p.activeUsers)
+}
+
+// kick with active counting the users active once target is gone
+func (p *Party) kick(uid UserUUID, target UserUUID, active func() int) (err error) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	e := Event{Type: EventKick, Actor: uid, Target: target}
+	startChangeID := p.changeID
+	defer func() {
+		p.record(&err, startChangeID, e)
+	}()
+
+	if err = p.canUserManage(uid, target, CapabilityKick); err != nil {
+		return err
+	}
+
+	if err = p.doRemoveUser(target); err != nil {
+		return err
+	}
+
+	e.Active = active()
+	p.checkSkipVotes(e.Active)
+	return nil
+}
+
+// Ban a user from the party. If they're in the party they're removed along
+// with their votes and the songs they suggested. Users who aren't in the
+// party can be banned too.
+// uid of person banning, target is who gets banned.
+func (p *Party) Ban(uid UserUUID, target UserUUID) error {
+	return p.ban(uid, target, p.activeUsers)
+}
+
+// ban with active counting the users active once target is gone
+func (p *Party) ban(uid UserUUID, target UserUUID, active func() int) (err error) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	e := Event{Type: EventBan, Actor: uid, Target: target}
+	startChangeID := p.changeID
+	defer func() {
+		p.record(&err, startChangeID, e)
+	}()
+
+	if can, err := p.canUserDo(uid, CapabilityBan); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user %s lacks the %s capability", uid, CapabilityBan)
+	}
+
+	if _, banned := p.bans[target]; banned {
+		return fmt.Errorf("user %s is already banned", target)
+	}
+
+	if _, err = p.getUser(target); err == nil {
+		if err = p.canUserManage(uid, target, CapabilityBan); err != nil {
+			return err
+		}
+
+		if err = p.doRemoveUser(target); err != nil {
+			return err
+		}
+
+		e.Active = active()
+		p.checkSkipVotes(e.Active)
+	}
+
+	p.bans[target] = struct{}{}
+	sections := []string{PullPermissionKey}
+
+	// some of their songs may have been played already
+	if p.suggestionQueue.RemoveUser(target) {
+		sections = append(sections, PullSuggestKey)
+	}
+
+	p.setUpdated(sections...)
+
+	return nil
+}
+
+// Unban a user so they can join again.
+// uid of person unbanning, target is who gets unbanned.
+func (p *Party) Unban(uid UserUUID, target UserUUID) (err error) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventUnban, Actor: uid, Target: target})
+
+	if can, err := p.canUserDo(uid, CapabilityBan); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user %s lacks the %s capability", uid, CapabilityBan)
+	}
+
+	if _, banned := p.bans[target]; !banned {
+		return fmt.Errorf("user %s isn't banned", target)
+	}
+
+	delete(p.bans, target)
+	p.setUpdated(PullPermissionKey)
+
+	return nil
+}
+
+// bannedUsers sorted so pulls are stable
+func (p *Party) bannedUsers() []UserUUID {
+	bans := make([]UserUUID, 0, len(p.bans))
+	for uid := range p.bans {
+		bans = append(bans, uid)
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i] < bans[j]
+	})
+
+	return bans
+}
+
+// HasUser checks if the user is in the party
+func (p *Party) HasUser(userUUID UserUUID) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	_, err := p.getUser(userUUID)
+	return err == nil
+}
+
 // CanUserEndParty id'ing the user by uuid
 func (p *Party) CanUserEndParty(userUUID UserUUID) bool {
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
-	return p.ownerUUID == userUUID
+	can, _ := p.canUserDo(userUUID, CapabilityEndParty)
+	return can
 }
 
-// canUserPerformAction id'd by string
-func (p *Party) canUserPerformAction(userUUID UserUUID, action string) (bool, error) {
+// roleOf a user, the owner is tracked by the party rather than the user
+func (p *Party) roleOf(userUUID UserUUID) Role {
+	if userUUID == p.ownerUUID {
+		return RoleOwner
+	}
+
+	user, err := p.getUser(userUUID)
+	if err != nil {
+		return RoleGuest
+	}
+
+	return user.role
+}
+
+// canUserDo checks if the user's role has a capability
+func (p *Party) canUserDo(userUUID UserUUID, capability string) (bool, error) {
 	// check that the user exists
 	if _, err := p.getUser(userUUID); err != nil {
 		return false, err
 	}
 
+	return p.roleOf(userUUID).can(capability), nil
+}
+
+// canUserManage checks if the user has a capability and outranks the target.
+// Returns an error if they don't.
+func (p *Party) canUserManage(userUUID UserUUID, target UserUUID, capability string) error {
+	if can, err := p.canUserDo(userUUID, capability); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user %s lacks the %s capability", userUUID, capability)
+	}
+
+	if _, err := p.getUser(target); err != nil {
+		return err
+	}
+
+	if !p.roleOf(userUUID).outranks(p.roleOf(target)) {
+		return fmt.Errorf("user %s doesn't outrank %s", userUUID, target)
+	}
+
+	return nil
+}
+
+// canUserPerformAction id'd by string.
+// The user's override wins, then what their role grants,
+// otherwise the party's permission applies.
+func (p *Party) canUserPerformAction(userUUID UserUUID, action string) (bool, error) {
+	// check that the user exists
+	user, err := p.getUser(userUUID)
+	if err != nil {
+		return false, err
+	}
+
+	role := p.roleOf(userUUID)
+
 	// owner can do anything
-	if userUUID == p.ownerUUID {
+	if role == RoleOwner {
+		return true, nil
+	}
+
+	if value, has := user.Permission(action); has {
+		return value, nil
+	}
+
+	if role.grants(action) {
 		return true, nil
 	}
 
@@ -116,15 +457,62 @@ func (p *Party) canUserPerformAction(userUUID UserUUID, action string) (bool, er
 	return value, nil
 }
 
-func (p *Party) setDefaultPermission(user *User) {
-	// TODO: replace with real permissions
-	user.SetPermission("default", true)
-	user.SetPermission("bad", false)
+// permissions copies the party wide permissions, pulls are encoded
+// after the lock is released
+func (p *Party) permissions() map[string]bool {
+	perms := make(map[string]bool, len(p.permMap))
+	for key, value := range p.permMap {
+		perms[key] = value
+	}
+
+	return perms
+}
+
+// userPermissions resolves every permission for a user
+func (p *Party) userPermissions(userUUID UserUUID) map[string]bool {
+	perms := make(map[string]bool, len(p.permMap))
+	for key := range p.permMap {
+		// only fails for missing users, and the caller checked that
+		perms[key], _ = p.canUserPerformAction(userUUID, key)
+	}
+
+	return perms
+}
+
+// userRoles of everyone who isn't a guest
+func (p *Party) userRoles() map[UserUUID]Role {
+	roles := make(map[UserUUID]Role)
+	for uid := range p.users {
+		if role := p.roleOf(uid); role != RoleGuest {
+			roles[uid] = role
+		}
+	}
+
+	return roles
+}
+
+// userOverrides copies each user's permission overrides, skipping users without any
+func (p *Party) userOverrides() map[UserUUID]map[string]bool {
+	overrides := make(map[UserUUID]map[string]bool)
+	for uid, user := range p.users {
+		if len(user.permissions) == 0 {
+			continue
+		}
+
+		perms := make(map[string]bool, len(user.permissions))
+		for key, value := range user.permissions {
+			perms[key] = value
+		}
+
+		overrides[uid] = perms
+	}
+
+	return overrides
 }
 
 // SetPermission by key.
 // uid of person trying to set the permissions.
-func (p *Party) SetPermission(which string, value bool, uid UserUUID) error {
+func (p *Party) SetPermission(which string, value bool, uid UserUUID) (err error) {
 
 	// not a valid permission
 	if _, has := PermissionDescriptionMap[which]; !has {
@@ -134,10 +522,12 @@ func (p *Party) SetPermission(which string, value bool, uid UserUUID) error {
 	// lock later b/c above should be threadsafe
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetPermission, Actor: uid, Permission: which, Value: value})
 
-	// check that the owner is setting perms
-	if uid != p.ownerUUID {
-		return fmt.Errorf("only owner can set permissions")
+	if can, err := p.canUserDo(uid, CapabilityManagePermissions); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't set permissions")
 	}
 
 	// adding a permission
@@ -149,7 +539,101 @@ func (p *Party) SetPermission(which string, value bool, uid UserUUID) error {
 
 	// else update permission
 	p.permMap[which] = value
-	p.setUpdated()
+	p.setUpdated(PullPermissionKey)
+
+	return nil
+}
+
+// SetUserPermission overrides a permission for one user.
+// uid of person trying to set the permission, target is who it applies to.
+func (p *Party) SetUserPermission(uid UserUUID, target UserUUID, which string, value bool) (err error) {
+
+	// not a valid permission
+	if _, has := PermissionDescriptionMap[which]; !has {
+		return fmt.Errorf("not a valid permission")
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetUserPermission, Actor: uid, Target: target, Permission: which, Value: value})
+
+	if err = p.canUserManage(uid, target, CapabilityManagePermissions); err != nil {
+		return err
+	}
+
+	user, err := p.getUser(target)
+	if err != nil {
+		return err
+	}
+
+	if current, has := user.Permission(which); has && current == value {
+		return fmt.Errorf("not changing anything")
+	}
+
+	user.SetPermission(which, value)
+	p.setUpdated(PullPermissionKey)
+
+	return nil
+}
+
+// ClearUserPermission removes a user's override so the party's permission applies.
+// uid of person trying to clear the permission, target is who it applies to.
+func (p *Party) ClearUserPermission(uid UserUUID, target UserUUID, which string) (err error) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventClearUserPermission, Actor: uid, Target: target, Permission: which})
+
+	if err = p.canUserManage(uid, target, CapabilityManagePermissions); err != nil {
+		return err
+	}
+
+	user, err := p.getUser(target)
+	if err != nil {
+		return err
+	}
+
+	if _, has := user.Permission(which); !has {
+		return fmt.Errorf("user %s has no override for %s", target, which)
+	}
+
+	user.ClearPermission(which)
+	p.setUpdated(PullPermissionKey)
+
+	return nil
+}
+
+// SetRole promotes or demotes a user.
+// uid of person changing the role, target is who it applies to.
+// Ownership can't be given this way.
+func (p *Party) SetRole(uid UserUUID, target UserUUID, role Role) (err error) {
+
+	if !IsValidRole(role) {
+		return fmt.Errorf("not a valid role")
+	} else if role == RoleOwner {
+		return fmt.Errorf("can't make another owner")
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetRole, Actor: uid, Target: target, Role: role})
+
+	if err = p.canUserManage(uid, target, CapabilityManageRoles); err != nil {
+		return err
+	}
+
+	user, err := p.getUser(target)
+	if err != nil {
+		return err
+	}
+
+	if user.role == role {
+		return fmt.Errorf("not changing anything")
+	}
+
+	user.role = role
+	p.setUpdated(PullPermissionKey)
+	p.rolesChanged()
 
 	return nil
 }
@@ -160,29 +644,164 @@ func (p *Party) getUser(userUUID UserUUID) (*User, error) {
 		return nil, fmt.Errorf("user %s not found", userUUID)
 	}
 
-	return user, nil
+	return user, nil
+}
+
+// SetOwner of the party (there can be only one).
+// This doesn't check who asked, use TransferOwnership for that.
+func (p *Party) SetOwner(userUUID UserUUID) (err error) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetOwner, Actor: userUUID})
+
+	if _, err = p.getUser(userUUID); err != nil {
+		return err
+	}
+
+	if userUUID == p.ownerUUID {
+		return fmt.Errorf("user %s is already the owner", userUUID)
+	}
+
+	p.setOwner(userUUID)
+
+	return nil
+}
+
+// TransferOwnership from the owner to another user.
+// The old owner stays on as a co-host.
+func (p *Party) TransferOwnership(uid UserUUID, target UserUUID) (err error) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventTransferOwnership, Actor: uid, Target: target})
+
+	if uid != p.ownerUUID {
+		return fmt.Errorf("only the owner can transfer ownership")
+	}
+
+	if _, err = p.getUser(target); err != nil {
+		return err
+	}
+
+	if target == uid {
+		return fmt.Errorf("user %s is already the owner", target)
+	}
+
+	p.setOwner(target)
+
+	return nil
+}
+
+// Heartbeat marks the user's client as still around.
+// Pulls count as heartbeats.
+func (p *Party) Heartbeat(uid UserUUID) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	user, err := p.getUser(uid)
+	if err != nil {
+		return err
+	}
+
+	user.lastSeen = p.now()
+	return nil
+}
+
+// FailoverOwner hands the party to the next in line if the owner hasn't
+// been heard from within timeout. Only users heard from within timeout
+// can take over. Returns the new owner and true if ownership changed.
+func (p *Party) FailoverOwner(timeout time.Duration) (UserUUID, bool) {
+	if timeout <= 0 {
+		return "", false
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	owner, err := p.getUser(p.ownerUUID)
+	if err == nil && p.now().Sub(owner.lastSeen) < timeout {
+		return "", false
+	}
+
+	successor, found := p.successor(timeout)
+	if !found {
+		return "", false
+	}
+
+	// logged as a SetOwner so replays don't depend on heartbeats
+	defer p.record(&err, p.changeID, Event{Type: EventSetOwner, Actor: successor})
+
+	err = nil
+	p.setOwner(successor)
+
+	return successor, true
+}
+
+// activeUsers counts the users heard from recently
+func (p *Party) activeUsers() int {
+	active := 0
+	for _, user := range p.users {
+		if p.now().Sub(user.lastSeen) < activeUserTimeout {
+			active++
+		}
+	}
+
+	return active
 }
 
-// SetOwner of the party (there can be only one)
-func (p *Party) SetOwner(userUUID UserUUID) error {
+// successor picks who should own the party after the owner.
+// Higher roles go first, then whoever has been in the party longest.
+// If activeWithin isn't 0, only users heard from that recently count.
+func (p *Party) successor(activeWithin time.Duration) (UserUUID, bool) {
+	var best UserUUID
+	var bestUser *User
 
-	p.mux.Lock()
-	defer p.mux.Unlock()
+	for uid, user := range p.users {
+		if uid == p.ownerUUID {
+			continue
+		}
 
-	if _, has := p.getUser(userUUID); has == nil {
-		return fmt.Errorf("user %s not found", userUUID)
+		if activeWithin != 0 && p.now().Sub(user.lastSeen) >= activeWithin {
+			continue
+		}
+
+		if bestUser != nil {
+			if bestUser.role.outranks(user.role) {
+				continue
+			}
+
+			// same rank, longest present wins, uid breaks ties so it's deterministic
+			if !user.role.outranks(bestUser.role) &&
+				(user.joined.After(bestUser.joined) ||
+					(user.joined.Equal(bestUser.joined) && uid > best)) {
+				continue
+			}
+		}
+
+		best = uid
+		bestUser = user
 	}
 
-	// TODO: set the permissions
-	p.ownerUUID = userUUID
+	return best, bestUser != nil
+}
 
-	return nil
+// setOwner moves ownership, the old owner becomes a co-host
+func (p *Party) setOwner(userUUID UserUUID) {
+	if old, err := p.getUser(p.ownerUUID); err == nil {
+		old.role = RoleCoHost
+	}
+
+	p.ownerUUID = userUUID
+	p.setUpdated(PullPermissionKey)
+	p.rolesChanged()
 }
 
 // SuggestionUpvote with user ID, song ID
-func (p *Party) SuggestionUpvote(uid UserUUID, sid SongUID) error {
+func (p *Party) SuggestionUpvote(uid UserUUID, sid SongUID) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSuggestionUpvote, Actor: uid, Song: sid})
 
 	if can, err := p.canUserPerformAction(uid, UserCanVoteSuggestionPermission); err != nil {
 		return err
@@ -190,20 +809,40 @@ func (p *Party) SuggestionUpvote(uid UserUUID, sid SongUID) error {
 		return fmt.Errorf("user can't upvote")
 	}
 
-	err := p.suggestionQueue.Upvote(uid, sid)
+	err = p.suggestionQueue.Upvote(uid, sid)
 	if err != nil {
 		return err
 	}
 
-	p.setUpdated()
+	p.likeArtist(sid)
+	p.setVotesUpdated()
 	return nil
 }
 
 // SuggestionDownvote with user ID, song ID
-func (p *Party) SuggestionDownvote(uid UserUUID, sid SongUID) error {
+// The song is vetoed if the vote crosses the party's VetoRule.
+func (p *Party) SuggestionDownvote(uid UserUUID, sid SongUID) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	// who's active isn't logged, so the count is
+	active := p.activeUsers()
+	defer p.record(&err, p.changeID, Event{Type: EventSuggestionDownvote, Actor: uid, Song: sid, Count: active})
+
+	return p.doSuggestionDownvote(uid, sid, active)
+}
+
+// replaySuggestionDownvote with the users active when the vote was cast
+func (p *Party) replaySuggestionDownvote(uid UserUUID, sid SongUID, active int) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSuggestionDownvote, Actor: uid, Song: sid, Count: active})
+
+	return p.doSuggestionDownvote(uid, sid, active)
+}
 
+// doSuggestionDownvote and veto the song if it crosses the rule
+func (p *Party) doSuggestionDownvote(uid UserUUID, sid SongUID, active int) error {
 	if can, err := p.canUserPerformAction(uid, UserCanVoteSuggestionPermission); err != nil {
 		return err
 	} else if !can {
@@ -215,14 +854,19 @@ func (p *Party) SuggestionDownvote(uid UserUUID, sid SongUID) error {
 		return err
 	}
 
-	p.setUpdated()
+	if p.crossesVeto(sid, active) {
+		return p.vetoSong(sid)
+	}
+
+	p.setVotesUpdated()
 	return nil
 }
 
 // SuggestionClearvote song to suggestion queue
-func (p *Party) SuggestionClearvote(uid UserUUID, sid SongUID) error {
+func (p *Party) SuggestionClearvote(uid UserUUID, sid SongUID) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSuggestionClearvote, Actor: uid, Song: sid})
 
 	if can, err := p.canUserPerformAction(uid, UserCanSuggestSongPermission); err != nil {
 		return err
@@ -230,19 +874,49 @@ func (p *Party) SuggestionClearvote(uid UserUUID, sid SongUID) error {
 		return fmt.Errorf("user can't suggest")
 	}
 
-	err := p.suggestionQueue.ClearVotes(uid, sid)
+	err = p.suggestionQueue.ClearVotes(uid, sid)
+	if err != nil {
+		return err
+	}
+
+	p.setVotesUpdated()
+	return nil
+}
+
+// SetQueueOrder picks how the suggestion queue decides what plays next.
+// uid of person changing the order.
+func (p *Party) SetQueueOrder(uid UserUUID, order QueueOrder) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetQueueOrder, Actor: uid, Name: string(order)})
+
+	if can, err := p.canUserDo(uid, CapabilityManageQueue); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't change the queue order")
+	}
+
+	err = p.suggestionQueue.SetOrder(order)
 	if err != nil {
 		return err
 	}
 
-	p.setUpdated()
+	p.setUpdated(PullSuggestKey)
 	return nil
 }
 
 // Suggest song to suggestion queue
 func (p *Party) Suggest(uid UserUUID, sid SongUID) error {
+	return p.SuggestWithDuration(uid, sid, 0)
+}
+
+// SuggestWithDuration is Suggest for a song the client says is duration
+// long, 0 if it doesn't know. The duration is set like SetSongDuration, but
+// it's left alone if the user can't change a duration that's already known.
+func (p *Party) SuggestWithDuration(uid UserUUID, sid SongUID, duration time.Duration) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSuggest, Actor: uid, Song: sid, DurationMs: durationMs(duration)})
 
 	if can, err := p.canUserPerformAction(uid, UserCanSuggestSongPermission); err != nil {
 		return err
@@ -250,35 +924,59 @@ func (p *Party) Suggest(uid UserUUID, sid SongUID) error {
 		return fmt.Errorf("user can't suggest")
 	}
 
-	err := p.suggestionQueue.AddSong(uid, sid)
+	if err = p.checkVetoed(sid); err != nil {
+		return err
+	}
+
+	if quotaErr := p.checkSuggestionLimits(uid); quotaErr != nil {
+		return quotaErr
+	}
+
+	if err = p.learnSong(sid); err != nil {
+		return err
+	}
+
+	err = p.suggestionQueue.AddSong(uid, sid)
 	if err != nil {
 		return err
 	}
 
-	// check if there is a song currently playing
-	if !p.nowPlaying.CurrentlyHasSong() {
+	p.lastSuggested[uid] = p.now()
+	p.offerSongDuration(uid, sid, duration)
+
+	// check if there is a song currently playing, radio songs give way
+	if p.needsSong() {
 		// this will choose the next song, return err if there is no song
 		// will update state if there is a change
 		return p.doPlayNextSong()
 	}
 
-	p.setUpdated()
+	p.setUpdated(PullSuggestKey)
 	return nil
 }
 
 // PlayNext adds a song to the playNext queue.
 // Error if song already in the queue.
 func (p *Party) PlayNext(uid UserUUID, sid SongUID) error {
+	return p.PlayNextWithDuration(uid, sid, 0)
+}
+
+// PlayNextWithDuration is PlayNext for a song the client says is duration
+// long, 0 if it doesn't know.
+func (p *Party) PlayNextWithDuration(uid UserUUID, sid SongUID, duration time.Duration) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventPlayNext, Actor: uid, Song: sid, DurationMs: durationMs(duration)})
 
 	// permission checked in doAdd function
 	if err := p.doAddToPlayNext(uid, sid); err != nil {
 		return err
 	}
 
-	// try to play a song if none is playing
-	if !p.nowPlaying.CurrentlyHasSong() {
+	p.offerSongDuration(uid, sid, duration)
+
+	// try to play a song if none is playing, radio songs give way
+	if p.needsSong() {
 		return p.doPlayNextSong()
 	}
 
@@ -287,14 +985,21 @@ func (p *Party) PlayNext(uid UserUUID, sid SongUID) error {
 	p.removeFromSuggestions(sid)
 
 	// update the state
-	p.setUpdated()
+	p.setUpdated(PullPlayNextKey, PullSuggestKey)
 	return nil
 }
 
 // AddTopPlayNext adds a song to the top of the play-next queue.
 func (p *Party) AddTopPlayNext(uid UserUUID, sid SongUID) error {
+	return p.AddTopPlayNextWithDuration(uid, sid, 0)
+}
+
+// AddTopPlayNextWithDuration is AddTopPlayNext for a song the client says
+// is duration long, 0 if it doesn't know.
+func (p *Party) AddTopPlayNextWithDuration(uid UserUUID, sid SongUID, duration time.Duration) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventAddTopPlayNext, Actor: uid, Song: sid, DurationMs: durationMs(duration)})
 
 	if can, err := p.canUserPerformAction(uid, UserCanPlaySongNextPermission); err != nil {
 		return err
@@ -302,12 +1007,18 @@ func (p *Party) AddTopPlayNext(uid UserUUID, sid SongUID) error {
 		return fmt.Errorf("user can't play-next")
 	}
 
+	if err := p.learnSong(sid); err != nil {
+		return err
+	}
+
 	if err := p.playNext.SetTop(sid); err != nil {
 		return err
 	}
 
-	// try to play a song if none is playing
-	if !p.nowPlaying.CurrentlyHasSong() {
+	p.offerSongDuration(uid, sid, duration)
+
+	// try to play a song if none is playing, radio songs give way
+	if p.needsSong() {
 		return p.doPlayNextSong()
 	}
 
@@ -316,10 +1027,36 @@ func (p *Party) AddTopPlayNext(uid UserUUID, sid SongUID) error {
 	p.removeFromSuggestions(sid)
 
 	// update the state
-	p.setUpdated()
+	p.setUpdated(PullPlayNextKey, PullSuggestKey)
 	return nil
 }
 
+// SongState is where a song is in the party
+type SongState struct {
+	Suggested bool `json:"suggested"`
+	PlayNext  bool `json:"playNext"`
+	Playing   bool `json:"playing"`
+}
+
+// SongStates for each song, in the same order
+func (p *Party) SongStates(sids ...SongUID) []SongState {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	states := make([]SongState, len(sids))
+	for i, sid := range sids {
+		_, suggested := p.suggestionQueue.songs[sid]
+
+		states[i] = SongState{
+			Suggested: suggested,
+			PlayNext:  p.playNext.getSong(sid) != nil,
+			Playing:   p.nowPlaying.CurrentlyHasSong() && p.nowPlaying.GetCurrentlyPlaying() == sid,
+		}
+	}
+
+	return states
+}
+
 // try to remove from suggestions once song is added to playnext
 func (p *Party) removeFromSuggestions(sid SongUID) error {
 	return p.suggestionQueue.RemoveSong(sid)
@@ -328,8 +1065,15 @@ func (p *Party) removeFromSuggestions(sid SongUID) error {
 // PlayNow plays a song right now.
 // Right now there's no error checking on this
 func (p *Party) PlayNow(uid UserUUID, sid SongUID) error {
+	return p.PlayNowWithDuration(uid, sid, 0)
+}
+
+// PlayNowWithDuration is PlayNow for a song the client says is duration
+// long, 0 if it doesn't know.
+func (p *Party) PlayNowWithDuration(uid UserUUID, sid SongUID, duration time.Duration) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventPlayNow, Actor: uid, Song: sid, DurationMs: durationMs(duration)})
 
 	if can, err := p.canUserPerformAction(uid, UserCanPlaySongNextPermission); err != nil {
 		return err
@@ -337,15 +1081,20 @@ func (p *Party) PlayNow(uid UserUUID, sid SongUID) error {
 		return fmt.Errorf("user can't play-next")
 	}
 
+	if err := p.learnSong(sid); err != nil {
+		return err
+	}
+
 	// try to remove from the queues
 	// don't do anything on error case
 	p.removeFromSuggestions(sid)
 	p.playNext.Remove(sid)
 
-	// play song now
+	// play song now, it needs its duration before it starts
+	p.offerSongDuration(uid, sid, duration)
 	p.playSong(sid)
 
-	p.setUpdated()
+	p.setUpdated(PullSuggestKey, PullPlayNextKey)
 
 	return nil
 }
@@ -359,14 +1108,19 @@ func (p *Party) doAddToPlayNext(uid UserUUID, sid SongUID) error {
 		return fmt.Errorf("user does not have permission to add to playnext")
 	}
 
+	if err := p.learnSong(sid); err != nil {
+		return err
+	}
+
 	return p.playNext.AddSong(sid)
 }
 
 // RemoveFromPlayNext removes a song from play next.
 // err is the song isn't there.
-func (p *Party) RemoveFromPlayNext(uid UserUUID, sid SongUID) error {
+func (p *Party) RemoveFromPlayNext(uid UserUUID, sid SongUID) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventRemoveFromPlayNext, Actor: uid, Song: sid})
 
 	// check permissions
 	if can, err := p.canUserPerformAction(uid, UserCanPlaySongNextPermission); err != nil {
@@ -381,7 +1135,7 @@ func (p *Party) RemoveFromPlayNext(uid UserUUID, sid SongUID) error {
 	}
 
 	// good remove
-	p.setUpdated()
+	p.setUpdated(PullPlayNextKey)
 
 	return nil
 }
@@ -389,9 +1143,10 @@ func (p *Party) RemoveFromPlayNext(uid UserUUID, sid SongUID) error {
 // Seek to a position in the song.
 // Error if there isn't anything playing or the user doesn't
 // have permission.
-func (p *Party) Seek(uid UserUUID, position float32) error {
+func (p *Party) Seek(uid UserUUID, position float32) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSeek, Actor: uid, Position: position})
 
 	// check if teh user can seek
 	can, err := p.canUserPerformAction(uid, UserCanSeekPermission)
@@ -404,43 +1159,215 @@ func (p *Party) Seek(uid UserUUID, position float32) error {
 	}
 
 	p.nowPlaying.Seek(position)
-	p.setUpdated()
+	p.setUpdated(PullPlayingKey)
 
 	return nil
 }
 
 // SongFinished is called when a song has finished playing.
-func (p *Party) SongFinished(uid UserUUID, sid SongUID) error {
+// Anyone can say a song finished once it has played for as long as it is.
+// If nobody knows how long it is, ending it is a skip.
+func (p *Party) SongFinished(uid UserUUID, sid SongUID) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSongFinished, Actor: uid, Song: sid, DurationMs: durationMs(p.nowPlaying.duration)})
+
+	return p.doSongFinished(uid, sid)
+}
+
+// replaySongFinished with the duration the song had, the catalog can change
+func (p *Party) replaySongFinished(uid UserUUID, sid SongUID, duration time.Duration) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSongFinished, Actor: uid, Song: sid, DurationMs: durationMs(duration)})
+
+	if err = p.checkPlaying(sid); err != nil {
+		return err
+	}
+
+	p.nowPlaying.SetDuration(duration)
+	return p.doSongFinished(uid, sid)
+}
+
+// doSongFinished does the work of SongFinished with the party locked
+func (p *Party) doSongFinished(uid UserUUID, sid SongUID) error {
+	if _, err := p.getUser(uid); err != nil {
+		return err
+	}
 
-	// TODO: check that user is owner
-	// TODO: check that current song actually ended
+	if err := p.checkPlaying(sid); err != nil {
+		return err
+	}
+
+	// clients can be a little ahead of the server's clock
+	if duration := p.nowPlaying.duration; duration > 0 {
+		if p.nowPlaying.Elapsed() < duration-songEndTolerance {
+			return fmt.Errorf("song %s isn't over yet", sid)
+		}
+	} else if can, err := p.canUserPerformAction(uid, UserCanSkipPermission); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user doesn't have permission to skip")
+	}
 
 	// play next song if there is one. This will update if there is a state change
 	return p.doPlayNextSong()
 }
 
+// Advance to the next song if the current one played all the way through.
+// The server calls this periodically so songs end on time without clients.
+// Error if the song isn't over.
+func (p *Party) Advance() (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventAdvance, Song: p.nowPlaying.GetCurrentlyPlaying(), DurationMs: durationMs(p.nowPlaying.duration)})
+
+	return p.doAdvance()
+}
+
+// replayAdvance with the duration the song had. Durations can come from the
+// catalog, which can change or not be set yet when the party is restored.
+func (p *Party) replayAdvance(sid SongUID, duration time.Duration) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventAdvance, Song: sid, DurationMs: durationMs(duration)})
+
+	if err = p.checkPlaying(sid); err != nil {
+		return err
+	}
+
+	p.nowPlaying.SetDuration(duration)
+	return p.doAdvance()
+}
+
+// doAdvance does the work of Advance with the party locked
+func (p *Party) doAdvance() error {
+	// replays happen at the time of the event, so the song is over then too
+	if !p.nowPlaying.Over() {
+		return fmt.Errorf("song isn't over")
+	}
+
+	return p.doPlayNextSong()
+}
+
+// SetSongDuration for a song that's in the party, so it can advance when
+// the song ends. Durations from the catalog win over ones from clients.
+// A short duration would skip the song when it plays, so anyone can say
+// how long a song is while nobody knows, but only users who can skip or
+// play songs now can change a known duration, or end the current song.
+// uid of person who knows the duration.
+func (p *Party) SetSongDuration(uid UserUUID, sid SongUID, duration time.Duration) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetSongDuration, Actor: uid, Song: sid, DurationMs: durationMs(duration)})
+
+	if _, err = p.getUser(uid); err != nil {
+		return err
+	}
+
+	if duration <= 0 {
+		return fmt.Errorf("bad duration")
+	}
+
+	if _, has := p.songsInParty()[sid]; !has {
+		return fmt.Errorf("song %s is not in the party", sid)
+	}
+
+	if can, err := p.canSetSongDuration(uid, sid, duration); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't change how long the song is")
+	}
+
+	p.setSongDuration(sid, duration)
+	return nil
+}
+
+// offerSongDuration from a client adding a song. It's set if the user could
+// set it with SetSongDuration, otherwise the known duration stays.
+func (p *Party) offerSongDuration(uid UserUUID, sid SongUID, duration time.Duration) {
+	if duration <= 0 {
+		return
+	}
+
+	if can, err := p.canSetSongDuration(uid, sid, duration); err == nil && can {
+		p.setSongDuration(sid, duration)
+	}
+}
+
+// canSetSongDuration checks if the user can say sid is duration long
+func (p *Party) canSetSongDuration(uid UserUUID, sid SongUID, duration time.Duration) (bool, error) {
+	playing := p.nowPlaying.CurrentlyHasSong() && p.nowPlaying.GetCurrentlyPlaying() == sid
+	if p.songDuration(sid) == 0 && !(playing && duration <= p.nowPlaying.Elapsed()) {
+		return true, nil
+	}
+
+	return p.canSkipOrPlayNow(uid)
+}
+
+// setSongDuration, the current song's clock needs to know
+func (p *Party) setSongDuration(sid SongUID, duration time.Duration) {
+	p.durations[sid] = duration
+
+	if p.nowPlaying.CurrentlyHasSong() && p.nowPlaying.GetCurrentlyPlaying() == sid {
+		p.nowPlaying.SetDuration(p.songDuration(sid))
+		p.setUpdated(PullPlayingKey)
+	}
+}
+
+// durationMs for events
+func durationMs(duration time.Duration) int64 {
+	return int64(duration / time.Millisecond)
+}
+
+// canSkipOrPlayNow checks if the user can change what's playing
+func (p *Party) canSkipOrPlayNow(uid UserUUID) (bool, error) {
+	if can, err := p.canUserPerformAction(uid, UserCanSkipPermission); err != nil || can {
+		return can, err
+	}
+
+	return p.canUserPerformAction(uid, UserCanPlaySongNextPermission)
+}
+
+// checkPlaying checks that sid is the current song, so calls about a song
+// that already ended are rejected
+func (p *Party) checkPlaying(sid SongUID) error {
+	if !p.nowPlaying.CurrentlyHasSong() {
+		return fmt.Errorf("nothing is playing")
+	}
+
+	if current := p.nowPlaying.GetCurrentlyPlaying(); current != sid {
+		return fmt.Errorf("song %s isn't playing, %s is", sid, current)
+	}
+
+	return nil
+}
+
 // Skip the currently playing song.
-func (p *Party) Skip(uid UserUUID, sid SongUID) error {
+func (p *Party) Skip(uid UserUUID, sid SongUID) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSkip, Actor: uid, Song: sid})
 
-	// TODO: check that they are on the actual current end song
 	if can, err := p.canUserPerformAction(uid, UserCanSkipPermission); err != nil {
 		return err
 	} else if !can {
 		return fmt.Errorf("user doesn't have permission to skip")
 	}
 
+	if err = p.checkPlaying(sid); err != nil {
+		return err
+	}
+
 	// play next song if there is one. This will update if there is a state change
 	return p.doPlayNextSong()
 }
 
 // Previous plays the previous song.
-func (p *Party) Previous(uid UserUUID, sid SongUID) error {
+func (p *Party) Previous(uid UserUUID, sid SongUID) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventPrevious, Actor: uid, Song: sid})
 
 	// TODO: check actual song
 	if can, err := p.canUserPerformAction(uid, UserCanSkipPermission); err != nil {
@@ -473,16 +1400,17 @@ func (p *Party) Previous(uid UserUUID, sid SongUID) error {
 	}
 
 	// set the currently playing
-	p.nowPlaying.ChangeSong(prevSid)
-	p.setUpdated()
+	p.changeSong(prevSid)
+	p.setUpdated(PullPlayingKey, PullPlayNextKey, PullHistoryKey)
 
 	return nil
 }
 
 // Pause the song
-func (p *Party) Pause(uid UserUUID, pos float32) error {
+func (p *Party) Pause(uid UserUUID, pos float32) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventPause, Actor: uid, Position: pos})
 
 	if can, err := p.canUserPerformAction(uid, UserCanPlayPausePermission); err != nil {
 		return err
@@ -494,14 +1422,15 @@ func (p *Party) Pause(uid UserUUID, pos float32) error {
 		return err
 	}
 
-	p.setUpdated()
+	p.setUpdated(PullPlayingKey)
 	return nil
 }
 
 // Play the song
-func (p *Party) Play(uid UserUUID) error {
+func (p *Party) Play(uid UserUUID) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventPlay, Actor: uid})
 
 	if can, err := p.canUserPerformAction(uid, UserCanPlayPausePermission); err != nil {
 		return err
@@ -513,14 +1442,15 @@ func (p *Party) Play(uid UserUUID) error {
 		return err
 	}
 
-	p.setUpdated()
+	p.setUpdated(PullPlayingKey)
 	return nil
 }
 
-// SetVolume sets the volume for the player
-func (p *Party) SetVolume(uid UserUUID, level uint32) error {
+// SetVolume sets the volume for a zone, or the party's player if zone is empty
+func (p *Party) SetVolume(uid UserUUID, zone string, level uint32) (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetVolume, Actor: uid, Zone: zone, Volume: level})
 
 	// check that the user can perform this action
 	if can, err := p.canUserPerformAction(uid, UserCanChangeVolumePermission); err != nil {
@@ -529,56 +1459,93 @@ func (p *Party) SetVolume(uid UserUUID, level uint32) error {
 		return fmt.Errorf("user can't change volume")
 	}
 
+	np, section := &p.nowPlaying, PullPlayingKey
+	if zone != "" {
+		z, err := p.getZone(zone)
+		if err != nil {
+			return err
+		}
+
+		np, section = &z.nowPlaying, PullZonesKey
+	}
+
 	// check for error, could be on bounds
-	if err := p.nowPlaying.SetVolume(level); err != nil {
+	if err := np.SetVolume(level); err != nil {
 		return err
 	}
 
-	p.setUpdated()
+	p.setUpdated(section)
 	return nil
 }
 
-// finds the next song to play.
+// finds the next song to play and the pull section it came from.
 // if an error was returned then no state changed
-func (p *Party) doGetNextSongToPlay() (SongUID, error) {
+func (p *Party) doGetNextSongToPlay() (SongUID, string, error) {
 	// first try to pop off of the playNext
 	if sid, err := p.playNext.Pop(); err == nil {
-		return sid, err
+		return sid, PullPlayNextKey, err
 	}
 
 	// failed to get from playNext, try suggestion
-	return p.suggestionQueue.Pop()
+	vse, err := p.suggestionQueue.pop()
+	if err != nil {
+		return "", PullSuggestKey, err
+	}
+
+	// counts towards the suggester's hourly limit
+	p.suggestedPlays[vse.suggestedBy] = append(p.recentPlays(vse.suggestedBy), p.now())
+
+	return vse.songID, PullSuggestKey, nil
 }
 
-// plays a song right now
-func (p *Party) playSong(nsid SongUID) {
+// plays a song right now.
+// sections are any other pull sections that changed along with playing
+func (p *Party) playSong(nsid SongUID, sections ...string) {
 	// get current song to add to back
 	csid := p.nowPlaying.GetCurrentlyPlaying()
 
 	havePlaying := p.nowPlaying.CurrentlyHasSong()
 
 	// now try to play the song
-	p.nowPlaying.ChangeSong(nsid)
+	p.changeSong(nsid)
 
 	if havePlaying {
 		p.previous.Push(csid)
+		sections = append(sections, PullHistoryKey)
 	}
 
 	// finally update state
-	p.setUpdated()
+	p.setUpdated(append(sections, PullPlayingKey)...)
+}
+
+// changeSong that's playing, the clock needs to know how long it is
+func (p *Party) changeSong(sid SongUID) {
+	p.nowPlaying.ChangeSong(sid)
+	p.nowPlaying.SetDuration(p.songDuration(sid))
+	p.clearSkipVotes()
+}
+
+// songDuration from the catalog, or from clients if the catalog doesn't know.
+// 0 if nobody knows.
+func (p *Party) songDuration(sid SongUID) time.Duration {
+	if info, has := p.songs[sid]; has && info.DurationMs > 0 {
+		return time.Duration(info.DurationMs) * time.Millisecond
+	}
+
+	return p.durations[sid]
 }
 
 // chooses and plays the next song.
 // Will update the state if there is a change
 func (p *Party) doPlayNextSong() error {
 
-	nsid, err := p.doGetNextSongToPlay()
+	nsid, section, err := p.doGetNextSongToPlay()
 
 	// if nil then we couldn't pull a song out of a queue
 	// close anything currently playing
 	if err != nil {
 
-		// TODO: may be out of songs, check to go to radio
+		// out of songs, the radio fills in if it's on, see FillRadio
 		if !p.nowPlaying.CurrentlyHasSong() {
 			return fmt.Errorf("no songs to play, nothing to skip")
 		}
@@ -588,24 +1555,148 @@ func (p *Party) doPlayNextSong() error {
 
 		// bad pop, but current song is still over, so we update
 		p.nowPlaying.SetNonePlaying()
+		p.clearSkipVotes()
 
-		p.setUpdated()
+		p.setUpdated(PullPlayingKey, PullHistoryKey)
 
 		// return error
 		return err
 	}
 
 	// go ahead and play the song now
-	p.playSong(nsid)
+	p.playSong(nsid, section)
 
 	return nil
 }
 
+// how many changes the party remembers for delta pulls.
+// Clients further behind than this get everything.
+const maxChangeHistory = 128
+
+// kinds of changes, so clients can tell what happened without diffing
+const (
+	ChangeSong        = "songChanged"
+	ChangeQueue       = "queueChanged"
+	ChangeVote        = "voteChanged"
+	ChangePermissions = "permissionsChanged"
+	ChangeZones       = "zonesChanged"
+	ChangeVeto        = "songVetoed"
+)
+
+// maps can't be const in go
+var (
+	// the kind of change for each pull section
+	sectionChangeKinds = map[string]string{
+		PullPlayingKey:    ChangeSong,
+		PullHistoryKey:    ChangeSong,
+		PullSuggestKey:    ChangeQueue,
+		PullPlayNextKey:   ChangeQueue,
+		PullPermissionKey: ChangePermissions,
+		PullZonesKey:      ChangeZones,
+		PullRadioKey:      ChangeQueue,
+		PullVetoedKey:     ChangeVeto,
+	}
+)
+
+// changeRecord is the pull sections touched by a change
+// and the kinds of change that happened
+type changeRecord struct {
+	changeID uint64
+	sections []string
+	kinds    []string
+}
+
 // updated increments the update tracker.
 // Should call this whenever there's an update everyone should know about.
-func (p *Party) setUpdated() {
+// sections are the pull keys of the data that changed.
+func (p *Party) setUpdated(sections ...string) {
+	kinds := make([]string, len(sections))
+	for i, section := range sections {
+		kinds[i] = sectionChangeKinds[section]
+	}
+
+	p.recordChange(sections, kinds)
+}
+
+// setVotesUpdated is setUpdated for when only votes changed
+func (p *Party) setVotesUpdated() {
+	p.recordChange([]string{PullSuggestKey}, []string{ChangeVote})
+}
+
+// recordChange increments the update tracker and remembers what changed
+func (p *Party) recordChange(sections []string, kinds []string) {
 	p.changeID++
-	p.lastChangeT = time.Now()
+	p.lastChangeT = p.now()
+
+	p.history = append(p.history, changeRecord{
+		changeID: p.changeID,
+		sections: sections,
+		kinds:    kinds,
+	})
+
+	if len(p.history) > maxChangeHistory {
+		p.history = p.history[len(p.history)-maxChangeHistory:]
+	}
+
+	p.changes.notify(p.changeID)
+}
+
+// historySince gets the changes made after changeID.
+// ok is false if the history doesn't go back far enough.
+func (p *Party) historySince(changeID uint64) (changes []changeRecord, ok bool) {
+	// the client has nothing, or we lost track of what changed
+	if changeID == 0 || len(p.history) == 0 || p.history[0].changeID > changeID+1 {
+		return nil, false
+	}
+
+	i := len(p.history)
+	for i > 0 && p.history[i-1].changeID > changeID {
+		i--
+	}
+
+	return p.history[i:], true
+}
+
+// sectionsSince finds the sections that changed after changeID.
+// ok is false if the history doesn't go back far enough.
+func (p *Party) sectionsSince(changeID uint64) (sections map[string]struct{}, ok bool) {
+	changes, ok := p.historySince(changeID)
+	if !ok {
+		return nil, false
+	}
+
+	sections = make(map[string]struct{})
+	for _, change := range changes {
+		for _, section := range change.sections {
+			sections[section] = struct{}{}
+		}
+	}
+
+	return sections, true
+}
+
+// ChangesSince returns the kinds of changes made after changeID.
+// ok is false if the history doesn't go back far enough, so anything may have changed.
+func (p *Party) ChangesSince(changeID uint64) (kinds []string, ok bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	changes, ok := p.historySince(changeID)
+	if !ok {
+		return nil, false
+	}
+
+	seen := make(map[string]struct{})
+	for _, change := range changes {
+		for _, kind := range change.kinds {
+			if _, has := seen[kind]; !has {
+				seen[kind] = struct{}{}
+				kinds = append(kinds, kind)
+			}
+		}
+	}
+
+	return kinds, true
 }
 
 // TimeSinceLastChange in duration
@@ -619,18 +1710,45 @@ func (p *Party) TimeSinceLastChange() time.Duration {
 // consts for pull
 const (
 	PullChangeKey     = "change"
+	PullFullKey       = "full"
 	PullPlayingKey    = "playing"
 	PullSuggestKey    = "suggest"
 	PullPermissionKey = "permissions"
 	PullPlayNextKey   = "playnext"
+	PullHistoryKey    = "history"
+	PullZonesKey      = "zones"
+	PullRadioKey      = "radio"
+	PullVetoedKey     = "vetoed"
+
+	// sent with the permissions section
+	PullMyPermissionsKey   = "myPermissions"
+	PullUserPermissionsKey = "userPermissions"
+	PullMyRoleKey          = "myRole"
+	PullRolesKey           = "roles"
+	PullBansKey            = "bans"
+	PullJoinKey            = "join"
+	PullSkipThresholdKey   = "skipThreshold"
 )
 
+// how many of the previous songs are pulled
+const historyPullSize = 20
+
+// how early a client can say a song finished
+const songEndTolerance = 5 * time.Second
+
 // Pull returns the user data in a serializable format.
+// Only the sections that changed since clientChangeID are included, unless
+// clientChangeID is 0 or too old, then everything is. PullFullKey says which.
 // NOTE: this checks for changes before checking uid.
 func (p *Party) Pull(userUUID UserUUID, clientChangeID uint64) (interface{}, error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
+	// pulling means the client is still around
+	if user, err := p.getUser(userUUID); err == nil {
+		user.lastSeen = p.now()
+	}
+
 	// if the client's change is larger than our current change
 	if p.changeID < clientChangeID {
 		return nil, fmt.Errorf("bad pull id")
@@ -646,12 +1764,74 @@ func (p *Party) Pull(userUUID UserUUID, clientChangeID uint64) (interface{}, err
 		return nil, err
 	}
 
+	sections, delta := p.sectionsSince(clientChangeID)
+
+	// include the section if we're sending everything or it changed
+	include := func(section string) bool {
+		if !delta {
+			return true
+		}
+
+		_, has := sections[section]
+		return has
+	}
+
 	data := make(map[string]interface{})
 	data[PullChangeKey] = p.changeID
-	data[PullPermissionKey] = p.permMap
-	data[PullPlayingKey] = p.nowPlaying.Data()
-	data[PullSuggestKey] = p.suggestionQueue.Pull(userUUID)
-	data[PullPlayNextKey] = p.playNext.Pull()
+	data[PullFullKey] = !delta
+
+	if include(PullPermissionKey) {
+		data[PullPermissionKey] = p.permissions()
+		data[PullMyPermissionsKey] = p.userPermissions(userUUID)
+		data[PullMyRoleKey] = p.roleOf(userUUID)
+		data[PullRolesKey] = p.userRoles()
+		data[PullSkipThresholdKey] = p.skipThresholdData()
+
+		// only users who can change overrides see them
+		if p.roleOf(userUUID).can(CapabilityManagePermissions) {
+			data[PullUserPermissionsKey] = p.userOverrides()
+		}
+
+		if p.roleOf(userUUID).can(CapabilityBan) {
+			data[PullBansKey] = p.bannedUsers()
+		}
+
+		if p.roleOf(userUUID).can(CapabilityManageInvites) {
+			data[PullJoinKey] = p.joinData()
+		}
+	}
+
+	if include(PullPlayingKey) {
+		data[PullPlayingKey] = p.nowPlaying.Data(p.songs)
+		p.addSkipData(data[PullPlayingKey], userUUID)
+	}
+
+	if include(PullHistoryKey) {
+		data[PullHistoryKey] = p.previous.Pull(historyPullSize, p.songs)
+	}
+
+	if include(PullSuggestKey) {
+		data[PullSuggestKey] = p.suggestionQueue.Pull(userUUID, p.songs)
+		p.addQuotaData(data[PullSuggestKey], userUUID)
+		p.addVetoRule(data[PullSuggestKey])
+		p.addScoring(data[PullSuggestKey])
+	}
+
+	if include(PullPlayNextKey) {
+		data[PullPlayNextKey] = p.playNext.Pull(p.songs)
+	}
+
+	if include(PullZonesKey) {
+		data[PullZonesKey] = p.zoneData()
+	}
+
+	if include(PullRadioKey) {
+		data[PullRadioKey] = p.radioData()
+	}
+
+	if include(PullVetoedKey) {
+		data[PullVetoedKey] = p.vetoedData()
+	}
 
 	return data, nil
 }
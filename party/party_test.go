@@ -6,6 +6,7 @@ import (
 	"github.com/me-next/menext-backend/party"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestPartyUserAdd(t *testing.T) {
@@ -33,8 +34,12 @@ func TestPartyUserAdd(t *testing.T) {
 	// check that owner was inserted
 	assert.NotNil(t, p.AddUser(ownerUUID, "fred"))
 
-	// check that we can't remove owners
-	assert.NotNil(t, p.RemoveUser(ownerUUID))
+	// the owner leaving hands the party to the next in line
+	assert.Nil(t, p.RemoveUser(ownerUUID))
+	assert.True(t, p.CanUserEndParty(user))
+
+	// the last user can't leave
+	assert.NotNil(t, p.RemoveUser(user))
 }
 
 func TestPartyCanRemove(t *testing.T) {
@@ -148,6 +153,283 @@ func TestPartyPermissions(t *testing.T) {
 	}
 }
 
+func TestPartyPullPermissionsCopy(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.Suggest(ouid, "a"))
+
+	// pulls are encoded without the lock, so they can't share the party's map
+	raw, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	perms := raw.(map[string]interface{})[party.PullPermissionKey].(map[string]bool)
+	assert.True(t, perms[party.UserCanSeekPermission])
+
+	assert.Nil(t, p.SetPermission(party.UserCanSeekPermission, false, ouid))
+	assert.True(t, perms[party.UserCanSeekPermission])
+}
+
+func TestPartyUserPermissions(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	fuid := party.UserUUID("2")
+	assert.Nil(t, p.AddUser(fuid, "fred"))
+
+	// pulls the user's effective permissions
+	myPermissions := func(uid party.UserUUID) map[string]bool {
+		raw, err := p.Pull(uid, 0)
+		assert.Nil(t, err)
+		return raw.(map[string]interface{})[party.PullMyPermissionsKey].(map[string]bool)
+	}
+
+	// party wide off, user override on
+	assert.Nil(t, p.SetPermission(party.UserCanSkipPermission, false, ouid))
+	assert.False(t, myPermissions(fuid)[party.UserCanSkipPermission])
+
+	assert.Nil(t, p.SetUserPermission(ouid, fuid, party.UserCanSkipPermission, true))
+	assert.True(t, myPermissions(fuid)[party.UserCanSkipPermission])
+
+	// party wide on, user override off
+	assert.Nil(t, p.SetUserPermission(ouid, fuid, party.UserCanSuggestSongPermission, false))
+	assert.NotNil(t, p.Suggest(fuid, "a"))
+	assert.Nil(t, p.Suggest(ouid, "a"))
+
+	// the owner sees the overrides, others don't
+	raw, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	overrides := raw.(map[string]interface{})[party.PullUserPermissionsKey].(map[party.UserUUID]map[string]bool)
+	assert.Equal(t, map[string]bool{
+		party.UserCanSkipPermission:        true,
+		party.UserCanSuggestSongPermission: false,
+	}, overrides[fuid])
+
+	raw, err = p.Pull(fuid, 0)
+	assert.Nil(t, err)
+	assert.NotContains(t, raw, party.PullUserPermissionsKey)
+
+	// clearing goes back to the party's permission
+	assert.Nil(t, p.ClearUserPermission(ouid, fuid, party.UserCanSuggestSongPermission))
+	assert.Nil(t, p.Suggest(fuid, "b"))
+
+	// bad sets
+	assert.NotNil(t, p.SetUserPermission(fuid, fuid, party.UserCanSeekPermission, true))
+	assert.NotNil(t, p.SetUserPermission(ouid, "bad", party.UserCanSeekPermission, true))
+	assert.NotNil(t, p.SetUserPermission(ouid, fuid, "bad", true))
+	assert.NotNil(t, p.SetUserPermission(ouid, ouid, party.UserCanSeekPermission, false))
+	assert.NotNil(t, p.SetUserPermission(ouid, fuid, party.UserCanSkipPermission, true))
+	assert.NotNil(t, p.ClearUserPermission(ouid, fuid, party.UserCanSuggestSongPermission))
+	assert.NotNil(t, p.ClearUserPermission(fuid, fuid, party.UserCanSkipPermission))
+}
+
+func TestPartyRoles(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	cuid := party.UserUUID("cohost")
+	muid := party.UserUUID("mod")
+	guid := party.UserUUID("guest")
+	assert.Nil(t, p.AddUser(cuid, "carl"))
+	assert.Nil(t, p.AddUser(muid, "mary"))
+	assert.Nil(t, p.AddUser(guid, "gary"))
+
+	// lock the party down
+	assert.Nil(t, p.SetPermission(party.UserCanSuggestSongPermission, false, ouid))
+	assert.Nil(t, p.SetPermission(party.UserCanSkipPermission, false, ouid))
+
+	// only the owner promotes, and not to owner
+	assert.NotNil(t, p.SetRole(guid, cuid, party.RoleCoHost))
+	assert.NotNil(t, p.SetRole(ouid, cuid, party.RoleOwner))
+	assert.NotNil(t, p.SetRole(ouid, cuid, "bad"))
+	assert.NotNil(t, p.SetRole(ouid, ouid, party.RoleGuest))
+	assert.Nil(t, p.SetRole(ouid, cuid, party.RoleCoHost))
+	assert.NotNil(t, p.SetRole(ouid, cuid, party.RoleCoHost))
+	assert.Nil(t, p.SetRole(ouid, muid, party.RoleModerator))
+	assert.NotNil(t, p.SetRole(cuid, guid, party.RoleModerator))
+
+	// roles grant permissions the party doesn't
+	assert.NotNil(t, p.Suggest(guid, "a"))
+	assert.Nil(t, p.Suggest(muid, "a"))
+	assert.Nil(t, p.Suggest(cuid, "b"))
+	assert.NotNil(t, p.Skip(muid, "a"))
+	assert.Nil(t, p.Skip(cuid, "a"))
+
+	// but overrides still win
+	assert.Nil(t, p.SetUserPermission(ouid, cuid, party.UserCanSkipPermission, false))
+	assert.NotNil(t, p.Skip(cuid, "b"))
+
+	// co-hosts manage permissions for lower ranks
+	assert.Nil(t, p.SetPermission(party.UserCanSeekPermission, false, cuid))
+	assert.NotNil(t, p.SetPermission(party.UserCanSeekPermission, true, muid))
+	assert.Nil(t, p.SetUserPermission(cuid, guid, party.UserCanSeekPermission, true))
+	assert.Nil(t, p.SetUserPermission(cuid, muid, party.UserCanSeekPermission, true))
+	assert.NotNil(t, p.SetUserPermission(cuid, ouid, party.UserCanSeekPermission, true))
+	assert.NotNil(t, p.SetUserPermission(cuid, cuid, party.UserCanSeekPermission, true))
+
+	// only the owner ends the party
+	assert.True(t, p.CanUserEndParty(ouid))
+	assert.False(t, p.CanUserEndParty(cuid))
+
+	// everyone sees the roles
+	raw, err := p.Pull(guid, 0)
+	assert.Nil(t, err)
+	data := raw.(map[string]interface{})
+	assert.Equal(t, party.RoleGuest, data[party.PullMyRoleKey])
+	assert.Equal(t, map[party.UserUUID]party.Role{
+		ouid: party.RoleOwner,
+		cuid: party.RoleCoHost,
+		muid: party.RoleModerator,
+	}, data[party.PullRolesKey])
+
+	// demoting takes the grants away
+	assert.Nil(t, p.SetRole(ouid, muid, party.RoleGuest))
+	assert.NotNil(t, p.Suggest(muid, "c"))
+
+	// removing someone with a role changes the roles
+	raw, err = p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	cid := raw.(map[string]interface{})[party.PullChangeKey].(uint64)
+
+	assert.Nil(t, p.RemoveUser(cuid))
+	raw, err = p.Pull(ouid, cid)
+	assert.Nil(t, err)
+	assert.NotContains(t, raw.(map[string]interface{})[party.PullRolesKey], cuid)
+}
+
+func TestPartyTransferOwnership(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	fuid := party.UserUUID("2")
+	assert.Nil(t, p.AddUser(fuid, "fred"))
+
+	assert.NotNil(t, p.TransferOwnership(fuid, fuid))
+	assert.NotNil(t, p.TransferOwnership(ouid, "bad"))
+	assert.NotNil(t, p.TransferOwnership(ouid, ouid))
+	assert.Nil(t, p.TransferOwnership(ouid, fuid))
+
+	assert.True(t, p.CanUserEndParty(fuid))
+	assert.False(t, p.CanUserEndParty(ouid))
+
+	// the old owner is a co-host
+	raw, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	data := raw.(map[string]interface{})
+	assert.Equal(t, party.RoleCoHost, data[party.PullMyRoleKey])
+	assert.Equal(t, party.RoleOwner, data[party.PullRolesKey].(map[party.UserUUID]party.Role)[fuid])
+
+	assert.NotNil(t, p.SetOwner(fuid))
+	assert.NotNil(t, p.SetOwner("bad"))
+	assert.Nil(t, p.SetOwner(ouid))
+	assert.True(t, p.CanUserEndParty(ouid))
+}
+
+func TestPartyFailover(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	g1 := party.UserUUID("g1")
+	g2 := party.UserUUID("g2")
+	cuid := party.UserUUID("cohost")
+	assert.Nil(t, p.AddUser(g1, "gary"))
+	assert.Nil(t, p.AddUser(g2, "greg"))
+	assert.Nil(t, p.AddUser(cuid, "carl"))
+	assert.Nil(t, p.SetRole(ouid, cuid, party.RoleCoHost))
+
+	timeout := 20 * time.Millisecond
+
+	// the owner was just here
+	_, changed := p.FailoverOwner(time.Hour)
+	assert.False(t, changed)
+
+	// co-hosts go first
+	time.Sleep(2 * timeout)
+	for _, uid := range []party.UserUUID{g1, g2, cuid} {
+		assert.Nil(t, p.Heartbeat(uid))
+	}
+
+	owner, changed := p.FailoverOwner(timeout)
+	assert.True(t, changed)
+	assert.Equal(t, cuid, owner)
+	assert.True(t, p.CanUserEndParty(cuid))
+
+	// then whoever has been around longest, as long as they're still around
+	time.Sleep(2 * timeout)
+	for _, uid := range []party.UserUUID{g1, g2} {
+		_, err := p.Pull(uid, 0)
+		assert.Nil(t, err)
+	}
+
+	owner, changed = p.FailoverOwner(timeout)
+	assert.True(t, changed)
+	assert.Equal(t, g1, owner)
+
+	// no one to take over
+	time.Sleep(2 * timeout)
+	_, changed = p.FailoverOwner(timeout)
+	assert.False(t, changed)
+}
+
+func TestPartyKickBan(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	muid := party.UserUUID("mod")
+	guid := party.UserUUID("guest")
+	assert.Nil(t, p.AddUser(muid, "mary"))
+	assert.Nil(t, p.AddUser(guid, "gary"))
+	assert.Nil(t, p.SetRole(ouid, muid, party.RoleModerator))
+
+	// guests can't kick, no one can kick up
+	assert.NotNil(t, p.Kick(guid, muid))
+	assert.NotNil(t, p.Kick(muid, ouid))
+	assert.NotNil(t, p.Kick(muid, "bad"))
+
+	// kicked users can come back
+	assert.Nil(t, p.Kick(muid, guid))
+	assert.False(t, p.HasUser(guid))
+	assert.Nil(t, p.AddUser(guid, "gary"))
+
+	// banning cleans up their songs and votes
+	assert.Nil(t, p.Suggest(ouid, "a"))
+	assert.Nil(t, p.Suggest(ouid, "d"))
+	assert.Nil(t, p.Suggest(guid, "b"))
+	assert.Nil(t, p.Suggest(guid, "c"))
+	assert.Nil(t, p.SuggestionUpvote(guid, "d"))
+
+	assert.NotNil(t, p.Ban(guid, muid))
+	assert.Nil(t, p.Ban(muid, guid))
+	assert.NotNil(t, p.Ban(muid, guid))
+	assert.False(t, p.HasUser(guid))
+	assert.NotNil(t, p.AddUser(guid, "gary"))
+
+	raw, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	data := raw.(map[string]interface{})
+	assert.Equal(t, []party.UserUUID{guid}, data[party.PullBansKey])
+
+	// b and c were theirs, and their vote on d is gone
+	suggestions := data[party.PullSuggestKey].(map[string]interface{})
+	songs := suggestions["songs"].([]interface{})
+	assert.Len(t, songs, 1)
+	assert.Equal(t, party.SongUID("d"), songs[0].(map[string]interface{})["id"])
+	assert.Equal(t, 1, songs[0].(map[string]interface{})["totalVotes"])
+
+	// guests don't see the ban list
+	assert.Nil(t, p.AddUser("other", "olive"))
+	raw, err = p.Pull("other", 0)
+	assert.Nil(t, err)
+	assert.NotContains(t, raw, party.PullBansKey)
+
+	// users who aren't around can be banned
+	assert.Nil(t, p.Ban(ouid, "stranger"))
+	assert.NotNil(t, p.AddUser("stranger", "sam"))
+
+	assert.NotNil(t, p.Unban(guid, guid))
+	assert.Nil(t, p.Unban(muid, guid))
+	assert.NotNil(t, p.Unban(muid, guid))
+	assert.Nil(t, p.AddUser(guid, "gary"))
+}
+
 func TestPartySkipPrev(t *testing.T) {
 	ouid := party.UserUUID("1")
 	p := party.New(ouid, "bob")
@@ -247,3 +529,166 @@ func TestPartyRemoveSuggest(t *testing.T) {
 	assert.Nil(t, p.Suggest(ouid, "b"))
 	assert.NotNil(t, p.Suggest(ouid, "b"))
 }
+
+func TestPartyDeltaPull(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	// pulls the data as a map
+	pull := func(cid uint64) map[string]interface{} {
+		raw, err := p.Pull(ouid, cid)
+		assert.Nil(t, err)
+		return raw.(map[string]interface{})
+	}
+
+	// change 1 plays a right away
+	assert.Nil(t, p.Suggest(ouid, "a"))
+
+	// new clients get everything
+	data := pull(0)
+	assert.Equal(t, true, data[party.PullFullKey])
+	assert.Contains(t, data, party.PullPermissionKey)
+	assert.Contains(t, data, party.PullPlayingKey)
+	assert.Contains(t, data, party.PullSuggestKey)
+	assert.Contains(t, data, party.PullPlayNextKey)
+
+	// change 2 only touches the suggestions
+	assert.Nil(t, p.Suggest(ouid, "b"))
+	data = pull(1)
+	assert.Equal(t, false, data[party.PullFullKey])
+	assert.EqualValues(t, 2, data[party.PullChangeKey])
+	assert.Contains(t, data, party.PullSuggestKey)
+	assert.NotContains(t, data, party.PullPlayingKey)
+	assert.NotContains(t, data, party.PullPermissionKey)
+	assert.NotContains(t, data, party.PullPlayNextKey)
+
+	// change 3 is a permission, being further behind gets both
+	assert.Nil(t, p.SetPermission(party.UserCanSeekPermission, false, ouid))
+	data = pull(1)
+	assert.Contains(t, data, party.PullSuggestKey)
+	assert.Contains(t, data, party.PullPermissionKey)
+	assert.NotContains(t, data, party.PullPlayingKey)
+
+	data = pull(2)
+	assert.NotContains(t, data, party.PullSuggestKey)
+	assert.Contains(t, data, party.PullPermissionKey)
+
+	// skipping changes playing and where the song came from
+	assert.Nil(t, p.Skip(ouid, "a"))
+	data = pull(3)
+	assert.Contains(t, data, party.PullPlayingKey)
+	assert.Contains(t, data, party.PullSuggestKey)
+	assert.NotContains(t, data, party.PullPlayNextKey)
+
+	// fall back to everything once the client is too far behind
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, p.SetVolume(ouid, "", uint32(i%100)))
+	}
+
+	data = pull(3)
+	assert.Equal(t, true, data[party.PullFullKey])
+	assert.Contains(t, data, party.PullSuggestKey)
+
+	data = pull(150)
+	assert.Equal(t, false, data[party.PullFullKey])
+	assert.NotContains(t, data, party.PullSuggestKey)
+	assert.Contains(t, data, party.PullPlayingKey)
+}
+
+func TestPartyChangesSince(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	// nothing to go on
+	_, ok := p.ChangesSince(0)
+	assert.False(t, ok)
+
+	assert.Nil(t, p.Suggest(ouid, "a"))
+	assert.Nil(t, p.Suggest(ouid, "b"))
+	assert.Nil(t, p.SuggestionUpvote(ouid, "b"))
+	assert.Nil(t, p.SetPermission(party.UserCanSkipPermission, false, ouid))
+
+	kinds, ok := p.ChangesSince(2)
+	assert.True(t, ok)
+	assert.Equal(t, []string{party.ChangeVote, party.ChangePermissions}, kinds)
+
+	kinds, ok = p.ChangesSince(1)
+	assert.True(t, ok)
+	assert.Equal(t, []string{party.ChangeQueue, party.ChangeVote, party.ChangePermissions}, kinds)
+
+	kinds, ok = p.ChangesSince(4)
+	assert.True(t, ok)
+	assert.Empty(t, kinds)
+}
+
+func TestPartyQueueOrder(t *testing.T) {
+	ouid := party.UserUUID("1")
+	guid := party.UserUUID("2")
+
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.AddUser(guid, "gary"))
+
+	assert.NotNil(t, p.SetQueueOrder(guid, party.OrderRoundRobin))
+	assert.NotNil(t, p.SetQueueOrder(ouid, "shuffle"))
+	assert.Nil(t, p.SetQueueOrder(ouid, party.OrderRoundRobin))
+
+	// the first song plays right away, then gary gets a turn
+	assert.Nil(t, p.Suggest(ouid, "a"))
+	assert.Nil(t, p.Suggest(ouid, "b"))
+	assert.Nil(t, p.Suggest(ouid, "c"))
+	assert.Nil(t, p.Suggest(guid, "d"))
+	assert.Nil(t, p.SuggestionUpvote(guid, "c"))
+
+	raw, err := p.Pull(guid, 0)
+	assert.Nil(t, err)
+	suggestions := raw.(map[string]interface{})[party.PullSuggestKey].(map[string]interface{})
+	assert.Equal(t, party.OrderRoundRobin, suggestions["order"])
+
+	songs := suggestions["songs"].([]interface{})
+	assert.Len(t, songs, 3)
+	assert.Equal(t, party.SongUID("d"), songs[0].(map[string]interface{})["id"])
+	assert.Equal(t, party.SongUID("c"), songs[1].(map[string]interface{})["id"])
+
+	assert.Nil(t, p.Skip(ouid, "a"))
+	actual, err := getCurrentlyPlaying(p, ouid)
+	assert.Nil(t, err)
+	assert.Equal(t, party.SongUID("d"), actual)
+
+	// turns survive snapshots
+	restored := party.Restore(p.Snapshot())
+	expectedData, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	actualData, err := restored.Pull(ouid, 0)
+	assert.Nil(t, err)
+	assert.Equal(t,
+		expectedData.(map[string]interface{})[party.PullSuggestKey],
+		actualData.(map[string]interface{})[party.PullSuggestKey])
+}
+
+func TestPartyQueueOrderReplay(t *testing.T) {
+	ouid := party.UserUUID("1")
+	guid := party.UserUUID("2")
+
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.AddUser(guid, "gary"))
+	var events []party.Event
+	base := captureEvents(p, &events)
+
+	assert.Nil(t, p.SetQueueOrder(ouid, party.OrderRoundRobin))
+	assert.Nil(t, p.Suggest(ouid, "a"))
+	assert.Nil(t, p.Suggest(ouid, "b"))
+	assert.Nil(t, p.Suggest(ouid, "c"))
+	assert.Nil(t, p.Suggest(guid, "d"))
+	assert.Nil(t, p.Skip(ouid, "a"))
+
+	restored := replay(t, base, events)
+
+	expected, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	actual, err := restored.Pull(ouid, 0)
+	assert.Nil(t, err)
+	assert.Equal(t,
+		expected.(map[string]interface{})[party.PullSuggestKey],
+		actual.(map[string]interface{})[party.PullSuggestKey])
+	assert.Equal(t, p.Snapshot().Suggestions.Served, restored.Snapshot().Suggestions.Served)
+}
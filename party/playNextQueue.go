@@ -69,8 +69,9 @@ func (pnq *PlayNextQueue) Remove(sid SongUID) error {
 }
 
 // Pull the values in the PlayNextQueue.
-// Returns the next items in play order
-func (pnq PlayNextQueue) Pull() interface{} {
+// Returns the next items in play order, with info if the catalog has it.
+// catalog may be nil.
+func (pnq PlayNextQueue) Pull(catalog Catalog) interface{} {
 
 	ret := make(map[string]interface{})
 
@@ -79,8 +80,7 @@ func (pnq PlayNextQueue) Pull() interface{} {
 	elem := pnq.songs.Front()
 	i := 0
 	for elem != nil {
-		data := map[string]SongUID{"id": elem.Value.(SongUID)}
-		vals[i] = data
+		vals[i] = songData(elem.Value.(SongUID), catalog)
 
 		elem = elem.Next()
 		i++
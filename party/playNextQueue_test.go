@@ -38,6 +38,6 @@ func TestPlayNextQueuePull(t *testing.T) {
 
 	// good add
 	q.AddSong("a")
-	t.Log(q.Pull())
+	t.Log(q.Pull(nil))
 
 }
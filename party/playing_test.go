@@ -5,6 +5,7 @@ import (
 	"github.com/me-next/menext-backend/party"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func getCurrentlyPlaying(p *party.Party, ouid party.UserUUID) (
@@ -52,3 +53,158 @@ func TestPlaySongOrderCorrect(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, party.SongUID("e"), actual)
 }
+
+// fakeClock a party runs on, it only moves when the test moves it
+type fakeClock struct {
+	now time.Time
+}
+
+// newFakeClock for p, set before anything plays
+func newFakeClock(p *party.Party) *fakeClock {
+	clock := &fakeClock{now: time.Now()}
+	p.SetClock(func() time.Time {
+		return clock.now
+	})
+
+	return clock
+}
+
+// advance the clock by d
+func (c *fakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestAdvanceWhenSongEnds(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+	clock := newFakeClock(p)
+
+	assert.Nil(t, p.PlayNext(ouid, "a"))
+	assert.Nil(t, p.PlayNext(ouid, "b"))
+
+	// nobody knows how long a is, so it never ends by itself
+	assert.NotNil(t, p.Advance())
+
+	assert.Nil(t, p.SetSongDuration(ouid, "a", 50*time.Millisecond))
+	assert.NotNil(t, p.SetSongDuration(ouid, "a", 0))
+	assert.NotNil(t, p.SetSongDuration(ouid, "nope", time.Second))
+	assert.NotNil(t, p.Advance())
+
+	// paused songs don't end
+	assert.Nil(t, p.Pause(ouid, 0))
+	clock.advance(100 * time.Millisecond)
+	assert.NotNil(t, p.Advance())
+
+	assert.Nil(t, p.Play(ouid))
+	clock.advance(100 * time.Millisecond)
+	assert.Nil(t, p.Advance())
+
+	actual, err := getCurrentlyPlaying(p, ouid)
+	assert.Nil(t, err)
+	assert.Equal(t, party.SongUID("b"), actual)
+}
+
+func TestAdvanceReplayWithoutCatalog(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+	clock := newFakeClock(p)
+	p.SetCatalog(party.NewMemoryCatalog(party.SongInfo{ID: "a", DurationMs: 1000}))
+
+	var events []party.Event
+	base := captureEvents(p, &events)
+
+	assert.Nil(t, p.PlayNext(ouid, "a"))
+	assert.Nil(t, p.PlayNext(ouid, "b"))
+	clock.advance(time.Second)
+	assert.Nil(t, p.Advance())
+
+	// the duration came from a catalog the restored party doesn't have yet
+	restored := replay(t, base, events)
+	actual, err := getCurrentlyPlaying(restored, ouid)
+	assert.Nil(t, err)
+	assert.Equal(t, party.SongUID("b"), actual)
+	assert.Equal(t, p.Snapshot().ChangeID, restored.Snapshot().ChangeID)
+}
+
+func TestPlaybackPosition(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	assert.Nil(t, p.PlayNext(ouid, "a"))
+	assert.Nil(t, p.SetSongDuration(ouid, "a", time.Minute))
+	assert.Nil(t, p.Seek(ouid, 10))
+
+	raw, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	playing := raw.(map[string]interface{})[party.PullPlayingKey].(map[string]interface{})
+	assert.EqualValues(t, 60000, playing[party.KDurationMs])
+	assert.InDelta(t, 10, playing[party.KPosition], 0.5)
+}
+
+func TestStaleSongCalls(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	assert.NotNil(t, p.SongFinished(ouid, "a"))
+
+	assert.Nil(t, p.PlayNext(ouid, "a"))
+	assert.Nil(t, p.PlayNext(ouid, "b"))
+	assert.Nil(t, p.PlayNext(ouid, "c"))
+
+	// two clients skip a at once, only one skip counts
+	assert.Nil(t, p.Skip(ouid, "a"))
+	assert.NotNil(t, p.Skip(ouid, "a"))
+
+	// b can't finish a minute early
+	assert.Nil(t, p.SetSongDuration(ouid, "b", time.Minute))
+	assert.NotNil(t, p.SongFinished(ouid, "b"))
+	assert.NotNil(t, p.SongFinished(ouid, "c"))
+
+	actual, err := getCurrentlyPlaying(p, ouid)
+	assert.Nil(t, err)
+	assert.Equal(t, party.SongUID("b"), actual)
+}
+
+func TestSongEndPermissions(t *testing.T) {
+	ouid := party.UserUUID("1")
+	guid := party.UserUUID("2")
+
+	p := party.New(ouid, "bob")
+	clock := newFakeClock(p)
+	assert.Nil(t, p.AddUser(guid, "gary"))
+	assert.Nil(t, p.PlayNext(ouid, "a"))
+	assert.Nil(t, p.PlayNext(ouid, "b"))
+	assert.Nil(t, p.PlayNext(ouid, "c"))
+	assert.Nil(t, p.SetPermission(party.UserCanSkipPermission, false, ouid))
+	assert.Nil(t, p.SetPermission(party.UserCanPlaySongNextPermission, false, ouid))
+
+	// guests can't skip by saying a song nobody knows the length of finished
+	assert.NotNil(t, p.SongFinished(guid, "a"))
+
+	// or by giving a duration that already ended
+	clock.advance(10 * time.Millisecond)
+	assert.NotNil(t, p.SetSongDuration(guid, "a", time.Millisecond))
+
+	// they can say how long it is if nobody has yet, but not change it
+	assert.Nil(t, p.SetSongDuration(guid, "a", time.Minute))
+	assert.NotNil(t, p.SetSongDuration(guid, "a", time.Second))
+	assert.Nil(t, p.SetSongDuration(ouid, "a", time.Second))
+
+	// songs that aren't playing yet too, they'd end as soon as they start
+	assert.Nil(t, p.SetSongDuration(guid, "b", time.Minute))
+	assert.NotNil(t, p.SetSongDuration(guid, "b", time.Second))
+
+	// durations offered with a song don't change known ones
+	assert.Nil(t, p.SuggestWithDuration(guid, "b", time.Second))
+	assert.Nil(t, p.SuggestWithDuration(guid, "d", time.Minute))
+	assert.NotNil(t, p.SetSongDuration(guid, "d", time.Second))
+
+	// anyone can say a song finished once it played all the way through
+	clock.advance(time.Second)
+	assert.Nil(t, p.SongFinished(guid, "a"))
+	assert.NotNil(t, p.SongFinished(guid, "b"))
+
+	assert.Nil(t, p.SetPermission(party.UserCanSkipPermission, true, ouid))
+	assert.Nil(t, p.SetSongDuration(guid, "b", time.Millisecond))
+	assert.Nil(t, p.SongFinished(guid, "b"))
+}
@@ -24,6 +24,17 @@ func (s *PreviousStack) Push(song SongUID) {
 	s.songs.PushBack(song)
 }
 
+// Pull the most recent songs, newest first, with info if the catalog has it.
+// At most limit songs are returned. catalog may be nil.
+func (s PreviousStack) Pull(limit int, catalog Catalog) interface{} {
+	vals := make([]interface{}, 0, limit)
+	for elem := s.songs.Back(); elem != nil && len(vals) < limit; elem = elem.Prev() {
+		vals = append(vals, songData(elem.Value.(SongUID), catalog))
+	}
+
+	return map[string]interface{}{"songs": vals}
+}
+
 // Pop a song off of the stack
 func (s *PreviousStack) Pop() (SongUID, error) {
 	if s.songs.Len() == 0 {
@@ -0,0 +1,189 @@
+package party
+
+// this file has the limits on how much one user can suggest
+
+import (
+	"fmt"
+	"time"
+)
+
+// SuggestionLimits cap how much each guest can suggest. 0 is no limit.
+// Users who can change the limits don't have them.
+type SuggestionLimits struct {
+	// songs a user can have waiting in the suggestion queue
+	MaxPending int `json:"maxPending,omitempty"`
+
+	// time between a user's suggestions
+	CooldownMs int64 `json:"cooldownMs,omitempty"`
+
+	// songs a user suggested that played in the last hour, or are waiting
+	MaxPerHour int `json:"maxPerHour,omitempty"`
+}
+
+// cooldown between suggestions
+func (l SuggestionLimits) cooldown() time.Duration {
+	return time.Duration(l.CooldownMs) * time.Millisecond
+}
+
+// reasons a suggestion can go over the limits
+const (
+	QuotaPending  = "pending"
+	QuotaCooldown = "cooldown"
+	QuotaHourly   = "hourly"
+)
+
+// QuotaError is returned when a suggestion goes over a limit.
+// RetryAt is when the user can suggest again, it's zero if that depends on
+// their songs playing.
+type QuotaError struct {
+	Reason  string
+	Limit   int
+	RetryAt time.Time
+}
+
+func (e *QuotaError) Error() string {
+	switch e.Reason {
+	case QuotaPending:
+		return fmt.Sprintf("already %d songs waiting, suggest again after one plays", e.Limit)
+	case QuotaCooldown:
+		return fmt.Sprintf("suggesting too fast, suggest again at %s", e.RetryAt.Format(time.RFC3339))
+	}
+
+	if e.RetryAt.IsZero() {
+		return fmt.Sprintf("%d songs an hour at most, suggest again after your songs play", e.Limit)
+	}
+
+	return fmt.Sprintf("%d songs an hour at most, suggest again at %s", e.Limit, e.RetryAt.Format(time.RFC3339))
+}
+
+// consts for pulling the limits, sent with the suggestion queue
+const (
+	KQuota         = "quota"
+	KLimits        = "limits"
+	KPendingLeft   = "pendingLeft"
+	KHourlyLeft    = "hourlyLeft"
+	KNextSuggestMs = "nextSuggestMs"
+)
+
+// the window MaxPerHour counts over
+const quotaWindow = time.Hour
+
+// SetSuggestionLimits for guests.
+// uid of person changing the limits.
+func (p *Party) SetSuggestionLimits(uid UserUUID, limits SuggestionLimits) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetSuggestionLimits, Actor: uid, Limits: &limits})
+
+	if can, err := p.canUserDo(uid, CapabilityManageQueue); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't change the suggestion limits")
+	}
+
+	if limits.MaxPending < 0 || limits.CooldownMs < 0 || limits.MaxPerHour < 0 {
+		return fmt.Errorf("limits can't be negative")
+	}
+
+	p.limits = limits
+	p.setUpdated(PullSuggestKey)
+
+	return nil
+}
+
+// hasLimits checks if the limits apply to a user
+func (p *Party) hasLimits(uid UserUUID) bool {
+	return !p.roleOf(uid).can(CapabilityManageQueue)
+}
+
+// recentPlays of the user's suggestions in the quota window, oldest first.
+// Forgets older ones.
+func (p *Party) recentPlays(uid UserUUID) []time.Time {
+	plays := p.suggestedPlays[uid]
+
+	start := 0
+	for start < len(plays) && p.now().Sub(plays[start]) >= quotaWindow {
+		start++
+	}
+
+	if start == len(plays) {
+		delete(p.suggestedPlays, uid)
+		return nil
+	}
+
+	p.suggestedPlays[uid] = plays[start:]
+	return plays[start:]
+}
+
+// checkSuggestionLimits returns an error if the user can't suggest right now
+func (p *Party) checkSuggestionLimits(uid UserUUID) *QuotaError {
+	if !p.hasLimits(uid) {
+		return nil
+	}
+
+	pending := p.suggestionQueue.PendingBy(uid)
+	if p.limits.MaxPending > 0 && pending >= p.limits.MaxPending {
+		return &QuotaError{Reason: QuotaPending, Limit: p.limits.MaxPending}
+	}
+
+	if last, has := p.lastSuggested[uid]; has && p.limits.CooldownMs > 0 {
+		if retry := last.Add(p.limits.cooldown()); p.now().Before(retry) {
+			return &QuotaError{Reason: QuotaCooldown, RetryAt: retry}
+		}
+	}
+
+	plays := p.recentPlays(uid)
+	if p.limits.MaxPerHour > 0 && len(plays)+pending >= p.limits.MaxPerHour {
+		quotaErr := &QuotaError{Reason: QuotaHourly, Limit: p.limits.MaxPerHour}
+
+		// enough plays have to age out to make room
+		if over := len(plays) + pending - p.limits.MaxPerHour; over < len(plays) {
+			quotaErr.RetryAt = plays[over].Add(quotaWindow)
+		}
+
+		return quotaErr
+	}
+
+	return nil
+}
+
+// addQuotaData to the suggestion section for a user
+func (p *Party) addQuotaData(data interface{}, uid UserUUID) {
+	suggest, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	suggest[KLimits] = p.limits
+	if !p.hasLimits(uid) {
+		return
+	}
+
+	quota := make(map[string]interface{})
+	pending := p.suggestionQueue.PendingBy(uid)
+
+	if p.limits.MaxPending > 0 {
+		quota[KPendingLeft] = maxInt(p.limits.MaxPending-pending, 0)
+	}
+
+	if last, has := p.lastSuggested[uid]; has && p.limits.CooldownMs > 0 {
+		if next := last.Add(p.limits.cooldown()); p.now().Before(next) {
+			quota[KNextSuggestMs] = next.UnixNano() / int64(time.Millisecond)
+		}
+	}
+
+	if p.limits.MaxPerHour > 0 {
+		quota[KHourlyLeft] = maxInt(p.limits.MaxPerHour-len(p.recentPlays(uid))-pending, 0)
+	}
+
+	suggest[KQuota] = quota
+}
+
+// maxInt of a and b
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,88 @@
+package party_test
+
+import (
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// pullQuota from a full pull
+func pullQuota(t *testing.T, p *party.Party, uid party.UserUUID) map[string]interface{} {
+	raw, err := p.Pull(uid, 0)
+	assert.Nil(t, err)
+
+	suggest := raw.(map[string]interface{})[party.PullSuggestKey].(map[string]interface{})
+	return suggest[party.KQuota].(map[string]interface{})
+}
+
+// quotaReason of a suggestion that went over the limits
+func quotaReason(t *testing.T, err error) *party.QuotaError {
+	quotaErr, ok := err.(*party.QuotaError)
+	assert.True(t, ok, "expected a quota error, got %v", err)
+	if !ok {
+		return &party.QuotaError{}
+	}
+
+	return quotaErr
+}
+
+func TestSuggestionLimits(t *testing.T) {
+	ouid := party.UserUUID("1")
+	guid := party.UserUUID("2")
+	cooldown := 50 * time.Millisecond
+
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.AddUser(guid, "gary"))
+
+	limits := party.SuggestionLimits{MaxPending: 2, CooldownMs: 50, MaxPerHour: 3}
+	assert.NotNil(t, p.SetSuggestionLimits(guid, limits))
+	assert.NotNil(t, p.SetSuggestionLimits(ouid, party.SuggestionLimits{MaxPending: -1}))
+	assert.Nil(t, p.SetSuggestionLimits(ouid, limits))
+
+	// a plays right away, which counts for the hour
+	assert.Nil(t, p.Suggest(guid, "a"))
+
+	quotaErr := quotaReason(t, p.Suggest(guid, "b"))
+	assert.Equal(t, party.QuotaCooldown, quotaErr.Reason)
+	assert.WithinDuration(t, time.Now().Add(cooldown), quotaErr.RetryAt, cooldown)
+
+	time.Sleep(cooldown + 10*time.Millisecond)
+	assert.Nil(t, p.Suggest(guid, "b"))
+
+	quota := pullQuota(t, p, guid)
+	assert.Equal(t, 1, quota[party.KPendingLeft])
+	assert.Equal(t, 1, quota[party.KHourlyLeft])
+	assert.Contains(t, quota, party.KNextSuggestMs)
+
+	time.Sleep(cooldown + 10*time.Millisecond)
+	assert.Nil(t, p.Suggest(guid, "c"))
+
+	time.Sleep(cooldown + 10*time.Millisecond)
+	quotaErr = quotaReason(t, p.Suggest(guid, "d"))
+	assert.Equal(t, party.QuotaPending, quotaErr.Reason)
+	assert.True(t, quotaErr.RetryAt.IsZero())
+
+	// b playing frees a spot in the queue, but not in the hour
+	assert.Nil(t, p.Skip(ouid, "a"))
+	quotaErr = quotaReason(t, p.Suggest(guid, "d"))
+	assert.Equal(t, party.QuotaHourly, quotaErr.Reason)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), quotaErr.RetryAt, time.Second)
+
+	quota = pullQuota(t, p, guid)
+	assert.Equal(t, 1, quota[party.KPendingLeft])
+	assert.Equal(t, 0, quota[party.KHourlyLeft])
+
+	// hosts aren't limited
+	assert.Nil(t, p.Suggest(ouid, "x"))
+	assert.Nil(t, p.Suggest(ouid, "y"))
+	assert.Nil(t, p.Suggest(ouid, "z"))
+	raw, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	assert.NotContains(t, raw.(map[string]interface{})[party.PullSuggestKey], party.KQuota)
+
+	// the hour survives snapshots
+	restored := party.Restore(p.Snapshot())
+	assert.Equal(t, 0, pullQuota(t, restored, guid)[party.KHourlyLeft])
+	assert.Equal(t, party.QuotaHourly, quotaReason(t, restored.Suggest(guid, "d")).Reason)
+}
@@ -0,0 +1,280 @@
+package party
+
+// this file has the radio, which keeps music going when the queues run dry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RadioMode is where the radio picks songs from
+type RadioMode string
+
+// radio modes
+const (
+	// no radio, the party goes quiet when the queues are empty
+	RadioOff RadioMode = "off"
+
+	// a seed playlist, in order
+	RadioPlaylist RadioMode = "playlist"
+
+	// songs the party already played, oldest first
+	RadioHistory RadioMode = "history"
+
+	// the catalog's songs by artists the party upvoted
+	RadioArtists RadioMode = "artists"
+)
+
+// consts for the radio
+const (
+	maxRadioSongs = 500
+
+	// songs played this recently are only picked if there's nothing else
+	radioRecentSize = 10
+)
+
+// radio settings of a party
+type radio struct {
+	mode  RadioMode
+	songs []SongUID
+
+	// where in songs the playlist picks up
+	cursor int
+}
+
+// IsValidRadioMode checks that the mode exists
+func IsValidRadioMode(mode RadioMode) bool {
+	switch mode {
+	case RadioOff, RadioPlaylist, RadioHistory, RadioArtists:
+		return true
+	}
+
+	return false
+}
+
+// consts for pulling the radio
+const (
+	KRadioMode  = "mode"
+	KRadioSongs = "songs"
+	KAutoPicked = "AutoPicked"
+)
+
+// radioData for pull
+func (p *Party) radioData() map[string]interface{} {
+	songs := make([]interface{}, 0, len(p.radio.songs))
+	for _, sid := range p.radio.songs {
+		songs = append(songs, songData(sid, p.songs))
+	}
+
+	return map[string]interface{}{
+		KRadioMode:  p.radio.mode,
+		KRadioSongs: songs,
+	}
+}
+
+// SetRadio picks where songs come from when the queues are empty.
+// songs is the seed playlist, it's only used in RadioPlaylist mode.
+// uid of person changing the radio.
+func (p *Party) SetRadio(uid UserUUID, mode RadioMode, songs []SongUID) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetRadio, Actor: uid, Name: string(mode), Songs: songs})
+
+	if can, err := p.canUserDo(uid, CapabilityManageRadio); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't change the radio")
+	}
+
+	if !IsValidRadioMode(mode) {
+		return fmt.Errorf("no radio mode %s", mode)
+	}
+
+	if mode != RadioPlaylist {
+		songs = nil
+	} else if len(songs) == 0 || len(songs) > maxRadioSongs {
+		return fmt.Errorf("playlists need 1 to %d songs", maxRadioSongs)
+	}
+
+	for _, sid := range songs {
+		if err = p.learnSong(sid); err != nil {
+			return err
+		}
+	}
+
+	p.radio = radio{
+		mode:  mode,
+		songs: append([]SongUID(nil), songs...),
+	}
+
+	p.setUpdated(PullRadioKey)
+	return nil
+}
+
+// FillRadio plays a song from the radio if nothing is playing.
+// The server calls this periodically so parties with the radio on
+// don't go quiet. Error if there's nothing to pick.
+func (p *Party) FillRadio() error {
+	// picking looks at the catalog, which can change, so it happens
+	// before the logged call
+	p.mux.Lock()
+	sid, err := p.pickRadio()
+	p.mux.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return p.playRadio(sid)
+}
+
+// playRadio plays a song the radio picked
+func (p *Party) playRadio(sid SongUID) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventPlayRadio, Song: sid})
+
+	if p.radio.mode == RadioOff {
+		return fmt.Errorf("the radio is off")
+	}
+
+	if p.nowPlaying.CurrentlyHasSong() {
+		return fmt.Errorf("a song is already playing")
+	}
+
+	if err = p.learnSong(sid); err != nil {
+		return err
+	}
+
+	// the playlist picks up after this song next time
+	for i, seed := range p.radio.songs {
+		if seed == sid {
+			p.radio.cursor = i + 1
+			break
+		}
+	}
+
+	p.playSong(sid)
+	p.nowPlaying.autoPicked = true
+
+	return nil
+}
+
+// needsSong checks if the next song suggested should play right away,
+// because nothing is playing or the radio is filling in
+func (p *Party) needsSong() bool {
+	return !p.nowPlaying.CurrentlyHasSong() || p.nowPlaying.autoPicked
+}
+
+// likeArtist of a song someone upvoted, for RadioArtists
+func (p *Party) likeArtist(sid SongUID) {
+	if info, has := p.songs[sid]; has && info.Artist != "" {
+		p.likedArtists[strings.ToLower(info.Artist)]++
+	}
+}
+
+// pickRadio chooses the radio's next song.
+// Songs played recently are skipped unless everything was, then the
+// song played longest ago is picked.
+func (p *Party) pickRadio() (SongUID, error) {
+	if p.radio.mode == RadioOff {
+		return "", fmt.Errorf("the radio is off")
+	}
+
+	if p.nowPlaying.CurrentlyHasSong() {
+		return "", fmt.Errorf("a song is already playing")
+	}
+
+	candidates, err := p.radioCandidates()
+	if err != nil {
+		return "", err
+	}
+
+	// when each song was last played, higher is more recent
+	played := songsFromList(p.previous.songs)
+	lastPlayed := make(map[SongUID]int, len(played))
+	for i, sid := range played {
+		lastPlayed[sid] = i
+	}
+
+	recent := len(played) - radioRecentSize
+
+	var oldest SongUID
+	for _, sid := range candidates {
+		at, has := lastPlayed[sid]
+		if !has || at < recent {
+			return sid, nil
+		}
+
+		if oldest == "" || at < lastPlayed[oldest] {
+			oldest = sid
+		}
+	}
+
+	if oldest == "" {
+		return "", fmt.Errorf("the radio has nothing to play")
+	}
+
+	return oldest, nil
+}
+
+// radioCandidates in the order the radio prefers them
+func (p *Party) radioCandidates() ([]SongUID, error) {
+	switch p.radio.mode {
+	case RadioPlaylist:
+		// start where the playlist left off
+		n := len(p.radio.songs)
+		candidates := make([]SongUID, 0, n)
+		for i := 0; i < n; i++ {
+			candidates = append(candidates, p.radio.songs[(p.radio.cursor+i)%n])
+		}
+
+		return candidates, nil
+
+	case RadioHistory:
+		seen := make(map[SongUID]struct{})
+		var candidates []SongUID
+		for _, sid := range songsFromList(p.previous.songs) {
+			if _, has := seen[sid]; !has {
+				seen[sid] = struct{}{}
+				candidates = append(candidates, sid)
+			}
+		}
+
+		return candidates, nil
+
+	case RadioArtists:
+		catalog, ok := p.catalog.(ListingCatalog)
+		if !ok {
+			return nil, fmt.Errorf("the catalog can't list songs by artist")
+		}
+
+		var songs []SongInfo
+		for _, info := range catalog.Songs() {
+			if p.likedArtists[strings.ToLower(info.Artist)] > 0 {
+				songs = append(songs, info)
+			}
+		}
+
+		// most liked artists first
+		sort.Slice(songs, func(i, j int) bool {
+			a := p.likedArtists[strings.ToLower(songs[i].Artist)]
+			b := p.likedArtists[strings.ToLower(songs[j].Artist)]
+			if a != b {
+				return a > b
+			}
+
+			return songs[i].ID < songs[j].ID
+		})
+
+		candidates := make([]SongUID, len(songs))
+		for i, info := range songs {
+			candidates[i] = info.ID
+		}
+
+		return candidates, nil
+	}
+
+	return nil, fmt.Errorf("the radio is off")
+}
@@ -0,0 +1,121 @@
+package party_test
+
+import (
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// pullPlaying from a full pull
+func pullPlaying(t *testing.T, p *party.Party, uid party.UserUUID) map[string]interface{} {
+	raw, err := p.Pull(uid, 0)
+	assert.Nil(t, err)
+
+	return raw.(map[string]interface{})[party.PullPlayingKey].(map[string]interface{})
+}
+
+func TestRadioPlaylist(t *testing.T) {
+	ouid := party.UserUUID("1")
+	guid := party.UserUUID("2")
+
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.AddUser(guid, "gary"))
+
+	// off by default
+	assert.NotNil(t, p.FillRadio())
+
+	assert.NotNil(t, p.SetRadio(guid, party.RadioPlaylist, []party.SongUID{"r1"}))
+	assert.NotNil(t, p.SetRadio(ouid, "polka", nil))
+	assert.NotNil(t, p.SetRadio(ouid, party.RadioPlaylist, nil))
+	assert.Nil(t, p.SetRadio(ouid, party.RadioPlaylist, []party.SongUID{"r1", "r2"}))
+
+	assert.Nil(t, p.FillRadio())
+	playing := pullPlaying(t, p, ouid)
+	assert.Equal(t, party.SongUID("r1"), playing[party.KCurrentSongID])
+	assert.Equal(t, true, playing[party.KAutoPicked])
+
+	// only fills in when nothing is playing
+	assert.NotNil(t, p.FillRadio())
+
+	// guests' songs cut the radio off
+	assert.Nil(t, p.Suggest(guid, "a"))
+	playing = pullPlaying(t, p, ouid)
+	assert.Equal(t, party.SongUID("a"), playing[party.KCurrentSongID])
+	assert.Equal(t, false, playing[party.KAutoPicked])
+
+	// the playlist picks up where it left off
+	assert.NotNil(t, p.SongFinished(ouid, "a"))
+	assert.Nil(t, p.FillRadio())
+	assert.Equal(t, party.SongUID("r2"), pullPlaying(t, p, ouid)[party.KCurrentSongID])
+
+	assert.NotNil(t, p.SongFinished(ouid, "r2"))
+	assert.Nil(t, p.FillRadio())
+	assert.Equal(t, party.SongUID("r1"), pullPlaying(t, p, ouid)[party.KCurrentSongID])
+}
+
+func TestRadioHistory(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	assert.Nil(t, p.PlayNext(ouid, "a"))
+	assert.Nil(t, p.PlayNext(ouid, "b"))
+	assert.Nil(t, p.Skip(ouid, "a"))
+	assert.NotNil(t, p.Skip(ouid, "b"))
+
+	// everything was played recently, so the oldest comes back first
+	assert.Nil(t, p.SetRadio(ouid, party.RadioHistory, nil))
+	assert.Nil(t, p.FillRadio())
+	assert.Equal(t, party.SongUID("a"), pullPlaying(t, p, ouid)[party.KCurrentSongID])
+
+	assert.NotNil(t, p.Skip(ouid, "a"))
+	assert.Nil(t, p.FillRadio())
+	assert.Equal(t, party.SongUID("b"), pullPlaying(t, p, ouid)[party.KCurrentSongID])
+}
+
+func TestRadioArtists(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+	p.SetCatalog(party.NewMemoryCatalog(
+		party.SongInfo{ID: "a", Artist: "Toto"},
+		party.SongInfo{ID: "b", Artist: "ABBA"},
+		party.SongInfo{ID: "t1", Artist: "toto"},
+		party.SongInfo{ID: "x", Artist: "Queen"},
+	))
+
+	assert.Nil(t, p.PlayNext(ouid, "b"))
+	assert.Nil(t, p.Suggest(ouid, "a"))
+	assert.Nil(t, p.SuggestionUpvote(ouid, "a"))
+	assert.Nil(t, p.Skip(ouid, "b"))
+	assert.NotNil(t, p.Skip(ouid, "a"))
+
+	// a was just played, so the other song by toto is next
+	assert.Nil(t, p.SetRadio(ouid, party.RadioArtists, nil))
+	assert.Nil(t, p.FillRadio())
+	assert.Equal(t, party.SongUID("t1"), pullPlaying(t, p, ouid)[party.KCurrentSongID])
+
+	// the radio survives snapshots
+	restored := party.Restore(p.Snapshot())
+	raw, err := restored.Pull(ouid, 0)
+	assert.Nil(t, err)
+	radio := raw.(map[string]interface{})[party.PullRadioKey].(map[string]interface{})
+	assert.Equal(t, party.RadioArtists, radio[party.KRadioMode])
+	assert.Equal(t, true, pullPlaying(t, restored, ouid)[party.KAutoPicked])
+}
+
+func TestRadioReplay(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+	var events []party.Event
+	base := captureEvents(p, &events)
+
+	assert.Nil(t, p.SetRadio(ouid, party.RadioPlaylist, []party.SongUID{"r1", "r2"}))
+	assert.Nil(t, p.FillRadio())
+	assert.Nil(t, p.PlayNext(ouid, "a"))
+
+	restored := replay(t, base, events)
+
+	expected, actual := p.Snapshot(), restored.Snapshot()
+	assert.Equal(t, expected.Radio, actual.Radio)
+	assert.Equal(t, expected.Previous, actual.Previous)
+	assert.Equal(t, expected.NowPlaying.Song, actual.NowPlaying.Song)
+}
@@ -0,0 +1,158 @@
+package party
+
+import (
+	"sort"
+)
+
+// Role of a user in a party.
+// Roles bundle permissions that are always granted plus capabilities
+// for managing the party.
+type Role string
+
+// roles, from most to least privileged
+const (
+	RoleOwner     Role = "owner"
+	RoleCoHost    Role = "cohost"
+	RoleModerator Role = "moderator"
+	RoleGuest     Role = "guest"
+)
+
+// capabilities for managing the party, these only come from roles
+const (
+	CapabilityEndParty          = "EndParty"
+	CapabilityManageRoles       = "ManageRoles"
+	CapabilityManagePermissions = "ManagePermissions"
+	CapabilityKick              = "Kick"
+	CapabilityBan               = "Ban"
+	CapabilityManageInvites     = "ManageInvites"
+	CapabilityManageZones       = "ManageZones"
+	CapabilityManageRadio       = "ManageRadio"
+	CapabilityManageQueue       = "ManageQueue"
+)
+
+// RoleInfo is what a role grants.
+type RoleInfo struct {
+	Description string `json:"description"`
+
+	// granted no matter what the party allows
+	Permissions  []string `json:"permissions"`
+	Capabilities []string `json:"capabilities"`
+
+	// higher ranks can manage lower ones
+	Rank int `json:"rank"`
+}
+
+// maps can't be const in go
+var (
+	CapabilityDescriptionMap = map[string]string{
+		CapabilityEndParty:          "User can end the party",
+		CapabilityManageRoles:       "User can promote and demote users",
+		CapabilityManagePermissions: "User can change party and user permissions",
+		CapabilityKick:              "User can kick users from the party",
+		CapabilityBan:               "User can ban users from the party",
+		CapabilityManageInvites:     "User can set the join password and create invites",
+		CapabilityManageZones:       "User can add, remove, group and ungroup zones",
+		CapabilityManageRadio:       "User can change what the radio plays when the queues are empty",
+		CapabilityManageQueue:       "User can change how the suggestion queue picks songs",
+	}
+
+	RoleMap = map[Role]RoleInfo{
+		RoleOwner: {
+			Description: "Runs the party and can do anything",
+			Permissions: allPermissions(),
+			Capabilities: []string{
+				CapabilityEndParty,
+				CapabilityManageRoles,
+				CapabilityManagePermissions,
+				CapabilityKick,
+				CapabilityBan,
+				CapabilityManageInvites,
+				CapabilityManageZones,
+				CapabilityManageRadio,
+				CapabilityManageQueue,
+			},
+			Rank: 3,
+		},
+		RoleCoHost: {
+			Description: "DJs alongside the owner",
+			Permissions: allPermissions(),
+			Capabilities: []string{
+				CapabilityManagePermissions,
+				CapabilityKick,
+				CapabilityBan,
+				CapabilityManageInvites,
+				CapabilityManageZones,
+				CapabilityManageRadio,
+				CapabilityManageQueue,
+			},
+			Rank: 2,
+		},
+		RoleModerator: {
+			Description: "Keeps the queue and guests in line",
+			Permissions: []string{
+				UserCanSuggestSongPermission,
+				UserCanVoteSuggestionPermission,
+			},
+			Capabilities: []string{
+				CapabilityKick,
+				CapabilityBan,
+			},
+			Rank: 1,
+		},
+		RoleGuest: {
+			Description:  "Can do what the party allows",
+			Permissions:  []string{},
+			Capabilities: []string{},
+			Rank:         0,
+		},
+	}
+)
+
+// GetRoleDescriptions for the roles available to any party
+func GetRoleDescriptions() interface{} {
+	return RoleMap
+}
+
+// IsValidRole checks that the role exists
+func IsValidRole(role Role) bool {
+	_, has := RoleMap[role]
+	return has
+}
+
+// grants checks if the role always grants a permission
+func (r Role) grants(permission string) bool {
+	for _, granted := range RoleMap[r].Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// can checks if the role has a capability
+func (r Role) can(capability string) bool {
+	for _, granted := range RoleMap[r].Capabilities {
+		if granted == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+// outranks checks if the role is more privileged than other
+func (r Role) outranks(other Role) bool {
+	return RoleMap[r].Rank > RoleMap[other].Rank
+}
+
+// allPermissions in the PermissionDescriptionMap
+func allPermissions() []string {
+	perms := make([]string, 0, len(PermissionDescriptionMap))
+	for key := range PermissionDescriptionMap {
+		perms = append(perms, key)
+	}
+
+	sort.Strings(perms)
+	return perms
+}
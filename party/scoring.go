@@ -0,0 +1,220 @@
+package party
+
+// this file has how songs in the suggestion queue are scored
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Scorer scores songs in a VotableQueue. Higher scores play first,
+// ties go to the song added first.
+type Scorer interface {
+	Score(vse VotableSongElement, state QueueState) float64
+}
+
+// QueueState is what a Scorer knows about the queue.
+// Songs scored together get the same state.
+type QueueState struct {
+	Now time.Time
+
+	// songs ever added, a song's PosAdded is how many were added before it
+	AddCounter uint64
+}
+
+// VoteScorer scores songs by their votes, ie Sum. It's the default.
+type VoteScorer struct{}
+
+// Score is the song's votes
+func (VoteScorer) Score(vse VotableSongElement, state QueueState) float64 {
+	return float64(vse.Sum())
+}
+
+// Scoring is how a party weighs votes. The zero value scores like VoteScorer.
+type Scoring struct {
+	// votes lose half their weight every HalfLifeMs, 0 is no decay
+	HalfLifeMs int64 `json:"halfLifeMs,omitempty"`
+
+	// added to a song's score for every song suggested after it,
+	// so songs that have waited a long time still get played
+	WaitBoost float64 `json:"waitBoost,omitempty"`
+
+	// how much votes count by the voter's role, roles left out count 1
+	RoleWeights map[Role]float64 `json:"roleWeights,omitempty"`
+}
+
+// consts for pulling the scoring, sent with the suggestion queue
+const (
+	KScoring = "scoring"
+)
+
+// SetScoring for the party's suggestion queue.
+// uid of person changing the scoring.
+func (p *Party) SetScoring(uid UserUUID, scoring Scoring) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetScoring, Actor: uid, Scoring: &scoring})
+
+	if can, err := p.canUserDo(uid, CapabilityManageQueue); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't change the scoring")
+	}
+
+	if scoring.HalfLifeMs < 0 || scoring.WaitBoost < 0 {
+		return fmt.Errorf("scoring can't be negative")
+	}
+
+	for role, weight := range scoring.RoleWeights {
+		if !IsValidRole(role) {
+			return fmt.Errorf("no role %s", role)
+		}
+
+		if weight < 0 {
+			return fmt.Errorf("role weights can't be negative")
+		}
+	}
+
+	p.scoring = scoring.copy()
+	p.suggestionQueue.SetScorer(p.scorer())
+	p.setUpdated(PullSuggestKey)
+
+	return nil
+}
+
+// scorer for the party's scoring
+func (p *Party) scorer() Scorer {
+	return WeightedScorer{Scoring: p.scoring, Role: p.roleOf}
+}
+
+// rolesChanged rescores the suggestions if votes are weighted by role
+func (p *Party) rolesChanged() {
+	if len(p.scoring.RoleWeights) > 0 {
+		p.suggestionQueue.Rescore()
+		p.setUpdated(PullSuggestKey)
+	}
+}
+
+// addScoring to the suggestion section
+func (p *Party) addScoring(data interface{}) {
+	if suggest, ok := data.(map[string]interface{}); ok {
+		suggest[KScoring] = p.scoring
+	}
+}
+
+// copy so the caller's role weights can change
+func (s Scoring) copy() Scoring {
+	if s.RoleWeights == nil {
+		return s
+	}
+
+	weights := make(map[Role]float64, len(s.RoleWeights))
+	for role, weight := range s.RoleWeights {
+		weights[role] = weight
+	}
+
+	s.RoleWeights = weights
+	return s
+}
+
+// VolatileScorer is a Scorer whose scores can change without votes changing
+// in ways that reorder the songs. The queue rescores every song before
+// using the scores.
+type VolatileScorer interface {
+	Scorer
+	Volatile() bool
+}
+
+// KeyedScorer is a Scorer whose scores change over time without changing
+// the order of the songs, ie every vote decays by the same factor. The queue
+// orders songs by a key that only changes with the song's votes, and works
+// out scores from keys when they're shown.
+type KeyedScorer interface {
+	Scorer
+
+	// Key of a song relative to epoch, higher keys play first
+	Key(vse VotableSongElement, epoch time.Time) float64
+
+	// ScoreOf a song with key at state
+	ScoreOf(key float64, epoch time.Time, state QueueState) float64
+
+	// EpochLasts is how long keys from an epoch stay accurate, 0 is forever.
+	// After that the queue moves the epoch up and keys every song again.
+	EpochLasts() time.Duration
+}
+
+// consts for keyed scoring
+const (
+	// keys weigh votes relative to the epoch, which doubles every half life.
+	// After this many half lives the weights are too far apart to add well.
+	epochHalfLives = 32
+)
+
+// WeightedScorer scores songs the way a party's Scoring says
+type WeightedScorer struct {
+	Scoring
+
+	// Role of a voter, nil counts everyone as a guest
+	Role func(UserUUID) Role
+}
+
+// Score is the song's weighted votes plus its boost for waiting
+func (s WeightedScorer) Score(vse VotableSongElement, state QueueState) float64 {
+	return s.ScoreOf(s.Key(vse, state.Now), state.Now, state)
+}
+
+// Volatile if votes decay and songs get a boost for waiting. Either one moves
+// every score the same way, together the boosts grow next to votes that
+// shrink so songs trade places over time.
+func (s WeightedScorer) Volatile() bool {
+	return s.HalfLifeMs > 0 && s.WaitBoost > 0
+}
+
+// Key is the song's votes weighted as of epoch, less the boost the songs
+// added before it have over it
+func (s WeightedScorer) Key(vse VotableSongElement, epoch time.Time) float64 {
+	key := 0.0
+	for uid, vote := range vse.votes {
+		key += float64(vote) * s.roleWeight(uid) * s.growth(vse.votedAt[uid], epoch)
+	}
+
+	return key - s.WaitBoost*float64(vse.posAdded)
+}
+
+// ScoreOf a song with key, decayed from epoch and boosted by the songs
+// added since the first
+func (s WeightedScorer) ScoreOf(key float64, epoch time.Time, state QueueState) float64 {
+	return key*s.growth(epoch, state.Now) + s.WaitBoost*(float64(state.AddCounter)-1)
+}
+
+// EpochLasts a number of half lives
+func (s WeightedScorer) EpochLasts() time.Duration {
+	return epochHalfLives * time.Duration(s.HalfLifeMs) * time.Millisecond
+}
+
+// growth of a vote cast at votedAt by now, relative to a vote cast now.
+// It's the decay if votedAt is earlier.
+func (s WeightedScorer) growth(votedAt time.Time, now time.Time) float64 {
+	// older snapshots don't know when votes were cast
+	if s.HalfLifeMs <= 0 || votedAt.IsZero() {
+		return 1
+	}
+
+	halfLife := time.Duration(s.HalfLifeMs) * time.Millisecond
+	return math.Pow(2, float64(votedAt.Sub(now))/float64(halfLife))
+}
+
+// roleWeight of votes by uid
+func (s WeightedScorer) roleWeight(uid UserUUID) float64 {
+	role := RoleGuest
+	if s.Role != nil {
+		role = s.Role(uid)
+	}
+
+	if roleWeight, has := s.RoleWeights[role]; has {
+		return roleWeight
+	}
+
+	return 1
+}
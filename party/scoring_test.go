@@ -0,0 +1,131 @@
+package party_test
+
+import (
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// newestScorer plays the newest songs first
+type newestScorer struct{}
+
+func (newestScorer) Score(vse party.VotableSongElement, state party.QueueState) float64 {
+	return float64(vse.PosAdded())
+}
+
+// popsInPullOrder checks that the queue plays songs in the order it pulls them
+func popsInPullOrder(t *testing.T, q *party.VotableQueue, expected []party.SongUID) {
+	data := parseSongsFromVQPull(q.Pull("1", nil))
+	assert.Len(t, data, len(expected))
+	for i, song := range expected {
+		assert.Equal(t, song, data[i]["id"])
+	}
+
+	for _, song := range expected {
+		actual, err := q.Pop()
+		assert.Nil(t, err)
+		assert.Equal(t, song, actual)
+	}
+}
+
+func TestScorerPluggable(t *testing.T) {
+	q := party.NewVotableQueue()
+	q.SetScorer(newestScorer{})
+
+	assert.Nil(t, q.AddSong("1", "a"))
+	assert.Nil(t, q.AddSong("1", "b"))
+	assert.Nil(t, q.AddSong("1", "c"))
+	assert.Nil(t, q.Upvote("2", "a"))
+
+	popsInPullOrder(t, &q, []party.SongUID{"c", "b", "a"})
+}
+
+func TestScoringWaitBoost(t *testing.T) {
+	q := party.NewVotableQueue()
+	assert.Nil(t, q.AddSong("1", "a"))
+	assert.Nil(t, q.AddSong("1", "b"))
+	assert.Nil(t, q.AddSong("1", "c"))
+	assert.Nil(t, q.Upvote("2", "c"))
+
+	// a waited for 2 songs, b for 1, which catches b up to c
+	q.SetScorer(party.WeightedScorer{Scoring: party.Scoring{WaitBoost: 1}})
+
+	data := parseSongsFromVQPull(q.Pull("1", nil))
+	assert.Equal(t, 3.0, data[0]["score"])
+	popsInPullOrder(t, &q, []party.SongUID{"a", "b", "c"})
+}
+
+func TestScoringDecay(t *testing.T) {
+	q := party.NewVotableQueue()
+	q.SetScorer(party.WeightedScorer{Scoring: party.Scoring{HalfLifeMs: 20}})
+
+	// a's vote is worth about a quarter of b's
+	assert.Nil(t, q.AddSong("1", "a"))
+	time.Sleep(40 * time.Millisecond)
+	assert.Nil(t, q.AddSong("1", "b"))
+
+	popsInPullOrder(t, &q, []party.SongUID{"b", "a"})
+}
+
+func TestScoringDecayEpoch(t *testing.T) {
+	q := party.NewVotableQueue()
+	q.SetScorer(party.WeightedScorer{Scoring: party.Scoring{HalfLifeMs: 5}})
+
+	// votes after this many half lives key against a newer epoch
+	assert.Nil(t, q.AddSong("1", "a"))
+	time.Sleep(200 * time.Millisecond)
+	assert.Nil(t, q.AddSong("1", "b"))
+	time.Sleep(5 * time.Millisecond)
+	assert.Nil(t, q.Upvote("2", "a"))
+
+	data := parseSongsFromVQPull(q.Pull("1", nil))
+	assert.Len(t, data, 2)
+	assert.True(t, data[0]["score"].(float64) <= 1.0)
+	assert.True(t, data[0]["score"].(float64) > data[1]["score"].(float64))
+	popsInPullOrder(t, &q, []party.SongUID{"a", "b"})
+}
+
+func TestPartyScoring(t *testing.T) {
+	ouid := party.UserUUID("1")
+	g1 := party.UserUUID("2")
+	g2 := party.UserUUID("3")
+
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.AddUser(g1, "gary"))
+	assert.Nil(t, p.AddUser(g2, "gus"))
+	assert.Nil(t, p.PlayNext(ouid, "playing"))
+
+	assert.Nil(t, p.Suggest(g1, "a"))
+	assert.Nil(t, p.Suggest(g2, "b"))
+	assert.Nil(t, p.SuggestionUpvote(g2, "a"))
+	assert.Nil(t, p.SuggestionUpvote(ouid, "b"))
+
+	assert.NotNil(t, p.SetScoring(g1, party.Scoring{WaitBoost: 1}))
+	assert.NotNil(t, p.SetScoring(ouid, party.Scoring{HalfLifeMs: -1}))
+	assert.NotNil(t, p.SetScoring(ouid, party.Scoring{RoleWeights: map[party.Role]float64{"dj": 2}}))
+
+	// the owner's vote counts 3 times, so b passes a
+	scoring := party.Scoring{RoleWeights: map[party.Role]float64{party.RoleOwner: 3}}
+	assert.Nil(t, p.SetScoring(ouid, scoring))
+
+	raw, err := p.Pull(g1, 0)
+	assert.Nil(t, err)
+	suggestions := raw.(map[string]interface{})[party.PullSuggestKey].(map[string]interface{})
+	assert.Equal(t, scoring, suggestions[party.KScoring])
+
+	songs := parseSongsFromVQPull(suggestions)
+	assert.Equal(t, party.SongUID("b"), songs[0]["id"])
+	assert.Equal(t, 4.0, songs[0]["score"])
+
+	// the scoring survives snapshots
+	restored := party.Restore(p.Snapshot())
+	raw, err = restored.Pull(g1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, suggestions, raw.(map[string]interface{})[party.PullSuggestKey])
+
+	assert.Nil(t, p.Skip(ouid, "playing"))
+	actual, err := getCurrentlyPlaying(p, ouid)
+	assert.Nil(t, err)
+	assert.Equal(t, party.SongUID("b"), actual)
+}
@@ -0,0 +1,537 @@
+package party
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// SnapshotVersion of the snapshots parties take.
+// Bump it when the format changes in a way older code can't read.
+const SnapshotVersion = 1
+
+// Snapshot is a serializable copy of a party's state.
+// It holds everything needed to rebuild the party, including the
+// changeID, so clients can keep pulling after a restore.
+type Snapshot struct {
+	// SnapshotVersion when the snapshot was taken, 0 isn't a snapshot
+	Version int `json:"snapshotVersion"`
+
+	Owner       UserUUID                  `json:"owner"`
+	Users       map[UserUUID]UserSnapshot `json:"users"`
+	Permissions map[string]bool           `json:"permissions"`
+	Bans        []UserUUID                `json:"bans,omitempty"`
+	Password    string                    `json:"password,omitempty"`
+	InviteOnly  bool                      `json:"inviteOnly,omitempty"`
+	Invites     []Invite                  `json:"invites,omitempty"`
+	ChangeID    uint64                    `json:"changeId"`
+	LastChange  time.Time                 `json:"lastChange"`
+
+	// events with a higher Seq happened after the snapshot
+	EventSeq uint64 `json:"eventSeq"`
+
+	Suggestions VotableQueueSnapshot `json:"suggestions"`
+	PlayNext    []SongUID            `json:"playNext"`
+	Previous    []SongUID            `json:"previous"`
+	NowPlaying  NowPlayingSnapshot   `json:"nowPlaying"`
+
+	// info for the songs above that the catalog knew
+	Songs []SongInfo `json:"songs,omitempty"`
+
+	// durations in ms that clients gave for the songs above
+	Durations map[SongUID]int64 `json:"durations,omitempty"`
+
+	Zones []ZoneSnapshot `json:"zones,omitempty"`
+
+	Radio        RadioSnapshot  `json:"radio"`
+	LikedArtists map[string]int `json:"likedArtists,omitempty"`
+
+	// votes to skip the current song, and how many it takes
+	SkipVotes    []UserUUID `json:"skipVotes,omitempty"`
+	SkipFraction float64    `json:"skipFraction,omitempty"`
+	SkipCount    int        `json:"skipCount,omitempty"`
+
+	// suggestion limits, when users last suggested and when their
+	// suggestions played in the last hour
+	SuggestionLimits SuggestionLimits         `json:"suggestionLimits"`
+	LastSuggested    map[UserUUID]time.Time   `json:"lastSuggested,omitempty"`
+	SuggestedPlays   map[UserUUID][]time.Time `json:"suggestedPlays,omitempty"`
+
+	// the veto rule and when vetoed songs can be suggested again
+	VetoRule VetoRule              `json:"vetoRule"`
+	Vetoed   map[SongUID]time.Time `json:"vetoed,omitempty"`
+
+	Scoring Scoring `json:"scoring"`
+}
+
+// RadioSnapshot is the serializable state of the radio.
+type RadioSnapshot struct {
+	Mode   RadioMode `json:"mode"`
+	Songs  []SongUID `json:"songs,omitempty"`
+	Cursor int       `json:"cursor,omitempty"`
+}
+
+// UserSnapshot is the serializable state of a user.
+// Permissions are the user's overrides.
+type UserSnapshot struct {
+	Name        string          `json:"name"`
+	Role        Role            `json:"role,omitempty"`
+	Joined      time.Time       `json:"joined"`
+	Permissions map[string]bool `json:"permissions"`
+}
+
+// VotableQueueSnapshot is the serializable state of a VotableQueue.
+type VotableQueueSnapshot struct {
+	Songs      []VotableSongSnapshot `json:"songs"`
+	AddCounter uint64                `json:"addCounter"`
+
+	Order      QueueOrder          `json:"order,omitempty"`
+	Served     map[UserUUID]uint64 `json:"served,omitempty"`
+	PopCounter uint64              `json:"popCounter,omitempty"`
+}
+
+// VotableSongSnapshot is the serializable state of a VotableSongElement.
+type VotableSongSnapshot struct {
+	ID          SongUID          `json:"id"`
+	PosAdded    uint64           `json:"posAdded"`
+	SuggestedBy UserUUID         `json:"suggestedBy,omitempty"`
+	Votes       map[UserUUID]int `json:"votes"`
+
+	VotedAt map[UserUUID]time.Time `json:"votedAt,omitempty"`
+}
+
+// NowPlayingSnapshot is the serializable state of NowPlaying.
+type NowPlayingSnapshot struct {
+	Song      SongUID   `json:"song"`
+	StartTime time.Time `json:"startTime"`
+	SongPos   float32   `json:"songPos"`
+	Volume    uint32    `json:"volume"`
+	Playing   bool      `json:"playing"`
+
+	AutoPicked bool `json:"autoPicked,omitempty"`
+}
+
+// ZoneSnapshot is the serializable state of a Zone.
+// NowPlaying holds the zone's volume, and its song if it isn't grouped.
+type ZoneSnapshot struct {
+	Name       string             `json:"name"`
+	Muted      bool               `json:"muted,omitempty"`
+	Grouped    bool               `json:"grouped"`
+	NowPlaying NowPlayingSnapshot `json:"nowPlaying"`
+}
+
+// Snapshot the party's current state.
+func (p *Party) Snapshot() Snapshot {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return p.snapshot()
+}
+
+// snapshot with the party locked
+func (p *Party) snapshot() Snapshot {
+	users := make(map[UserUUID]UserSnapshot, len(p.users))
+	for uid, user := range p.users {
+		users[uid] = user.snapshot()
+	}
+
+	return Snapshot{
+		Version:     SnapshotVersion,
+		Owner:       p.ownerUUID,
+		Users:       users,
+		Permissions: p.permissions(),
+		Bans:        p.bannedUsers(),
+		Password:    p.password,
+		InviteOnly:  p.inviteOnly,
+		Invites:     p.activeInvites(),
+		ChangeID:    p.changeID,
+		LastChange:  p.lastChangeT,
+		EventSeq:    p.eventSeq,
+
+		Suggestions: p.suggestionQueue.snapshot(),
+		PlayNext:    songsFromList(p.playNext.songs),
+		Previous:    songsFromList(p.previous.songs),
+		NowPlaying:  p.nowPlaying.snapshot(),
+		Songs:       p.knownSongs(),
+		Durations:   p.clientDurations(),
+		Zones:       p.zoneSnapshots(),
+
+		Radio: RadioSnapshot{
+			Mode:   p.radio.mode,
+			Songs:  append([]SongUID(nil), p.radio.songs...),
+			Cursor: p.radio.cursor,
+		},
+		LikedArtists: p.copyLikedArtists(),
+
+		SkipVotes:    p.skipVoters(),
+		SkipFraction: p.skipFraction,
+		SkipCount:    p.skipCount,
+
+		SuggestionLimits: p.limits,
+		LastSuggested:    p.copyLastSuggested(),
+		SuggestedPlays:   p.copySuggestedPlays(),
+
+		VetoRule: p.veto,
+		Vetoed:   p.copyVetoed(),
+
+		Scoring: p.scoring.copy(),
+	}
+}
+
+// copyVetoed songs that can't be suggested yet, for a snapshot
+func (p *Party) copyVetoed() map[SongUID]time.Time {
+	vetoed := make(map[SongUID]time.Time, len(p.vetoed))
+	for _, sid := range p.vetoedSongs() {
+		vetoed[sid] = p.vetoed[sid]
+	}
+
+	return vetoed
+}
+
+// copyLastSuggested for a snapshot
+func (p *Party) copyLastSuggested() map[UserUUID]time.Time {
+	last := make(map[UserUUID]time.Time, len(p.lastSuggested))
+	for uid, at := range p.lastSuggested {
+		last[uid] = at
+	}
+
+	return last
+}
+
+// copySuggestedPlays in the quota window for a snapshot
+func (p *Party) copySuggestedPlays() map[UserUUID][]time.Time {
+	plays := make(map[UserUUID][]time.Time, len(p.suggestedPlays))
+	for uid := range p.suggestedPlays {
+		if recent := p.recentPlays(uid); len(recent) > 0 {
+			plays[uid] = append([]time.Time(nil), recent...)
+		}
+	}
+
+	return plays
+}
+
+// copyLikedArtists for a snapshot
+func (p *Party) copyLikedArtists() map[string]int {
+	liked := make(map[string]int, len(p.likedArtists))
+	for artist, count := range p.likedArtists {
+		liked[artist] = count
+	}
+
+	return liked
+}
+
+// zoneSnapshots sorted by name
+func (p *Party) zoneSnapshots() []ZoneSnapshot {
+	zones := make([]ZoneSnapshot, 0, len(p.zones))
+	for _, name := range p.zoneNames() {
+		zone := p.zones[name]
+		zones = append(zones, ZoneSnapshot{
+			Name:       zone.name,
+			Muted:      zone.muted,
+			Grouped:    zone.grouped,
+			NowPlaying: zone.nowPlaying.snapshot(),
+		})
+	}
+
+	return zones
+}
+
+// clientDurations in ms of songs still in the party
+func (p *Party) clientDurations() map[SongUID]int64 {
+	durations := make(map[SongUID]int64)
+	for sid := range p.songsInParty() {
+		if duration, has := p.durations[sid]; has {
+			durations[sid] = int64(duration / time.Millisecond)
+		}
+	}
+
+	return durations
+}
+
+// Restore a party from a snapshot.
+func Restore(snap Snapshot) *Party {
+	p := &Party{
+		users:     make(map[UserUUID]*User, len(snap.Users)),
+		ownerUUID: snap.Owner,
+		mux:       &sync.Mutex{},
+		changeID:  snap.ChangeID,
+
+		nowPlaying:      restoreNowPlaying(snap.NowPlaying),
+		suggestionQueue: restoreVotableQueue(snap.Suggestions, snap.LastChange),
+		playNext:        PlayNextQueue{songs: listFromSongs(snap.PlayNext)},
+		previous:        PreviousStack{songs: listFromSongs(snap.Previous)},
+
+		lastChangeT: snap.LastChange,
+		changes:     newNotifier(snap.ChangeID),
+
+		permMap: make(map[string]bool),
+		bans:    make(map[UserUUID]struct{}, len(snap.Bans)),
+
+		password:   snap.Password,
+		inviteOnly: snap.InviteOnly,
+		invites:    make(map[string]*Invite, len(snap.Invites)),
+		songs:      make(songCache, len(snap.Songs)),
+		durations:  make(map[SongUID]time.Duration, len(snap.Durations)),
+		zones:      make(map[string]*Zone, len(snap.Zones)),
+
+		joinFailures: make(map[UserUUID][]time.Time),
+
+		radio: radio{
+			mode:   snap.Radio.Mode,
+			songs:  snap.Radio.Songs,
+			cursor: snap.Radio.Cursor,
+		},
+		likedArtists: make(map[string]int, len(snap.LikedArtists)),
+
+		skipVotes:    make(map[UserUUID]struct{}, len(snap.SkipVotes)),
+		skipFraction: snap.SkipFraction,
+		skipCount:    snap.SkipCount,
+
+		limits:         snap.SuggestionLimits,
+		lastSuggested:  make(map[UserUUID]time.Time, len(snap.LastSuggested)),
+		suggestedPlays: make(map[UserUUID][]time.Time, len(snap.SuggestedPlays)),
+
+		veto:   snap.VetoRule,
+		vetoed: make(map[SongUID]time.Time, len(snap.Vetoed)),
+
+		scoring: snap.Scoring.copy(),
+
+		eventSeq: snap.EventSeq,
+	}
+
+	for _, uid := range snap.Bans {
+		p.bans[uid] = struct{}{}
+	}
+
+	for i := range snap.Invites {
+		invite := snap.Invites[i]
+		p.invites[invite.Code] = &invite
+	}
+
+	for _, info := range snap.Songs {
+		p.songs[info.ID] = info
+	}
+
+	// older snapshots don't have the radio
+	if !IsValidRadioMode(p.radio.mode) {
+		p.radio = radio{mode: RadioOff}
+	}
+
+	for artist, count := range snap.LikedArtists {
+		p.likedArtists[artist] = count
+	}
+
+	for sid, until := range snap.Vetoed {
+		p.vetoed[sid] = until
+	}
+
+	for uid, at := range snap.LastSuggested {
+		p.lastSuggested[uid] = at
+	}
+
+	for uid, plays := range snap.SuggestedPlays {
+		p.suggestedPlays[uid] = append([]time.Time(nil), plays...)
+	}
+
+	for _, uid := range snap.SkipVotes {
+		p.skipVotes[uid] = struct{}{}
+	}
+
+	// older snapshots don't have a skip threshold
+	if p.skipFraction <= 0 || p.skipFraction > 1 {
+		p.skipFraction = defaultSkipFraction
+	}
+
+	for sid, ms := range snap.Durations {
+		p.durations[sid] = time.Duration(ms) * time.Millisecond
+	}
+
+	p.suggestionQueue.clock = p.now
+	p.suggestionQueue.SetScorer(p.scorer())
+
+	p.nowPlaying.clock = p.now
+	if p.nowPlaying.CurrentlyHasSong() {
+		p.nowPlaying.SetDuration(p.songDuration(p.nowPlaying.GetCurrentlyPlaying()))
+	}
+
+	for _, snapZone := range snap.Zones {
+		zone := &Zone{
+			name:       snapZone.Name,
+			muted:      snapZone.Muted,
+			grouped:    snapZone.Grouped,
+			nowPlaying: restoreNowPlaying(snapZone.NowPlaying),
+		}
+
+		zone.nowPlaying.clock = p.now
+		if zone.nowPlaying.CurrentlyHasSong() {
+			zone.nowPlaying.SetDuration(p.songDuration(zone.nowPlaying.GetCurrentlyPlaying()))
+		}
+
+		p.zones[zone.name] = zone
+	}
+
+	for uid, user := range snap.Users {
+		p.users[uid] = restoreUser(user)
+	}
+
+	// start from the defaults so permissions added since the snapshot exist
+	for key := range PermissionDescriptionMap {
+		p.permMap[key] = true
+	}
+
+	for key, value := range snap.Permissions {
+		if _, valid := PermissionDescriptionMap[key]; valid {
+			p.permMap[key] = value
+		}
+	}
+
+	return p
+}
+
+func (u User) snapshot() UserSnapshot {
+	perms := make(map[string]bool, len(u.permissions))
+	for key, value := range u.permissions {
+		perms[key] = value
+	}
+
+	return UserSnapshot{
+		Name:        u.name,
+		Role:        u.role,
+		Joined:      u.joined,
+		Permissions: perms,
+	}
+}
+
+func restoreUser(snap UserSnapshot) *User {
+	user := NewUser(snap.Name)
+	user.joined = snap.Joined
+
+	// give everyone a fresh heartbeat window after a restore
+	user.lastSeen = time.Now()
+
+	// older snapshots don't have roles, the owner's comes from the party
+	if IsValidRole(snap.Role) && snap.Role != RoleOwner {
+		user.role = snap.Role
+	}
+
+	for key, value := range snap.Permissions {
+		// older snapshots have placeholder permissions
+		if _, valid := PermissionDescriptionMap[key]; valid {
+			user.SetPermission(key, value)
+		}
+	}
+
+	return user
+}
+
+func (q VotableQueue) snapshot() VotableQueueSnapshot {
+	songs := make([]VotableSongSnapshot, 0, len(q.songs))
+	for _, vse := range q.songs {
+		votes := make(map[UserUUID]int, len(vse.votes))
+		votedAt := make(map[UserUUID]time.Time, len(vse.votedAt))
+		for uid, vote := range vse.votes {
+			votes[uid] = vote
+			votedAt[uid] = vse.votedAt[uid]
+		}
+
+		songs = append(songs, VotableSongSnapshot{
+			ID:          vse.songID,
+			PosAdded:    vse.posAdded,
+			SuggestedBy: vse.suggestedBy,
+			Votes:       votes,
+			VotedAt:     votedAt,
+		})
+	}
+
+	served := make(map[UserUUID]uint64, len(q.served))
+	for uid, at := range q.served {
+		served[uid] = at
+	}
+
+	return VotableQueueSnapshot{
+		Songs:      songs,
+		AddCounter: q.addCounter,
+		Order:      q.order,
+		Served:     served,
+		PopCounter: q.popCounter,
+	}
+}
+
+// restoreVotableQueue from snap, votes with no time were cast by lastChange
+func restoreVotableQueue(snap VotableQueueSnapshot, lastChange time.Time) VotableQueue {
+	q := NewVotableQueue()
+	q.addCounter = snap.AddCounter
+	q.popCounter = snap.PopCounter
+
+	// older snapshots don't have an order
+	if IsValidQueueOrder(snap.Order) {
+		q.order = snap.Order
+	}
+
+	for uid, at := range snap.Served {
+		q.served[uid] = at
+	}
+
+	for _, song := range snap.Songs {
+		vse := NewVotableSongElement(song.PosAdded, song.ID)
+		vse.suggestedBy = song.SuggestedBy
+		for uid, vote := range song.Votes {
+			vse.setVote(uid, vote)
+		}
+
+		for uid := range song.Votes {
+			vse.votedAt[uid] = lastChange
+		}
+
+		for uid, at := range song.VotedAt {
+			vse.votedAt[uid] = at
+		}
+
+		q.insert(&vse)
+	}
+
+	return q
+}
+
+func (np NowPlaying) snapshot() NowPlayingSnapshot {
+	return NowPlayingSnapshot{
+		Song:      np.nowPlaying,
+		StartTime: np.startTime,
+		SongPos:   np.songPos,
+		Volume:    np.volume,
+		Playing:   np.playing,
+
+		AutoPicked: np.autoPicked,
+	}
+}
+
+func restoreNowPlaying(snap NowPlayingSnapshot) NowPlaying {
+	return NowPlaying{
+		nowPlaying: snap.Song,
+		startTime:  snap.StartTime,
+		songPos:    snap.SongPos,
+		volume:     snap.Volume,
+		playing:    snap.Playing,
+		autoPicked: snap.AutoPicked,
+	}
+}
+
+// songsFromList copies a list of songs front to back
+func songsFromList(songs *list.List) []SongUID {
+	ret := make([]SongUID, 0, songs.Len())
+	for elem := songs.Front(); elem != nil; elem = elem.Next() {
+		ret = append(ret, elem.Value.(SongUID))
+	}
+
+	return ret
+}
+
+// listFromSongs is the inverse of songsFromList
+func listFromSongs(songs []SongUID) *list.List {
+	ret := list.New()
+	for _, sid := range songs {
+		ret.PushBack(sid)
+	}
+
+	return ret
+}
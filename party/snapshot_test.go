@@ -0,0 +1,82 @@
+package party_test
+
+import (
+	"encoding/json"
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// round trips a party through json like the server's store does
+func roundTrip(t *testing.T, p *party.Party) *party.Party {
+	raw, err := json.Marshal(p.Snapshot())
+	assert.Nil(t, err)
+
+	var snap party.Snapshot
+	assert.Nil(t, json.Unmarshal(raw, &snap))
+
+	return party.Restore(snap)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ouid := party.UserUUID("1")
+	fuid := party.UserUUID("2")
+
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.AddUser(fuid, "fred"))
+
+	assert.Nil(t, p.Suggest(ouid, "a"))
+	assert.Nil(t, p.Suggest(ouid, "b"))
+	assert.Nil(t, p.Suggest(ouid, "c"))
+	assert.Nil(t, p.SuggestionDownvote(fuid, "b"))
+	assert.Nil(t, p.PlayNext(ouid, "d"))
+	assert.Nil(t, p.Skip(ouid, "a"))
+	assert.Nil(t, p.SetVolume(ouid, "", 40))
+	assert.Nil(t, p.SetPermission(party.UserCanSeekPermission, false, ouid))
+	assert.Nil(t, p.SetUserPermission(ouid, fuid, party.UserCanSkipPermission, false))
+	assert.Nil(t, p.SetRole(ouid, fuid, party.RoleModerator))
+	assert.Nil(t, p.Ban(ouid, "troll"))
+	assert.Nil(t, p.SetPassword(ouid, "hunter2"))
+
+	restored := roundTrip(t, p)
+
+	// same change id means clients don't need to resync
+	expected, err := p.Pull(fuid, 0)
+	assert.Nil(t, err)
+	actual, err := restored.Pull(fuid, 0)
+	assert.Nil(t, err)
+
+	expectedData := expected.(map[string]interface{})
+	actualData := actual.(map[string]interface{})
+	assert.Equal(t, expectedData[party.PullChangeKey], actualData[party.PullChangeKey])
+	assert.Equal(t, expectedData[party.PullSuggestKey], actualData[party.PullSuggestKey])
+	assert.Equal(t, expectedData[party.PullPlayNextKey], actualData[party.PullPlayNextKey])
+	assert.Equal(t, expectedData[party.PullPermissionKey], actualData[party.PullPermissionKey])
+	assert.Equal(t, expectedData[party.PullMyPermissionsKey], actualData[party.PullMyPermissionsKey])
+	assert.Equal(t, expectedData[party.PullRolesKey], actualData[party.PullRolesKey])
+
+	// owner, users and bans survive
+	assert.True(t, restored.CanUserEndParty(ouid))
+	assert.NotNil(t, restored.AddUser("troll", "tom"))
+	assert.NotNil(t, restored.Join("guest", "gary", "", ""))
+	assert.Nil(t, restored.Join("guest", "gary", "hunter2", ""))
+	assert.NotNil(t, restored.AddUser(fuid, "fred"))
+	assert.NotNil(t, restored.Seek(fuid, 1))
+
+	// queues keep their order, c has more votes than b
+	finished := party.SongUID("d")
+	for _, expected := range []party.SongUID{"c", "b"} {
+		assert.Nil(t, restored.SongFinished(ouid, finished))
+		actual, err := getCurrentlyPlaying(restored, ouid)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+		finished = actual
+	}
+
+	// previous stack survives
+	assert.Nil(t, restored.Previous(ouid, "b"))
+	actual, err = restored.Pull(ouid, 0)
+	assert.Nil(t, err)
+	playing := actual.(map[string]interface{})[party.PullPlayingKey].(map[string]interface{})
+	assert.Equal(t, party.SongUID("c"), playing[party.KCurrentSongID])
+}
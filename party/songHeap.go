@@ -0,0 +1,92 @@
+package party
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// heap slots, which of a song's indexes a songHeap keeps up to date
+const (
+	scoreSlot = iota
+	suggesterSlot
+	heapSlots
+)
+
+// songHeap orders songs by score for container/heap, the top song first.
+// Songs remember where they are in the heap so they can be fixed or
+// removed without a search.
+type songHeap struct {
+	songs []*VotableSongElement
+	slot  int
+}
+
+// Len of the heap
+func (h songHeap) Len() int {
+	return len(h.songs)
+}
+
+// Less checks if song i plays before song j
+func (h songHeap) Less(i, j int) bool {
+	return scoreBefore(h.songs[i], h.songs[j])
+}
+
+// Swap songs i and j
+func (h songHeap) Swap(i, j int) {
+	h.songs[i], h.songs[j] = h.songs[j], h.songs[i]
+	h.songs[i].heapIndex[h.slot] = i
+	h.songs[j].heapIndex[h.slot] = j
+}
+
+// Push a song, use heap.Push
+func (h *songHeap) Push(x interface{}) {
+	vse := x.(*VotableSongElement)
+	vse.heapIndex[h.slot] = len(h.songs)
+	h.songs = append(h.songs, vse)
+}
+
+// Pop the last song, use heap.Pop
+func (h *songHeap) Pop() interface{} {
+	last := len(h.songs) - 1
+	vse := h.songs[last]
+	h.songs[last] = nil
+	h.songs = h.songs[:last]
+	vse.heapIndex[h.slot] = -1
+
+	return vse
+}
+
+// top song of the heap, nil if it's empty
+func (h songHeap) top() *VotableSongElement {
+	if len(h.songs) == 0 {
+		return nil
+	}
+
+	return h.songs[0]
+}
+
+// add a song
+func (h *songHeap) add(vse *VotableSongElement) {
+	heap.Push(h, vse)
+}
+
+// remove a song
+func (h *songHeap) remove(vse *VotableSongElement) {
+	heap.Remove(h, vse.heapIndex[h.slot])
+}
+
+// fix a song after its score changed
+func (h *songHeap) fix(vse *VotableSongElement) {
+	heap.Fix(h, vse.heapIndex[h.slot])
+}
+
+// sorted copy of the songs, the top song first
+func (h songHeap) sorted() []*VotableSongElement {
+	songs := make([]*VotableSongElement, len(h.songs))
+	copy(songs, h.songs)
+
+	sort.Slice(songs, func(i, j int) bool {
+		return scoreBefore(songs[i], songs[j])
+	})
+
+	return songs
+}
@@ -10,9 +10,16 @@ import (
 // The client can determine the current song position with the song start time,
 // song pos, and current server time.
 // The time diff gives a relative "time since seek'd to songPos"
+// Positions are in seconds.
 type NowPlaying struct {
 	nowPlaying SongUID
 
+	// 0 if we don't know how long the song is
+	duration time.Duration
+
+	// the radio picked the song, not a guest
+	autoPicked bool
+
 	// when we started the song
 	startTime time.Time
 	songPos   float32
@@ -21,6 +28,18 @@ type NowPlaying struct {
 	volume uint32
 
 	playing bool
+
+	// clock used for start times, nil means time.Now
+	clock func() time.Time
+}
+
+// now from the clock
+func (np *NowPlaying) now() time.Time {
+	if np.clock == nil {
+		return time.Now()
+	}
+
+	return np.clock()
 }
 
 // CurrentlyHasSong checks if there is a song currently playing
@@ -31,14 +50,17 @@ func (np *NowPlaying) CurrentlyHasSong() bool {
 // SetNonePlaying indicates that no song is playing.
 func (np *NowPlaying) SetNonePlaying() {
 	np.nowPlaying = ""
+	np.autoPicked = false
 }
 
 // ChangeSong changes the currently playing song.
 // Always start at 0:00 when changing.
 func (np *NowPlaying) ChangeSong(song SongUID) {
 	np.nowPlaying = song
+	np.duration = 0
+	np.autoPicked = false
 	np.songPos = 0
-	np.startTime = time.Now()
+	np.startTime = np.now()
 
 	// make sure that the song doesn't get paused
 	np.playing = true
@@ -47,7 +69,7 @@ func (np *NowPlaying) ChangeSong(song SongUID) {
 // Seek to a position in the song.
 // Client needs to make sure that this makes sense.
 func (np *NowPlaying) Seek(pos float32) {
-	np.startTime = time.Now()
+	np.startTime = np.now()
 	np.songPos = pos
 }
 
@@ -80,12 +102,38 @@ func (np *NowPlaying) SetPlaying() error {
 	}
 
 	// need to update time
-	np.startTime = time.Now()
+	np.startTime = np.now()
 
 	np.playing = true
 	return nil
 }
 
+// SetDuration of the current song, 0 if it isn't known
+func (np *NowPlaying) SetDuration(duration time.Duration) {
+	np.duration = duration
+}
+
+// Elapsed is how far into the song playback is right now
+func (np *NowPlaying) Elapsed() time.Duration {
+	return np.elapsedAt(np.now())
+}
+
+// elapsedAt is how far into the song playback is at now
+func (np *NowPlaying) elapsedAt(now time.Time) time.Duration {
+	elapsed := time.Duration(float64(np.songPos) * float64(time.Second))
+	if np.playing {
+		elapsed += now.Sub(np.startTime)
+	}
+
+	return elapsed
+}
+
+// Over checks if the song played all the way through.
+// Paused songs and songs we don't know the length of are never over.
+func (np *NowPlaying) Over() bool {
+	return np.CurrentlyHasSong() && np.playing && np.duration > 0 && np.Elapsed() >= np.duration
+}
+
 // GetCurrentlyPlaying song
 func (np *NowPlaying) GetCurrentlyPlaying() SongUID {
 	return np.nowPlaying
@@ -97,13 +145,20 @@ const (
 	KCurrentTimeMs   = "CurrentTimeMs"
 	KSongPosition    = "SongPos"
 	KCurrentSongID   = "CurrentSongId"
+	KCurrentSongInfo = "CurrentSongInfo"
+	KPosition        = "Position"
+	KDurationMs      = "DurationMs"
 	KHasSong         = "HasSong"
 	KVolume          = "Volume"
 	KPlaying         = "Playing"
 )
 
 // Data returns {songStartTime, pos, currTime}.
-func (np NowPlaying) Data() interface{} {
+// Times are ms on the server's clock, clients sync to it with /clock.
+// Position is where playback is at currTime, so playback is at
+// Position + (t - currTime) at server time t while playing.
+// The song's info is included if the catalog has it, catalog may be nil.
+func (np NowPlaying) Data(catalog Catalog) interface{} {
 	data := make(map[string]interface{})
 
 	toMs := func(t time.Time) int64 {
@@ -111,10 +166,22 @@ func (np NowPlaying) Data() interface{} {
 	}
 
 	if np.CurrentlyHasSong() {
+		// position and time from the same instant so clients can extrapolate
+		now := np.now()
 		data[KSongStartTimeMs] = toMs(np.startTime)
-		data[KCurrentTimeMs] = toMs(time.Now())
+		data[KCurrentTimeMs] = toMs(now)
 		data[KSongPosition] = np.songPos
+		data[KPosition] = float32(np.elapsedAt(now).Seconds())
+		if np.duration > 0 {
+			data[KDurationMs] = int64(np.duration / time.Millisecond)
+		}
 		data[KCurrentSongID] = np.nowPlaying
+		data[KAutoPicked] = np.autoPicked
+		if catalog != nil {
+			if info, err := catalog.Lookup(np.nowPlaying); err == nil {
+				data[KCurrentSongInfo] = info
+			}
+		}
 		data[KHasSong] = true
 		data[KVolume] = np.volume
 		data[KPlaying] = np.playing
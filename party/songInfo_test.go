@@ -13,7 +13,7 @@ func TestSongInfo(t *testing.T) {
 	np.ChangeSong("1")
 
 	getRaw := func(np *party.NowPlaying) map[string]interface{} {
-		raw := np.Data()
+		raw := np.Data(nil)
 		data := raw.(map[string]interface{})
 		return data
 	}
@@ -1,8 +1,19 @@
 package party
 
+import (
+	"time"
+)
+
 // User at a party
 type User struct {
-	name        string
+	name string
+	role Role
+
+	// when the user joined and when their client was last heard from
+	joined   time.Time
+	lastSeen time.Time
+
+	// overrides of the party's permissions for just this user
 	permissions map[string]bool
 }
 
@@ -13,6 +24,7 @@ type UserUUID string
 func NewUser(name string) *User {
 	return &User{
 		name:        name,
+		role:        RoleGuest,
 		permissions: make(map[string]bool),
 	}
 }
@@ -28,6 +40,17 @@ func (u *User) SetPermission(action string, canPerform bool) {
 	u.permissions[action] = canPerform
 }
 
+// Permission gets the user's override for an action, if there is one
+func (u User) Permission(action string) (canPerform bool, has bool) {
+	canPerform, has = u.permissions[action]
+	return
+}
+
+// ClearPermission removes the user's override so the party's permission applies
+func (u *User) ClearPermission(action string) {
+	delete(u.permissions, action)
+}
+
 // Data satisfies the serializable interface
 func (u User) Data() interface{} {
 	return u.permissions
@@ -42,6 +65,7 @@ const (
 	UserCanChangeVolumePermission   = "Volume"
 	UserCanPlayPausePermission      = "PlayPause"
 	UserCanPlaySongNextPermission   = "PlayNext"
+	UserCanVoteSkipPermission       = "VoteSkip"
 )
 
 // maps can't be const in go
@@ -54,6 +78,7 @@ var (
 		UserCanPlayPausePermission:      "Users can play and pause music",
 		UserCanPlaySongNextPermission:   "User can add a song to playnext",
 		UserCanSkipPermission:           "User can skip a song",
+		UserCanVoteSkipPermission:       "Users can vote to skip the current song",
 	}
 )
 
@@ -0,0 +1,141 @@
+package party
+
+// this file has vetoing, which takes songs the party doesn't want out of
+// the suggestion queue
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// VetoRule for the suggestion queue. A song is vetoed on the downvote
+// that crosses either threshold, 0 turns a threshold off.
+type VetoRule struct {
+	// vetoed when its net score gets down to -NetDownvotes
+	NetDownvotes int `json:"netDownvotes,omitempty"`
+
+	// vetoed when this fraction of the active users downvoted it
+	DownvoteFraction float64 `json:"downvoteFraction,omitempty"`
+
+	// how long a vetoed song can't be suggested again
+	BanMs int64 `json:"banMs,omitempty"`
+}
+
+// consts for pulling vetoes
+const (
+	KVetoRule    = "veto"
+	KVetoedUntil = "untilMs"
+)
+
+// SetVetoRule for the suggestion queue. Songs already in the queue are
+// only vetoed on their next downvote.
+// uid of person changing the rule.
+func (p *Party) SetVetoRule(uid UserUUID, rule VetoRule) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetVetoRule, Actor: uid, Veto: &rule})
+
+	if can, err := p.canUserDo(uid, CapabilityManageQueue); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't change the veto rule")
+	}
+
+	if rule.NetDownvotes < 0 || rule.BanMs < 0 {
+		return fmt.Errorf("veto rule can't be negative")
+	}
+
+	if rule.DownvoteFraction < 0 || rule.DownvoteFraction > 1 {
+		return fmt.Errorf("downvote fraction has to be between 0 and 1")
+	}
+
+	p.veto = rule
+	p.setUpdated(PullSuggestKey)
+
+	return nil
+}
+
+// crossesVeto checks if a song in the suggestion queue should be vetoed.
+// active is how many users were active when the vote was cast.
+func (p *Party) crossesVeto(sid SongUID, active int) bool {
+	vse, has := p.suggestionQueue.songs[sid]
+	if !has {
+		return false
+	}
+
+	if p.veto.NetDownvotes > 0 && vse.Sum() <= -p.veto.NetDownvotes {
+		return true
+	}
+
+	if p.veto.DownvoteFraction > 0 && active > 0 {
+		return float64(vse.Downvotes()) >= p.veto.DownvoteFraction*float64(active)
+	}
+
+	return false
+}
+
+// vetoSong out of the suggestion queue
+func (p *Party) vetoSong(sid SongUID) error {
+	if err := p.suggestionQueue.RemoveSong(sid); err != nil {
+		return err
+	}
+
+	if p.veto.BanMs > 0 {
+		p.vetoed[sid] = p.now().Add(time.Duration(p.veto.BanMs) * time.Millisecond)
+	}
+
+	p.setUpdated(PullSuggestKey, PullVetoedKey)
+	return nil
+}
+
+// checkVetoed returns an error if the song was vetoed recently
+func (p *Party) checkVetoed(sid SongUID) error {
+	until, has := p.vetoed[sid]
+	if !has {
+		return nil
+	}
+
+	if !p.now().Before(until) {
+		delete(p.vetoed, sid)
+		return nil
+	}
+
+	return fmt.Errorf("song was vetoed, it can be suggested again at %s", until.Format(time.RFC3339))
+}
+
+// vetoedSongs that can't be suggested yet, sorted
+func (p *Party) vetoedSongs() []SongUID {
+	songs := make([]SongUID, 0, len(p.vetoed))
+	for sid, until := range p.vetoed {
+		if p.now().Before(until) {
+			songs = append(songs, sid)
+		}
+	}
+
+	sort.Slice(songs, func(i, j int) bool {
+		return songs[i] < songs[j]
+	})
+
+	return songs
+}
+
+// addVetoRule to the suggestion section
+func (p *Party) addVetoRule(data interface{}) {
+	if suggest, ok := data.(map[string]interface{}); ok {
+		suggest[KVetoRule] = p.veto
+	}
+}
+
+// vetoedData for pull
+func (p *Party) vetoedData() []interface{} {
+	songs := p.vetoedSongs()
+	data := make([]interface{}, len(songs))
+	for i, sid := range songs {
+		song := songData(sid, p.songs)
+		song[KVetoedUntil] = p.vetoed[sid].UnixNano() / int64(time.Millisecond)
+		data[i] = song
+	}
+
+	return data
+}
@@ -0,0 +1,94 @@
+package party_test
+
+import (
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVetoNetDownvotes(t *testing.T) {
+	ouid := party.UserUUID("1")
+	g1 := party.UserUUID("2")
+	g2 := party.UserUUID("3")
+
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.AddUser(g1, "gary"))
+	assert.Nil(t, p.AddUser(g2, "gus"))
+	assert.Nil(t, p.PlayNext(ouid, "playing"))
+
+	assert.NotNil(t, p.SetVetoRule(g1, party.VetoRule{NetDownvotes: 1}))
+	assert.NotNil(t, p.SetVetoRule(ouid, party.VetoRule{DownvoteFraction: 2}))
+	assert.Nil(t, p.SetVetoRule(ouid, party.VetoRule{NetDownvotes: 1, BanMs: 60000}))
+
+	// the suggester's upvote keeps it at 0 after one downvote
+	assert.Nil(t, p.Suggest(g1, "a"))
+	assert.Nil(t, p.SuggestionDownvote(g2, "a"))
+
+	raw, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	change := raw.(map[string]interface{})[party.PullChangeKey].(uint64)
+
+	assert.Nil(t, p.SuggestionDownvote(ouid, "a"))
+
+	raw, err = p.Pull(ouid, change)
+	assert.Nil(t, err)
+	data := raw.(map[string]interface{})
+	suggestions := data[party.PullSuggestKey].(map[string]interface{})
+	assert.Len(t, suggestions["songs"], 0)
+
+	vetoed := data[party.PullVetoedKey].([]interface{})
+	assert.Len(t, vetoed, 1)
+	song := vetoed[0].(map[string]interface{})
+	assert.Equal(t, party.SongUID("a"), song["id"])
+	assert.Contains(t, song, party.KVetoedUntil)
+
+	changes, _ := p.ChangesSince(change)
+	assert.Contains(t, changes, party.ChangeVeto)
+
+	// can't come back for a while
+	assert.NotNil(t, p.Suggest(g1, "a"))
+
+	restored := party.Restore(p.Snapshot())
+	assert.NotNil(t, restored.Suggest(g1, "a"))
+}
+
+func TestVetoFraction(t *testing.T) {
+	ouid := party.UserUUID("1")
+	g1 := party.UserUUID("2")
+	g2 := party.UserUUID("3")
+	g3 := party.UserUUID("4")
+
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.AddUser(g1, "gary"))
+	assert.Nil(t, p.AddUser(g2, "gus"))
+	assert.Nil(t, p.AddUser(g3, "gina"))
+	assert.Nil(t, p.PlayNext(ouid, "playing"))
+	var events []party.Event
+	base := captureEvents(p, &events)
+
+	// half of the 4 users, and no ban so it can be suggested again
+	assert.Nil(t, p.SetVetoRule(ouid, party.VetoRule{DownvoteFraction: 0.5}))
+	assert.Nil(t, p.Suggest(ouid, "a"))
+	assert.Nil(t, p.Suggest(ouid, "b"))
+	assert.Nil(t, p.SuggestionDownvote(g1, "a"))
+	assert.Nil(t, p.SuggestionDownvote(g2, "a"))
+
+	raw, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	suggestions := raw.(map[string]interface{})[party.PullSuggestKey].(map[string]interface{})
+	assert.Len(t, suggestions["songs"], 1)
+	assert.Equal(t, party.VetoRule{DownvoteFraction: 0.5}, suggestions[party.KVetoRule])
+
+	assert.Nil(t, p.Suggest(ouid, "a"))
+
+	// replays use who was active at the time
+	restored := replay(t, base, events)
+
+	expectedData, err := p.Pull(ouid, 0)
+	assert.Nil(t, err)
+	actualData, err := restored.Pull(ouid, 0)
+	assert.Nil(t, err)
+	assert.Equal(t,
+		expectedData.(map[string]interface{})[party.PullSuggestKey],
+		actualData.(map[string]interface{})[party.PullSuggestKey])
+}
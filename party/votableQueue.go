@@ -1,33 +1,177 @@
 package party
 
 import (
+	"container/heap"
 	"fmt"
-	"sort"
+	"time"
 )
 
 // SongUID uniquely identifies a song
 type SongUID string
 
-// Song represents the actual song data
-type Song struct {
-	id SongUID
+// QueueOrder is how the suggestion queue picks the next song
+type QueueOrder string
+
+// queue orders
+const (
+	// most votes first, ties go to the song added first
+	OrderVotes QueueOrder = "votes"
+
+	// takes turns between suggesters, starting with whoever had a song
+	// play longest ago. Each suggester's songs go by votes.
+	OrderRoundRobin QueueOrder = "roundRobin"
+)
+
+// IsValidQueueOrder checks that the order exists
+func IsValidQueueOrder(order QueueOrder) bool {
+	switch order {
+	case OrderVotes, OrderRoundRobin:
+		return true
+	}
+
+	return false
 }
 
-// VotableQueue defines a queue that can be voted on
+// VotableQueue defines a queue that can be voted on.
+// Songs keep their key, and are kept in heaps by key so votes and pops
+// don't have to look at every song.
 type VotableQueue struct {
-	songs map[SongUID]VotableSongElement
+	songs map[SongUID]*VotableSongElement
+
+	// all the songs, and each suggester's songs, by key
+	byScore     songHeap
+	bySuggester map[UserUUID]*songHeap
 
 	addCounter uint64
+
+	order QueueOrder
+
+	// when each suggester last had a song popped, for OrderRoundRobin.
+	// Higher is more recent, popCounter is the latest.
+	served     map[UserUUID]uint64
+	popCounter uint64
+
+	// scores songs, see scoring.go
+	scorer Scorer
+
+	// keys are relative to epoch for KeyedScorers
+	epoch time.Time
+
+	// when votes are cast, the party's clock
+	clock func() time.Time
+
+	// play order, so pulls between changes don't sort again.
+	// nil if something changed.
+	ordered []*VotableSongElement
 }
 
 // NewVotableQueue returns a queue can can be voted on
 func NewVotableQueue() VotableQueue {
 	return VotableQueue{
-		songs:      make(map[SongUID]VotableSongElement),
-		addCounter: 0,
+		songs:       make(map[SongUID]*VotableSongElement),
+		byScore:     songHeap{slot: scoreSlot},
+		bySuggester: make(map[UserUUID]*songHeap),
+		addCounter:  0,
+		order:       OrderVotes,
+		served:      make(map[UserUUID]uint64),
+		scorer:      VoteScorer{},
+	}
+}
+
+// SetScorer the queue orders songs with
+func (q *VotableQueue) SetScorer(scorer Scorer) {
+	q.scorer = scorer
+	q.Rescore()
+}
+
+// Rescore every song. Scorers that look at more than votes need this
+// when what they look at changes, ie a voter's role.
+func (q *VotableQueue) Rescore() {
+	q.epoch = q.now()
+	for _, vse := range q.songs {
+		vse.key = q.keyOf(vse)
+	}
+
+	heap.Init(&q.byScore)
+	for _, songs := range q.bySuggester {
+		heap.Init(songs)
+	}
+
+	q.ordered = nil
+}
+
+// rescoreIfVolatile before using the keys, if songs trade places over time
+func (q *VotableQueue) rescoreIfVolatile() {
+	if volatile, ok := q.scorer.(VolatileScorer); ok && volatile.Volatile() {
+		q.Rescore()
 	}
 }
 
+// rescoreIfStale keys every song again if the epoch is too old for new keys
+func (q *VotableQueue) rescoreIfStale() {
+	keyed, ok := q.scorer.(KeyedScorer)
+	if !ok || keyed.EpochLasts() <= 0 {
+		return
+	}
+
+	if q.now().Sub(q.epoch) > keyed.EpochLasts() {
+		q.Rescore()
+	}
+}
+
+// keyOf a song, what the heaps order by.
+// It's the score unless the scorer is keyed.
+func (q *VotableQueue) keyOf(vse *VotableSongElement) float64 {
+	if keyed, ok := q.scorer.(KeyedScorer); ok {
+		return keyed.Key(*vse, q.epoch)
+	}
+
+	return q.scorer.Score(*vse, q.state())
+}
+
+// scoreOf a song from its key
+func (q *VotableQueue) scoreOf(vse *VotableSongElement, state QueueState) float64 {
+	if keyed, ok := q.scorer.(KeyedScorer); ok {
+		return keyed.ScoreOf(vse.key, q.epoch, state)
+	}
+
+	return vse.key
+}
+
+// state of the queue for the scorer
+func (q *VotableQueue) state() QueueState {
+	return QueueState{Now: q.now(), AddCounter: q.addCounter}
+}
+
+// now is the queue's clock
+func (q *VotableQueue) now() time.Time {
+	if q.clock == nil {
+		return time.Now()
+	}
+
+	return q.clock()
+}
+
+// SetOrder the queue picks songs in
+func (q *VotableQueue) SetOrder(order QueueOrder) error {
+	if !IsValidQueueOrder(order) {
+		return fmt.Errorf("no queue order %s", order)
+	}
+
+	if q.order == order {
+		return fmt.Errorf("queue already in %s order", order)
+	}
+
+	q.order = order
+	q.ordered = nil
+	return nil
+}
+
+// Order the queue picks songs in
+func (q *VotableQueue) Order() QueueOrder {
+	return q.order
+}
+
 // AddSong to the queue.
 // Upvotes the song by default.
 func (q *VotableQueue) AddSong(uid UserUUID, sid SongUID) error {
@@ -37,67 +181,178 @@ func (q *VotableQueue) AddSong(uid UserUUID, sid SongUID) error {
 
 	// add song to queue and move the song counter
 	vse := NewVotableSongElement(q.addCounter, sid)
+	vse.suggestedBy = uid
 	vse.Upvote(uid)
+	vse.votedAt[uid] = q.now()
 
 	// incr counter
 	q.addCounter++
 
-	q.songs[sid] = vse
+	q.insert(&vse)
 
 	return nil
 }
 
-// Pull the data from the queue. Use the uid to find which
-// songs the user voted on. Sorts the songs.
-// ret is:
-// {"q":[{"id":<song ID>, "vote":<{1, 0, -1}>}]}
-// where vote is 1 if the user upvoted, 0 if no vote, -1 if downvote
-func (q *VotableQueue) Pull(uid UserUUID) interface{} {
-	// order the songs
-	arr := make([]VotableSongElement, len(q.songs))
-	i := 0
-
-	// move everything to an array
-	for _, vse := range q.songs {
-		arr[i] = vse
-		i++
+// insert a song into the map and heaps
+func (q *VotableQueue) insert(vse *VotableSongElement) {
+	q.rescoreIfStale()
+	vse.key = q.keyOf(vse)
+	q.songs[vse.songID] = vse
+	q.byScore.add(vse)
+
+	songs, has := q.bySuggester[vse.suggestedBy]
+	if !has {
+		songs = &songHeap{slot: suggesterSlot}
+		q.bySuggester[vse.suggestedBy] = songs
 	}
 
-	// sort the array by total votes. Break ties with order added.
-	sort.Slice(arr, func(i, j int) bool {
-		a := arr[i]
-		b := arr[j]
+	songs.add(vse)
+	q.ordered = nil
+}
 
-		if a.Sum() == b.Sum() {
-			return a.posAdded < b.posAdded
-		}
+// rescore a song after its votes changed
+func (q *VotableQueue) rescore(vse *VotableSongElement) {
+	q.rescoreIfStale()
+	vse.key = q.keyOf(vse)
+	q.byScore.fix(vse)
+	q.bySuggester[vse.suggestedBy].fix(vse)
+	q.ordered = nil
+}
 
-		return a.Sum() < b.Sum()
-	})
+// Pull the data from the queue. Use the uid to find which
+// songs the user voted on. Songs are in the order Pop will play them.
+// ret is:
+// {"songs":[{"id":<song ID>, "info":<SongInfo>, "vote":<{1, 0, -1}>, "score":<score>}], "order":<QueueOrder>}
+// where vote is 1 if the user upvoted, 0 if no vote, -1 if downvote.
+// info is only there if the catalog has it, catalog may be nil.
+func (q *VotableQueue) Pull(uid UserUUID, catalog Catalog) interface{} {
+	arr := q.playOrder()
+	state := q.state()
 
 	// now make an array of the data
-	dataArr := make([]interface{}, len(q.songs))
+	dataArr := make([]interface{}, len(arr))
 	for i, vse := range arr {
 		// pull only the info for this user's request
-		dataArr[i] = vse.Pull(uid)
+		song := vse.Pull(uid, catalog).(map[string]interface{})
+		song["score"] = q.scoreOf(vse, state)
+		dataArr[i] = song
 	}
 
 	data := make(map[string]interface{})
 	data["songs"] = dataArr
+	data["order"] = q.order
 
 	return data
 }
 
+// scoreBefore checks if a plays before b going by key.
+// Break ties with order added.
+func scoreBefore(a, b *VotableSongElement) bool {
+	if a.key == b.key {
+		return a.posAdded < b.posAdded
+	}
+
+	return a.key > b.key
+}
+
+// playOrder is the order Pop will play the songs in
+// if nothing changes in between. Don't change what's returned.
+func (q *VotableQueue) playOrder() []*VotableSongElement {
+	q.rescoreIfVolatile()
+	if q.ordered != nil {
+		return q.ordered
+	}
+
+	if q.order != OrderRoundRobin {
+		q.ordered = q.byScore.sorted()
+		return q.ordered
+	}
+
+	// each suggester's songs, by score
+	bySuggester := make(map[UserUUID][]*VotableSongElement, len(q.bySuggester))
+	served := make(map[UserUUID]uint64, len(q.bySuggester))
+	for uid, songs := range q.bySuggester {
+		bySuggester[uid] = songs.sorted()
+		served[uid] = q.served[uid]
+	}
+
+	// play out the turns without changing the queue
+	counter := q.popCounter
+	order := make([]*VotableSongElement, 0, len(q.songs))
+	for len(order) < len(q.songs) {
+		next := nextTurn(bySuggester, served)
+		order = append(order, bySuggester[next][0])
+		bySuggester[next] = bySuggester[next][1:]
+
+		counter++
+		served[next] = counter
+	}
+
+	q.ordered = order
+	return q.ordered
+}
+
+// nextTurn is the suggester who had a song played longest ago.
+// Ties go to whoever has the best song. songs are each suggester's songs
+// by score.
+func nextTurn(songs map[UserUUID][]*VotableSongElement, served map[UserUUID]uint64) UserUUID {
+	var next UserUUID
+	found := false
+
+	for uid, suggested := range songs {
+		if len(suggested) == 0 {
+			continue
+		}
+
+		if !found || served[uid] < served[next] ||
+			(served[uid] == served[next] && scoreBefore(suggested[0], songs[next][0])) {
+			next = uid
+			found = true
+		}
+	}
+
+	return next
+}
+
 // RemoveSong from the queue.
 func (q *VotableQueue) RemoveSong(sid SongUID) error {
-	if _, has := q.songs[sid]; !has {
+	vse, has := q.songs[sid]
+	if !has {
 		return fmt.Errorf("song not in queue")
 	}
 
+	q.byScore.remove(vse)
+
+	songs := q.bySuggester[vse.suggestedBy]
+	songs.remove(vse)
+	if songs.Len() == 0 {
+		delete(q.bySuggester, vse.suggestedBy)
+	}
+
 	delete(q.songs, sid)
+	q.ordered = nil
 	return nil
 }
 
+// RemoveUser takes out the songs a user suggested and their votes on the rest.
+// Returns true if the queue changed.
+func (q *VotableQueue) RemoveUser(uid UserUUID) bool {
+	changed := false
+
+	for sid, vse := range q.songs {
+		if vse.suggestedBy == uid {
+			q.RemoveSong(sid)
+			changed = true
+		} else if _, voted := vse.votes[uid]; voted {
+			vse.ClearUserVotes(uid)
+			q.rescore(vse)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
 // Upvote song by one
 func (q *VotableQueue) Upvote(uid UserUUID, sid SongUID) error {
 	vse, has := q.songs[sid]
@@ -105,9 +360,9 @@ func (q *VotableQueue) Upvote(uid UserUUID, sid SongUID) error {
 		return fmt.Errorf("song not in queue")
 	}
 
-	// check that the up / down votes are cleaned up properly
-	vse.ClearUserVotes(uid)
 	vse.Upvote(uid)
+	vse.votedAt[uid] = q.now()
+	q.rescore(vse)
 	return nil
 }
 
@@ -118,9 +373,9 @@ func (q *VotableQueue) Downvote(uid UserUUID, sid SongUID) error {
 		return fmt.Errorf("song not in queue")
 	}
 
-	// check that the up / down votes are cleaned up properly
-	vse.ClearUserVotes(uid)
 	vse.Downvote(uid)
+	vse.votedAt[uid] = q.now()
+	q.rescore(vse)
 	return nil
 }
 
@@ -131,39 +386,53 @@ func (q *VotableQueue) ClearVotes(uid UserUUID, sid SongUID) error {
 		return fmt.Errorf("song not in queue")
 	}
 
-	// check that the up / down votes are cleaned up properly
 	vse.ClearUserVotes(uid)
+	q.rescore(vse)
 	return nil
 }
 
 // Pop the top song off of the queue.
 func (q *VotableQueue) Pop() (SongUID, error) {
+	vse, err := q.pop()
+	return vse.songID, err
+}
+
+// pop the top song, the party needs to know who suggested it
+func (q *VotableQueue) pop() (VotableSongElement, error) {
 
 	// check that there are songs in the queue
 	if len(q.songs) == 0 {
-		return "", fmt.Errorf("no songs in queue")
+		return VotableSongElement{}, fmt.Errorf("no songs in queue")
 	}
 
+	q.rescoreIfVolatile()
+
 	// find the "top" song
-	topScore := -10000 // probably a safe lower bound on vote total
-	var topSong VotableSongElement
-
-	for _, song := range q.songs {
-		// if == just check position added
-		if song.Sum() == topScore {
-			if song.posAdded < topSong.posAdded {
-				topSong = song
-			}
+	topSong := q.byScore.top()
+	if q.order == OrderRoundRobin {
+		heads := make(map[UserUUID][]*VotableSongElement, len(q.bySuggester))
+		for uid, songs := range q.bySuggester {
+			heads[uid] = []*VotableSongElement{songs.top()}
 		}
 
-		if song.Sum() > topScore {
-			topScore = song.Sum()
-			topSong = song
-		}
+		topSong = heads[nextTurn(heads, q.served)][0]
 	}
 
+	// the suggester goes to the back of the line
+	q.popCounter++
+	q.served[topSong.suggestedBy] = q.popCounter
+
 	err := q.RemoveSong(topSong.songID)
-	return topSong.songID, err
+	return *topSong, err
+}
+
+// PendingBy counts the songs a user suggested that are still in the queue
+func (q *VotableQueue) PendingBy(uid UserUUID) int {
+	if songs, has := q.bySuggester[uid]; has {
+		return songs.Len()
+	}
+
+	return 0
 }
 
 // values for the song element voting
@@ -177,15 +446,26 @@ const (
 type VotableSongElement struct {
 	votes map[UserUUID]int
 
-	songID   SongUID
-	posAdded uint64
+	// when each vote was cast, for scorers that care
+	votedAt map[UserUUID]time.Time
+
+	// kept up to date as votes change
+	sum       int
+	downvotes int
+
+	// from the queue's scorer, and where the song is in the queue's heaps
+	key       float64
+	heapIndex [heapSlots]int
+
+	songID      SongUID
+	posAdded    uint64
+	suggestedBy UserUUID
 }
 
 // Pull the song data. "posAdded" provides order for sorting.
-func (vse VotableSongElement) Pull(uid UserUUID) interface{} {
-	data := make(map[string]interface{})
+func (vse VotableSongElement) Pull(uid UserUUID, catalog Catalog) interface{} {
+	data := songData(vse.songID, catalog)
 
-	data["id"] = vse.songID
 	data["posAdded"] = vse.posAdded
 	data["totalVotes"] = vse.Sum()
 
@@ -203,6 +483,7 @@ func (vse VotableSongElement) Pull(uid UserUUID) interface{} {
 func NewVotableSongElement(pos uint64, songID SongUID) VotableSongElement {
 	return VotableSongElement{
 		votes:    make(map[UserUUID]int),
+		votedAt:  make(map[UserUUID]time.Time),
 		songID:   songID,
 		posAdded: pos,
 	}
@@ -210,27 +491,69 @@ func NewVotableSongElement(pos uint64, songID SongUID) VotableSongElement {
 
 // ClearUserVotes from both up and down.
 func (vse *VotableSongElement) ClearUserVotes(uid UserUUID) {
-	if _, has := vse.votes[uid]; has {
+	if vote, has := vse.votes[uid]; has {
+		vse.sum -= vote
+		if vote == DownVoteValue {
+			vse.downvotes--
+		}
+
 		delete(vse.votes, uid)
+		delete(vse.votedAt, uid)
 	}
 }
 
 // Upvote this song.
 func (vse *VotableSongElement) Upvote(uid UserUUID) {
-	vse.votes[uid] = UpVoteValue
+	vse.setVote(uid, UpVoteValue)
 }
 
 // Downvote this song.
 func (vse *VotableSongElement) Downvote(uid UserUUID) {
-	vse.votes[uid] = DownVoteValue
+	vse.setVote(uid, DownVoteValue)
 }
 
-// Sum the down votes and the up votes
-func (vse VotableSongElement) Sum() int {
-	sum := 0
-	for _, vote := range vse.votes {
-		sum += vote
+// setVote replacing the user's old vote
+func (vse *VotableSongElement) setVote(uid UserUUID, vote int) {
+	vse.ClearUserVotes(uid)
+
+	vse.votes[uid] = vote
+	vse.sum += vote
+	if vote == DownVoteValue {
+		vse.downvotes++
+	}
+}
+
+// Downvotes on this song
+func (vse VotableSongElement) Downvotes() int {
+	return vse.downvotes
+}
+
+// SongID of this song
+func (vse VotableSongElement) SongID() SongUID {
+	return vse.songID
+}
+
+// PosAdded is how many songs were added to the queue before this one
+func (vse VotableSongElement) PosAdded() uint64 {
+	return vse.posAdded
+}
+
+// Votes on this song by user
+func (vse VotableSongElement) Votes() map[UserUUID]int {
+	votes := make(map[UserUUID]int, len(vse.votes))
+	for uid, vote := range vse.votes {
+		votes[uid] = vote
 	}
 
-	return sum
+	return votes
+}
+
+// VotedAt is when the user voted, zero if they didn't or it isn't known
+func (vse VotableSongElement) VotedAt(uid UserUUID) time.Time {
+	return vse.votedAt[uid]
+}
+
+// Sum the down votes and the up votes
+func (vse VotableSongElement) Sum() int {
+	return vse.sum
 }
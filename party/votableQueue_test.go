@@ -1,6 +1,7 @@
 package party_test
 
 import (
+	"fmt"
 	"github.com/me-next/menext-backend/party"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -34,7 +35,7 @@ func TestVotableQueueSimple(t *testing.T) {
 	assert.NotNil(t, q.AddSong("2", songs[1]))
 
 	// check that the songs are all there
-	rawPull := q.Pull("1")
+	rawPull := q.Pull("1", nil)
 	data := parseSongsFromVQPull(rawPull)
 	assert.Len(t, data, len(songs))
 
@@ -64,3 +65,225 @@ func TestVotableQueueSort(t *testing.T) {
 		assert.Equal(t, song, actual)
 	}
 }
+
+func TestVotableQueueRemoveUser(t *testing.T) {
+	q := party.NewVotableQueue()
+	assert.Nil(t, q.AddSong("1", "a"))
+	assert.Nil(t, q.AddSong("2", "b"))
+	assert.Nil(t, q.AddSong("2", "c"))
+	assert.Nil(t, q.Upvote("2", "a"))
+
+	// nothing from this user
+	assert.False(t, q.RemoveUser("3"))
+
+	// their songs go and their votes stop counting
+	assert.True(t, q.RemoveUser("2"))
+
+	data := parseSongsFromVQPull(q.Pull("1", nil))
+	assert.Len(t, data, 1)
+	assert.Equal(t, party.SongUID("a"), data[0]["id"])
+	assert.Equal(t, 1, data[0]["totalVotes"])
+
+	assert.False(t, q.RemoveUser("2"))
+}
+
+func TestVotableQueueRoundRobin(t *testing.T) {
+	q := party.NewVotableQueue()
+	assert.Nil(t, q.AddSong("1", "a"))
+	assert.Nil(t, q.AddSong("1", "b"))
+	assert.Nil(t, q.AddSong("1", "c"))
+	assert.Nil(t, q.AddSong("2", "d"))
+	assert.Nil(t, q.AddSong("3", "e"))
+	assert.Nil(t, q.Upvote("4", "e"))
+
+	assert.NotNil(t, q.SetOrder("shuffle"))
+	assert.NotNil(t, q.SetOrder(party.OrderVotes))
+	assert.Nil(t, q.SetOrder(party.OrderRoundRobin))
+	assert.Equal(t, party.OrderRoundRobin, q.Order())
+
+	// suggesters take turns, the best song goes first
+	expectedOrder := []party.SongUID{"e", "a", "d", "b", "c"}
+	data := parseSongsFromVQPull(q.Pull("1", nil))
+	assert.Len(t, data, len(expectedOrder))
+	for i, song := range expectedOrder {
+		assert.Equal(t, song, data[i]["id"])
+	}
+
+	// 3 just had a turn, so 1 and 2 go before their next song
+	actual, err := q.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, party.SongUID("e"), actual)
+	assert.Nil(t, q.AddSong("3", "f"))
+
+	expectedOrder = []party.SongUID{"a", "d", "f", "b", "c"}
+	data = parseSongsFromVQPull(q.Pull("1", nil))
+	for i, song := range expectedOrder {
+		assert.Equal(t, song, data[i]["id"])
+	}
+
+	// pops follow what was pulled
+	for _, song := range expectedOrder {
+		actual, err := q.Pop()
+		assert.Nil(t, err)
+		assert.Equal(t, song, actual)
+	}
+}
+
+func TestVotableQueuePullOrder(t *testing.T) {
+	q := party.NewVotableQueue()
+	assert.Nil(t, q.AddSong("1", "a"))
+	assert.Nil(t, q.AddSong("1", "b"))
+	assert.Nil(t, q.AddSong("2", "c"))
+	assert.Nil(t, q.Upvote("2", "b"))
+	assert.Nil(t, q.Downvote("2", "a"))
+
+	// pulls are in the order songs will play
+	data := parseSongsFromVQPull(q.Pull("1", nil))
+	for _, song := range data {
+		actual, err := q.Pop()
+		assert.Nil(t, err)
+		assert.Equal(t, song["id"], actual)
+	}
+}
+
+func TestVotableQueueReorder(t *testing.T) {
+	q := party.NewVotableQueue()
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, q.AddSong(party.UserUUID(fmt.Sprintf("u%d", i%3)), party.SongUID(fmt.Sprintf("s%d", i))))
+	}
+
+	// votes change and get taken back so songs move both ways
+	for v := 0; v < 10; v++ {
+		uid := party.UserUUID(fmt.Sprintf("v%d", v))
+		for i := 0; i < 20; i++ {
+			sid := party.SongUID(fmt.Sprintf("s%d", i))
+			switch (i * (v + 1)) % 5 {
+			case 0:
+				assert.Nil(t, q.Downvote(uid, sid))
+			case 1, 2:
+				assert.Nil(t, q.Upvote(uid, sid))
+			case 3:
+				assert.Nil(t, q.Downvote(uid, sid))
+				assert.Nil(t, q.ClearVotes(uid, sid))
+			}
+		}
+	}
+
+	assert.Nil(t, q.RemoveSong("s7"))
+	assert.True(t, q.RemoveUser("v3"))
+
+	// most votes first, ties go to whoever was added first
+	data := parseSongsFromVQPull(q.Pull("u0", nil))
+	assert.Len(t, data, 19)
+	for i := 1; i < len(data); i++ {
+		prev, cur := data[i-1]["totalVotes"].(int), data[i]["totalVotes"].(int)
+		assert.True(t, prev > cur || (prev == cur && data[i-1]["posAdded"].(uint64) < data[i]["posAdded"].(uint64)))
+	}
+
+	for _, song := range data {
+		actual, err := q.Pop()
+		assert.Nil(t, err)
+		assert.Equal(t, song["id"], actual)
+	}
+}
+
+// sizes for the benchmarks, a big party
+const (
+	benchSongs  = 500
+	benchVoters = 300
+)
+
+// newBenchQueue with benchSongs songs that every voter voted on
+func newBenchQueue(b *testing.B) *party.VotableQueue {
+	q := party.NewVotableQueue()
+	for i := 0; i < benchSongs; i++ {
+		sid := party.SongUID(fmt.Sprintf("s%d", i))
+		if err := q.AddSong(party.UserUUID(fmt.Sprintf("u%d", i%benchVoters)), sid); err != nil {
+			b.Fatal(err)
+		}
+
+		for v := 0; v < benchVoters; v++ {
+			uid := party.UserUUID(fmt.Sprintf("u%d", v))
+			if (i+v)%3 == 0 {
+				q.Downvote(uid, sid)
+			} else {
+				q.Upvote(uid, sid)
+			}
+		}
+	}
+
+	return &q
+}
+
+func BenchmarkVotableQueueVote(b *testing.B) {
+	q := newBenchQueue(b)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		sid := party.SongUID(fmt.Sprintf("s%d", i%benchSongs))
+		uid := party.UserUUID(fmt.Sprintf("u%d", i%benchVoters))
+		if i%2 == 0 {
+			q.Downvote(uid, sid)
+		} else {
+			q.Upvote(uid, sid)
+		}
+	}
+}
+
+func BenchmarkVotableQueuePop(b *testing.B) {
+	q := newBenchQueue(b)
+	b.ResetTimer()
+
+	// pop and suggest again so the queue stays the same size
+	for i := 0; i < b.N; i++ {
+		sid, err := q.Pop()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		q.AddSong("u0", sid)
+	}
+}
+
+func BenchmarkVotableQueuePull(b *testing.B) {
+	q := newBenchQueue(b)
+	b.ResetTimer()
+
+	// every guest pulling after a vote
+	for i := 0; i < b.N; i++ {
+		q.Pull(party.UserUUID(fmt.Sprintf("u%d", i%benchVoters)), nil)
+	}
+}
+
+func BenchmarkVotableQueueVoteAndPull(b *testing.B) {
+	benchVoteAndPull(b, party.VoteScorer{})
+}
+
+func BenchmarkVotableQueueDecayVoteAndPull(b *testing.B) {
+	benchVoteAndPull(b, party.WeightedScorer{Scoring: party.Scoring{HalfLifeMs: 600000}})
+}
+
+func BenchmarkVotableQueueBoostVoteAndPull(b *testing.B) {
+	benchVoteAndPull(b, party.WeightedScorer{Scoring: party.Scoring{WaitBoost: 0.5}})
+}
+
+// decay and a boost together reorder songs as time passes
+func BenchmarkVotableQueueDecayAndBoostVoteAndPull(b *testing.B) {
+	benchVoteAndPull(b, party.WeightedScorer{Scoring: party.Scoring{HalfLifeMs: 600000, WaitBoost: 0.5}})
+}
+
+// benchVoteAndPull has a vote, then everyone pulls it
+func benchVoteAndPull(b *testing.B, scorer party.Scorer) {
+	q := newBenchQueue(b)
+	q.SetScorer(scorer)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		sid := party.SongUID(fmt.Sprintf("s%d", i%benchSongs))
+		q.Upvote(party.UserUUID(fmt.Sprintf("u%d", i%benchVoters)), sid)
+
+		for v := 0; v < 10; v++ {
+			q.Pull(party.UserUUID(fmt.Sprintf("u%d", v)), nil)
+		}
+	}
+}
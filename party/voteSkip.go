@@ -0,0 +1,189 @@
+package party
+
+// this file has voting to skip the current song
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// consts for vote skipping
+const (
+	// by default half of the active users have to vote to skip
+	defaultSkipFraction = 0.5
+
+	// users heard from this recently count towards the votes needed
+	activeUserTimeout = 5 * time.Minute
+)
+
+// consts for pulling skip votes
+const (
+	KSkipVotes       = "SkipVotes"
+	KSkipVotesNeeded = "SkipVotesNeeded"
+	KMySkipVote      = "MySkipVote"
+
+	KSkipFraction = "fraction"
+	KSkipCount    = "count"
+)
+
+// VoteSkip the current song. The song is skipped once enough users vote.
+// sid has to be playing so votes for a song that already ended don't count.
+func (p *Party) VoteSkip(uid UserUUID, sid SongUID) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	// who's active isn't logged, so the votes needed are
+	needed := p.skipVotesNeeded(p.activeUsers())
+	defer p.record(&err, p.changeID, Event{Type: EventVoteSkip, Actor: uid, Song: sid, Count: needed})
+
+	return p.doVoteSkip(uid, sid, needed)
+}
+
+// replayVoteSkip with the votes needed when the vote was cast
+func (p *Party) replayVoteSkip(uid UserUUID, sid SongUID, needed int) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventVoteSkip, Actor: uid, Song: sid, Count: needed})
+
+	return p.doVoteSkip(uid, sid, needed)
+}
+
+// doVoteSkip counts the vote and skips if there are enough
+func (p *Party) doVoteSkip(uid UserUUID, sid SongUID, needed int) error {
+	if can, err := p.canUserPerformAction(uid, UserCanVoteSkipPermission); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't vote to skip")
+	}
+
+	if err := p.checkPlaying(sid); err != nil {
+		return err
+	}
+
+	if _, voted := p.skipVotes[uid]; voted {
+		return fmt.Errorf("user already voted to skip")
+	}
+
+	p.skipVotes[uid] = struct{}{}
+
+	if len(p.skipVotes) >= needed {
+		return p.doPlayNextSong()
+	}
+
+	p.setUpdated(PullPlayingKey)
+	return nil
+}
+
+// SetSkipThreshold for vote skipping. If count isn't 0 that many votes
+// skip a song, otherwise fraction of the active users have to vote.
+// Users count as active if they pulled in the last few minutes.
+// If the current song already has enough votes it's skipped.
+// uid of person changing the threshold.
+func (p *Party) SetSkipThreshold(uid UserUUID, fraction float64, count int) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	// who's active isn't logged, so how many were is
+	active := p.activeUsers()
+	defer p.record(&err, p.changeID, Event{Type: EventSetSkipThreshold, Actor: uid, Fraction: fraction, Count: count, Active: active})
+
+	return p.doSetSkipThreshold(uid, fraction, count, active)
+}
+
+// replaySetSkipThreshold with the users active when it was set
+func (p *Party) replaySetSkipThreshold(uid UserUUID, fraction float64, count int, active int) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetSkipThreshold, Actor: uid, Fraction: fraction, Count: count, Active: active})
+
+	return p.doSetSkipThreshold(uid, fraction, count, active)
+}
+
+// doSetSkipThreshold checks and sets the threshold
+func (p *Party) doSetSkipThreshold(uid UserUUID, fraction float64, count int, active int) error {
+	if can, err := p.canUserDo(uid, CapabilityManagePermissions); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't change the skip threshold")
+	}
+
+	if count < 0 {
+		return fmt.Errorf("bad skip count")
+	}
+
+	if fraction <= 0 || fraction > 1 {
+		return fmt.Errorf("skip fraction has to be more than 0 and at most 1")
+	}
+
+	p.skipFraction = fraction
+	p.skipCount = count
+	p.setUpdated(PullPermissionKey, PullPlayingKey)
+	p.checkSkipVotes(active)
+
+	return nil
+}
+
+// checkSkipVotes skips the current song if it already has enough votes,
+// which happens when the threshold drops. Running out of songs isn't an
+// error for whoever lowered it.
+func (p *Party) checkSkipVotes(active int) {
+	if len(p.skipVotes) > 0 && len(p.skipVotes) >= p.skipVotesNeeded(active) {
+		p.doPlayNextSong()
+	}
+}
+
+// skipVotesNeeded to skip the current song with active users
+func (p *Party) skipVotesNeeded(active int) int {
+	if p.skipCount > 0 {
+		return p.skipCount
+	}
+
+	needed := int(math.Ceil(p.skipFraction * float64(active)))
+	if needed < 1 {
+		needed = 1
+	}
+
+	return needed
+}
+
+// clearSkipVotes when the song changes
+func (p *Party) clearSkipVotes() {
+	p.skipVotes = make(map[UserUUID]struct{})
+}
+
+// skipVoters sorted, for snapshots
+func (p *Party) skipVoters() []UserUUID {
+	voters := make([]UserUUID, 0, len(p.skipVotes))
+	for uid := range p.skipVotes {
+		voters = append(voters, uid)
+	}
+
+	sort.Slice(voters, func(i, j int) bool {
+		return voters[i] < voters[j]
+	})
+
+	return voters
+}
+
+// addSkipData to the playing section for a user
+func (p *Party) addSkipData(data interface{}, uid UserUUID) {
+	playing, ok := data.(map[string]interface{})
+	if !ok || !p.nowPlaying.CurrentlyHasSong() {
+		return
+	}
+
+	_, voted := p.skipVotes[uid]
+	playing[KSkipVotes] = len(p.skipVotes)
+	playing[KSkipVotesNeeded] = p.skipVotesNeeded(p.activeUsers())
+	playing[KMySkipVote] = voted
+}
+
+// skipThresholdData for pull
+func (p *Party) skipThresholdData() map[string]interface{} {
+	return map[string]interface{}{
+		KSkipFraction: p.skipFraction,
+		KSkipCount:    p.skipCount,
+	}
+}
@@ -0,0 +1,148 @@
+package party_test
+
+import (
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVoteSkip(t *testing.T) {
+	ouid := party.UserUUID("1")
+	g1 := party.UserUUID("2")
+	g2 := party.UserUUID("3")
+
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.AddUser(g1, "gary"))
+	assert.Nil(t, p.AddUser(g2, "gus"))
+
+	// nothing to skip
+	assert.NotNil(t, p.VoteSkip(g1, "a"))
+
+	assert.Nil(t, p.PlayNext(ouid, "a"))
+	assert.Nil(t, p.PlayNext(ouid, "b"))
+	assert.Nil(t, p.PlayNext(ouid, "c"))
+
+	// votes only count for the song that's playing, once
+	assert.NotNil(t, p.VoteSkip(g1, "b"))
+	assert.Nil(t, p.VoteSkip(g1, "a"))
+	assert.NotNil(t, p.VoteSkip(g1, "a"))
+
+	// half of the 3 users rounds up to 2
+	playing := pullPlaying(t, p, g1)
+	assert.Equal(t, party.SongUID("a"), playing[party.KCurrentSongID])
+	assert.Equal(t, 1, playing[party.KSkipVotes])
+	assert.Equal(t, 2, playing[party.KSkipVotesNeeded])
+	assert.Equal(t, true, playing[party.KMySkipVote])
+	assert.Equal(t, false, pullPlaying(t, p, g2)[party.KMySkipVote])
+
+	// votes reset when the song changes
+	assert.Nil(t, p.VoteSkip(g2, "a"))
+	playing = pullPlaying(t, p, g1)
+	assert.Equal(t, party.SongUID("b"), playing[party.KCurrentSongID])
+	assert.Equal(t, 0, playing[party.KSkipVotes])
+	assert.Equal(t, false, playing[party.KMySkipVote])
+
+	// leaving takes the vote away
+	assert.Nil(t, p.VoteSkip(g1, "b"))
+	assert.Nil(t, p.RemoveUser(g1))
+	assert.Equal(t, 0, pullPlaying(t, p, ouid)[party.KSkipVotes])
+
+	// hosts can turn voting off
+	assert.Nil(t, p.SetPermission(party.UserCanVoteSkipPermission, false, ouid))
+	assert.NotNil(t, p.VoteSkip(g2, "b"))
+}
+
+func TestSkipThreshold(t *testing.T) {
+	ouid := party.UserUUID("1")
+	guid := party.UserUUID("2")
+
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.AddUser(guid, "gary"))
+	assert.Nil(t, p.PlayNext(ouid, "a"))
+	assert.Nil(t, p.PlayNext(ouid, "b"))
+
+	assert.NotNil(t, p.SetSkipThreshold(guid, 0.5, 1))
+	assert.NotNil(t, p.SetSkipThreshold(ouid, 0, 0))
+	assert.NotNil(t, p.SetSkipThreshold(ouid, 1.5, 0))
+	assert.NotNil(t, p.SetSkipThreshold(ouid, 0.5, -1))
+
+	// every active user has to vote
+	assert.Nil(t, p.SetSkipThreshold(ouid, 1, 0))
+	assert.Nil(t, p.VoteSkip(guid, "a"))
+	assert.Equal(t, 2, pullPlaying(t, p, ouid)[party.KSkipVotesNeeded])
+
+	// a count wins over the fraction
+	assert.Nil(t, p.SetSkipThreshold(ouid, 1, 3))
+	assert.Equal(t, 3, pullPlaying(t, p, ouid)[party.KSkipVotesNeeded])
+
+	raw, err := p.Pull(guid, 0)
+	assert.Nil(t, err)
+	threshold := raw.(map[string]interface{})[party.PullSkipThresholdKey].(map[string]interface{})
+	assert.Equal(t, 1.0, threshold[party.KSkipFraction])
+	assert.Equal(t, 3, threshold[party.KSkipCount])
+
+	// votes and the threshold survive snapshots
+	restored := party.Restore(p.Snapshot())
+	playing := pullPlaying(t, restored, guid)
+	assert.Equal(t, 1, playing[party.KSkipVotes])
+	assert.Equal(t, 3, playing[party.KSkipVotesNeeded])
+	assert.Equal(t, true, playing[party.KMySkipVote])
+}
+
+func TestVoteSkipReplay(t *testing.T) {
+	ouid := party.UserUUID("1")
+	guid := party.UserUUID("2")
+
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.AddUser(guid, "gary"))
+	var events []party.Event
+	base := captureEvents(p, &events)
+
+	assert.Nil(t, p.PlayNext(ouid, "a"))
+	assert.Nil(t, p.PlayNext(ouid, "b"))
+	assert.Nil(t, p.PlayNext(ouid, "c"))
+	assert.Nil(t, p.VoteSkip(guid, "a"))
+	assert.Nil(t, p.SetSkipThreshold(ouid, 0.5, 1))
+	assert.Nil(t, p.VoteSkip(guid, "b"))
+
+	restored := replay(t, base, events)
+
+	expected, actual := p.Snapshot(), restored.Snapshot()
+	assert.Equal(t, expected.SkipVotes, actual.SkipVotes)
+	assert.Equal(t, expected.SkipCount, actual.SkipCount)
+	assert.Equal(t, expected.NowPlaying.Song, actual.NowPlaying.Song)
+	assert.Equal(t, expected.Previous, actual.Previous)
+}
+
+func TestSkipThresholdRecheck(t *testing.T) {
+	ouid := party.UserUUID("1")
+	g1 := party.UserUUID("2")
+	g2 := party.UserUUID("3")
+
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.AddUser(g1, "gary"))
+	assert.Nil(t, p.AddUser(g2, "gus"))
+	var events []party.Event
+	base := captureEvents(p, &events)
+
+	assert.Nil(t, p.PlayNext(ouid, "a"))
+	assert.Nil(t, p.PlayNext(ouid, "b"))
+	assert.Nil(t, p.PlayNext(ouid, "c"))
+
+	// lowering the threshold skips a song that already has enough votes
+	assert.Nil(t, p.VoteSkip(g1, "a"))
+	assert.Equal(t, party.SongUID("a"), pullPlaying(t, p, ouid)[party.KCurrentSongID])
+	assert.Nil(t, p.SetSkipThreshold(ouid, 0.5, 1))
+	assert.Equal(t, party.SongUID("b"), pullPlaying(t, p, ouid)[party.KCurrentSongID])
+
+	// so does someone who didn't vote leaving
+	assert.Nil(t, p.SetSkipThreshold(ouid, 0.5, 0))
+	assert.Nil(t, p.VoteSkip(g1, "b"))
+	assert.Equal(t, party.SongUID("b"), pullPlaying(t, p, ouid)[party.KCurrentSongID])
+	assert.Nil(t, p.RemoveUser(g2))
+	assert.Equal(t, party.SongUID("c"), pullPlaying(t, p, ouid)[party.KCurrentSongID])
+
+	restored := replay(t, base, events)
+
+	assert.Equal(t, p.Snapshot().NowPlaying.Song, restored.Snapshot().NowPlaying.Song)
+}
@@ -0,0 +1,353 @@
+package party
+
+// this file has the party's output zones, ie the rooms at a big party
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// consts for zones
+const (
+	maxZones        = 16
+	maxZoneNameSize = 32
+)
+
+// Zone is a named output, like the speakers in one room.
+// Zones are grouped by default, they play whatever the party is playing.
+// Ungrouped zones play their own song and don't take from the queues.
+type Zone struct {
+	name    string
+	muted   bool
+	grouped bool
+
+	// the zone's volume, and its own song when it isn't grouped
+	nowPlaying NowPlaying
+}
+
+// consts for pulling zones
+const (
+	KZoneName    = "Name"
+	KZoneMuted   = "Muted"
+	KZoneGrouped = "Grouped"
+	KZonePlaying = "Playing"
+)
+
+// data for pull. Ungrouped zones include what they're playing.
+func (z *Zone) data(catalog Catalog) map[string]interface{} {
+	data := map[string]interface{}{
+		KZoneName:    z.name,
+		KVolume:      z.nowPlaying.volume,
+		KZoneMuted:   z.muted,
+		KZoneGrouped: z.grouped,
+	}
+
+	if !z.grouped {
+		data[KZonePlaying] = z.nowPlaying.Data(catalog)
+	}
+
+	return data
+}
+
+// zoneData for pull, sorted by name
+func (p *Party) zoneData() []map[string]interface{} {
+	names := p.zoneNames()
+
+	zones := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		zones = append(zones, p.zones[name].data(p.songs))
+	}
+
+	return zones
+}
+
+// zoneNames sorted
+func (p *Party) zoneNames() []string {
+	names := make([]string, 0, len(p.zones))
+	for name := range p.zones {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// getZone by name
+func (p *Party) getZone(name string) (*Zone, error) {
+	zone, has := p.zones[name]
+	if !has {
+		return nil, fmt.Errorf("no zone %s", name)
+	}
+
+	return zone, nil
+}
+
+// getUngroupedZone by name, error if it follows the party
+func (p *Party) getUngroupedZone(name string) (*Zone, error) {
+	zone, err := p.getZone(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if zone.grouped {
+		return nil, fmt.Errorf("zone %s is grouped with the party", name)
+	}
+
+	return zone, nil
+}
+
+// AddZone to the party. It starts grouped at the party's volume.
+// uid of person adding the zone.
+func (p *Party) AddZone(uid UserUUID, name string) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventAddZone, Actor: uid, Zone: name})
+
+	if can, err := p.canUserDo(uid, CapabilityManageZones); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't manage zones")
+	}
+
+	if name == "" || len(name) > maxZoneNameSize {
+		return fmt.Errorf("bad zone name")
+	}
+
+	if _, has := p.zones[name]; has {
+		return fmt.Errorf("zone %s already exists", name)
+	}
+
+	if len(p.zones) >= maxZones {
+		return fmt.Errorf("party already has %d zones", maxZones)
+	}
+
+	zone := &Zone{name: name, grouped: true}
+	zone.nowPlaying.clock = p.now
+	zone.nowPlaying.volume = p.nowPlaying.volume
+
+	p.zones[name] = zone
+	p.setUpdated(PullZonesKey)
+
+	return nil
+}
+
+// RemoveZone from the party.
+// uid of person removing the zone.
+func (p *Party) RemoveZone(uid UserUUID, name string) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventRemoveZone, Actor: uid, Zone: name})
+
+	if can, err := p.canUserDo(uid, CapabilityManageZones); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't manage zones")
+	}
+
+	if _, err = p.getZone(name); err != nil {
+		return err
+	}
+
+	delete(p.zones, name)
+	p.setUpdated(PullZonesKey)
+
+	return nil
+}
+
+// SetMute on a zone.
+// uid of person muting.
+func (p *Party) SetMute(uid UserUUID, name string, muted bool) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSetMute, Actor: uid, Zone: name, Value: muted})
+
+	if can, err := p.canUserPerformAction(uid, UserCanChangeVolumePermission); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't change volume")
+	}
+
+	zone, err := p.getZone(name)
+	if err != nil {
+		return err
+	}
+
+	if zone.muted == muted {
+		return fmt.Errorf("not changing anything")
+	}
+
+	zone.muted = muted
+	p.setUpdated(PullZonesKey)
+
+	return nil
+}
+
+// UngroupZone so it plays on its own.
+// It starts from wherever the party is in the song.
+// uid of person splitting the zone off.
+func (p *Party) UngroupZone(uid UserUUID, name string) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventUngroupZone, Actor: uid, Zone: name})
+
+	if can, err := p.canUserDo(uid, CapabilityManageZones); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't manage zones")
+	}
+
+	zone, err := p.getZone(name)
+	if err != nil {
+		return err
+	}
+
+	if !zone.grouped {
+		return fmt.Errorf("zone %s isn't grouped", name)
+	}
+
+	// take the party's song but keep the zone's volume
+	volume := zone.nowPlaying.volume
+	zone.nowPlaying = p.nowPlaying
+	zone.nowPlaying.volume = volume
+	zone.grouped = false
+
+	p.setUpdated(PullZonesKey)
+
+	return nil
+}
+
+// GroupZone so it follows the party again.
+// uid of person grouping the zone.
+func (p *Party) GroupZone(uid UserUUID, name string) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventGroupZone, Actor: uid, Zone: name})
+
+	if can, err := p.canUserDo(uid, CapabilityManageZones); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't manage zones")
+	}
+
+	zone, err := p.getUngroupedZone(name)
+	if err != nil {
+		return err
+	}
+
+	zone.nowPlaying.SetNonePlaying()
+	zone.grouped = true
+
+	p.setUpdated(PullZonesKey)
+
+	return nil
+}
+
+// ZonePlayNow plays a song right now in an ungrouped zone.
+// The queues aren't touched.
+func (p *Party) ZonePlayNow(uid UserUUID, name string, sid SongUID) error {
+	return p.ZonePlayNowWithDuration(uid, name, sid, 0)
+}
+
+// ZonePlayNowWithDuration is ZonePlayNow for a song the client says is
+// duration long, 0 if it doesn't know.
+func (p *Party) ZonePlayNowWithDuration(uid UserUUID, name string, sid SongUID, duration time.Duration) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventPlayNow, Actor: uid, Zone: name, Song: sid, DurationMs: durationMs(duration)})
+
+	if can, err := p.canUserPerformAction(uid, UserCanPlaySongNextPermission); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't play-next")
+	}
+
+	zone, err := p.getUngroupedZone(name)
+	if err != nil {
+		return err
+	}
+
+	if err := p.learnSong(sid); err != nil {
+		return err
+	}
+
+	p.offerSongDuration(uid, sid, duration)
+	zone.nowPlaying.ChangeSong(sid)
+	zone.nowPlaying.SetDuration(p.songDuration(sid))
+	p.setUpdated(PullZonesKey)
+
+	return nil
+}
+
+// ZoneSeek in an ungrouped zone's song.
+func (p *Party) ZoneSeek(uid UserUUID, name string, position float32) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventSeek, Actor: uid, Zone: name, Position: position})
+
+	if can, err := p.canUserPerformAction(uid, UserCanSeekPermission); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can not seek")
+	}
+
+	zone, err := p.getUngroupedZone(name)
+	if err != nil {
+		return err
+	}
+
+	zone.nowPlaying.Seek(position)
+	p.setUpdated(PullZonesKey)
+
+	return nil
+}
+
+// ZonePause an ungrouped zone's song.
+func (p *Party) ZonePause(uid UserUUID, name string, pos float32) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventPause, Actor: uid, Zone: name, Position: pos})
+
+	if can, err := p.canUserPerformAction(uid, UserCanPlayPausePermission); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't play/pause")
+	}
+
+	zone, err := p.getUngroupedZone(name)
+	if err != nil {
+		return err
+	}
+
+	if err := zone.nowPlaying.SetPaused(pos); err != nil {
+		return err
+	}
+
+	p.setUpdated(PullZonesKey)
+	return nil
+}
+
+// ZonePlay an ungrouped zone's song.
+func (p *Party) ZonePlay(uid UserUUID, name string) (err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.record(&err, p.changeID, Event{Type: EventPlay, Actor: uid, Zone: name})
+
+	if can, err := p.canUserPerformAction(uid, UserCanPlayPausePermission); err != nil {
+		return err
+	} else if !can {
+		return fmt.Errorf("user can't play/pause")
+	}
+
+	zone, err := p.getUngroupedZone(name)
+	if err != nil {
+		return err
+	}
+
+	if err := zone.nowPlaying.SetPlaying(); err != nil {
+		return err
+	}
+
+	p.setUpdated(PullZonesKey)
+	return nil
+}
@@ -0,0 +1,122 @@
+package party_test
+
+import (
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// pullZones from a full pull
+func pullZones(t *testing.T, p *party.Party, uid party.UserUUID) []map[string]interface{} {
+	raw, err := p.Pull(uid, 0)
+	assert.Nil(t, err)
+
+	return raw.(map[string]interface{})[party.PullZonesKey].([]map[string]interface{})
+}
+
+func TestZones(t *testing.T) {
+	ouid := party.UserUUID("1")
+	guid := party.UserUUID("2")
+
+	p := party.New(ouid, "bob")
+	assert.Nil(t, p.AddUser(guid, "gary"))
+	assert.Nil(t, p.SetVolume(ouid, "", 50))
+
+	// only hosts manage zones
+	assert.NotNil(t, p.AddZone(guid, "patio"))
+	assert.NotNil(t, p.AddZone(ouid, ""))
+	assert.Nil(t, p.AddZone(ouid, "patio"))
+	assert.Nil(t, p.AddZone(ouid, "kitchen"))
+	assert.NotNil(t, p.AddZone(ouid, "patio"))
+
+	// zones start at the party's volume, and change on their own
+	assert.Nil(t, p.SetVolume(guid, "patio", 80))
+	assert.NotNil(t, p.SetVolume(guid, "attic", 80))
+	assert.NotNil(t, p.SetVolume(guid, "patio", 101))
+	assert.Nil(t, p.SetMute(guid, "kitchen", true))
+	assert.NotNil(t, p.SetMute(guid, "kitchen", true))
+
+	zones := pullZones(t, p, ouid)
+	assert.Len(t, zones, 2)
+	assert.Equal(t, "kitchen", zones[0][party.KZoneName])
+	assert.EqualValues(t, 50, zones[0][party.KVolume])
+	assert.Equal(t, true, zones[0][party.KZoneMuted])
+	assert.Equal(t, "patio", zones[1][party.KZoneName])
+	assert.EqualValues(t, 80, zones[1][party.KVolume])
+	assert.Equal(t, true, zones[1][party.KZoneGrouped])
+
+	assert.Nil(t, p.RemoveZone(ouid, "kitchen"))
+	assert.NotNil(t, p.RemoveZone(ouid, "kitchen"))
+	assert.Len(t, pullZones(t, p, ouid), 1)
+}
+
+func TestZoneGrouping(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+
+	assert.Nil(t, p.PlayNext(ouid, "a"))
+	assert.Nil(t, p.PlayNext(ouid, "b"))
+	assert.Nil(t, p.AddZone(ouid, "patio"))
+
+	// grouped zones follow the party
+	assert.NotNil(t, p.ZonePlayNow(ouid, "patio", "z"))
+	assert.NotNil(t, p.GroupZone(ouid, "patio"))
+
+	// ungrouped zones start with the party's song then go their own way
+	assert.Nil(t, p.UngroupZone(ouid, "patio"))
+	assert.NotNil(t, p.UngroupZone(ouid, "patio"))
+
+	zone := pullZones(t, p, ouid)[0]
+	assert.Equal(t, false, zone[party.KZoneGrouped])
+	playing := zone[party.KZonePlaying].(map[string]interface{})
+	assert.Equal(t, party.SongUID("a"), playing[party.KCurrentSongID])
+
+	assert.Nil(t, p.ZonePlayNow(ouid, "patio", "z"))
+	assert.Nil(t, p.ZoneSeek(ouid, "patio", 30))
+	assert.Nil(t, p.ZonePause(ouid, "patio", 31))
+	assert.Nil(t, p.ZonePlay(ouid, "patio"))
+
+	// the party and its queues don't notice
+	assert.Nil(t, p.Skip(ouid, "a"))
+	actual, err := getCurrentlyPlaying(p, ouid)
+	assert.Nil(t, err)
+	assert.Equal(t, party.SongUID("b"), actual)
+
+	playing = pullZones(t, p, ouid)[0][party.KZonePlaying].(map[string]interface{})
+	assert.Equal(t, party.SongUID("z"), playing[party.KCurrentSongID])
+
+	// zones survive snapshots
+	restored := party.Restore(p.Snapshot())
+	playing = pullZones(t, restored, ouid)[0][party.KZonePlaying].(map[string]interface{})
+	assert.Equal(t, party.SongUID("z"), playing[party.KCurrentSongID])
+
+	assert.Nil(t, p.GroupZone(ouid, "patio"))
+	assert.NotContains(t, pullZones(t, p, ouid)[0], party.KZonePlaying)
+}
+
+func TestZoneReplay(t *testing.T) {
+	ouid := party.UserUUID("1")
+	p := party.New(ouid, "bob")
+	var events []party.Event
+	base := captureEvents(p, &events)
+
+	assert.Nil(t, p.PlayNext(ouid, "a"))
+	assert.Nil(t, p.AddZone(ouid, "patio"))
+	assert.Nil(t, p.SetVolume(ouid, "patio", 30))
+	assert.Nil(t, p.SetMute(ouid, "patio", true))
+	assert.Nil(t, p.UngroupZone(ouid, "patio"))
+	assert.Nil(t, p.ZonePlayNow(ouid, "patio", "z"))
+	assert.Nil(t, p.ZonePause(ouid, "patio", 12))
+
+	restored := replay(t, base, events)
+
+	// start times are a little off on replay, like the party's
+	expected, actual := p.Snapshot().Zones, restored.Snapshot().Zones
+	assert.Len(t, actual, 1)
+	assert.Equal(t, expected[0].Name, actual[0].Name)
+	assert.Equal(t, expected[0].Muted, actual[0].Muted)
+	assert.Equal(t, expected[0].Grouped, actual[0].Grouped)
+	assert.Equal(t, expected[0].NowPlaying.Song, actual[0].NowPlaying.Song)
+	assert.Equal(t, expected[0].NowPlaying.SongPos, actual[0].NowPlaying.SongPos)
+	assert.Equal(t, expected[0].NowPlaying.Volume, actual[0].NowPlaying.Volume)
+}
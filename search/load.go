@@ -0,0 +1,106 @@
+package search
+
+// this file loads an index from a file of tracks
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LoadFile indexes the tracks in a json or csv file.
+// json is an array of party.SongInfo. csv needs a header row naming the
+// columns, the same as the json fields. id and title are required.
+func LoadFile(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var songs []party.SongInfo
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.NewDecoder(f).Decode(&songs)
+	case ".csv":
+		songs, err = readCSV(f)
+	default:
+		return nil, fmt.Errorf("tracks need to be json or csv: %s", path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("bad tracks %s: %s", path, err.Error())
+	}
+
+	for i, info := range songs {
+		if info.ID == "" {
+			return nil, fmt.Errorf("bad tracks %s: track %d has no id", path, i+1)
+		}
+	}
+
+	return New(songs), nil
+}
+
+// readCSV tracks
+func readCSV(r io.Reader) ([]party.SongInfo, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("no header: %s", err.Error())
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, required := range []string{"id", "title"} {
+		if _, has := columns[required]; !has {
+			return nil, fmt.Errorf("no %s column", required)
+		}
+	}
+
+	// empty if the row doesn't have the column
+	get := func(row []string, name string) string {
+		if i, has := columns[strings.ToLower(name)]; has && i < len(row) {
+			return row[i]
+		}
+
+		return ""
+	}
+
+	var songs []party.SongInfo
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return songs, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		info := party.SongInfo{
+			ID:         party.SongUID(get(row, "id")),
+			Title:      get(row, "title"),
+			Artist:     get(row, "artist"),
+			Album:      get(row, "album"),
+			ArtworkURL: get(row, "artworkUrl"),
+			Source:     get(row, "source"),
+		}
+
+		if duration := get(row, "durationMs"); duration != "" {
+			if info.DurationMs, err = strconv.ParseInt(duration, 10, 64); err != nil {
+				return nil, fmt.Errorf("bad durationMs for %s", info.ID)
+			}
+		}
+
+		songs = append(songs, info)
+	}
+}
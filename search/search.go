@@ -0,0 +1,250 @@
+// Package search finds songs by title, artist and album.
+// An Index is also a party.Catalog, so the songs guests find are the
+// songs parties know about.
+package search
+
+import (
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// consts for search
+const (
+	// how a query token matched an indexed one
+	exactWeight  = 4
+	prefixWeight = 3
+	fuzzyWeight  = 2
+
+	// prefixes shorter than this match too much
+	minPrefixSize = 2
+)
+
+// fields of a song that are searched, with how much a match in each counts
+const (
+	fieldTitle = iota
+	fieldArtist
+	fieldAlbum
+)
+
+// maps can't be const in go
+var (
+	fieldWeights = []int{
+		fieldTitle:  3,
+		fieldArtist: 2,
+		fieldAlbum:  1,
+	}
+)
+
+// posting is a song with a token in a field
+type posting struct {
+	song  int
+	field int
+}
+
+// Index of songs for searching.
+// Safe to search while songs are replaced.
+type Index struct {
+	songs    []party.SongInfo
+	byID     map[party.SongUID]int
+	postings map[string][]posting
+
+	// every indexed token, as runes for fuzzy matching
+	vocab map[string][]rune
+
+	mux *sync.RWMutex
+}
+
+// Results of a search
+type Results struct {
+	// how many songs matched, across every page
+	Total int `json:"total"`
+
+	Songs []party.SongInfo `json:"songs"`
+}
+
+// New index of songs
+func New(songs []party.SongInfo) *Index {
+	idx := &Index{mux: &sync.RWMutex{}}
+	idx.Replace(songs)
+
+	return idx
+}
+
+// Replace every song in the index
+func (idx *Index) Replace(songs []party.SongInfo) {
+	byID := make(map[party.SongUID]int, len(songs))
+	postings := make(map[string][]posting)
+	vocab := make(map[string][]rune)
+
+	kept := make([]party.SongInfo, 0, len(songs))
+	for _, info := range songs {
+		// later songs replace earlier ones with the same id
+		if i, has := byID[info.ID]; has {
+			kept[i] = info
+			continue
+		}
+
+		byID[info.ID] = len(kept)
+		kept = append(kept, info)
+	}
+
+	for i, info := range kept {
+		fields := []string{
+			fieldTitle:  info.Title,
+			fieldArtist: info.Artist,
+			fieldAlbum:  info.Album,
+		}
+
+		for field, text := range fields {
+			seen := make(map[string]struct{})
+			for _, token := range tokenize(text) {
+				if _, has := seen[token]; has {
+					continue
+				}
+
+				seen[token] = struct{}{}
+				postings[token] = append(postings[token], posting{song: i, field: field})
+				vocab[token] = []rune(token)
+			}
+		}
+	}
+
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	idx.songs = kept
+	idx.byID = byID
+	idx.postings = postings
+	idx.vocab = vocab
+}
+
+// Len is how many songs are indexed
+func (idx *Index) Len() int {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	return len(idx.songs)
+}
+
+// Songs in the index, in the order they were added
+func (idx *Index) Songs() []party.SongInfo {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	songs := make([]party.SongInfo, len(idx.songs))
+	copy(songs, idx.songs)
+
+	return songs
+}
+
+// Lookup a song by id, so an index can be a party's catalog
+func (idx *Index) Lookup(sid party.SongUID) (party.SongInfo, error) {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	i, has := idx.byID[sid]
+	if !has {
+		return party.SongInfo{}, fmt.Errorf("song %s not in index", sid)
+	}
+
+	return idx.songs[i], nil
+}
+
+// Search for songs matching every word in query.
+// Words match exactly, as a prefix, or with a typo or two if they're long
+// enough. Best matches come first, then they're sorted by title.
+// Returns up to limit songs starting at offset.
+func (idx *Index) Search(query string, offset int, limit int) (Results, error) {
+	tokens := tokenize(query)
+	if len(tokens) == 0 {
+		return Results{}, fmt.Errorf("nothing to search for")
+	}
+
+	if offset < 0 || limit <= 0 {
+		return Results{}, fmt.Errorf("bad page")
+	}
+
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	// songs have to match every token
+	var scores map[int]int
+	for _, token := range tokens {
+		matches := idx.match(token)
+
+		if scores == nil {
+			scores = matches
+			continue
+		}
+
+		for song, score := range scores {
+			if add, has := matches[song]; has {
+				scores[song] = score + add
+			} else {
+				delete(scores, song)
+			}
+		}
+	}
+
+	found := make([]int, 0, len(scores))
+	for song := range scores {
+		found = append(found, song)
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+
+		ta, tb := strings.ToLower(idx.songs[a].Title), strings.ToLower(idx.songs[b].Title)
+		if ta != tb {
+			return ta < tb
+		}
+
+		return idx.songs[a].ID < idx.songs[b].ID
+	})
+
+	results := Results{
+		Total: len(found),
+		Songs: []party.SongInfo{},
+	}
+
+	for i := offset; i < len(found) && i < offset+limit; i++ {
+		results.Songs = append(results.Songs, idx.songs[found[i]])
+	}
+
+	return results, nil
+}
+
+// match a query token against the index.
+// Returns each song's best score for the token.
+func (idx *Index) match(token string) map[int]int {
+	query := []rune(token)
+	edits := maxEdits(query)
+
+	scores := make(map[int]int)
+	add := func(indexed string, weight int) {
+		for _, p := range idx.postings[indexed] {
+			if score := weight * fieldWeights[p.field]; score > scores[p.song] {
+				scores[p.song] = score
+			}
+		}
+	}
+
+	for indexed, runes := range idx.vocab {
+		switch {
+		case indexed == token:
+			add(indexed, exactWeight)
+		case len(query) >= minPrefixSize && strings.HasPrefix(indexed, token):
+			add(indexed, prefixWeight)
+		case edits > 0 && withinEdits(query, runes, edits):
+			add(indexed, fuzzyWeight)
+		}
+	}
+
+	return scores
+}
@@ -0,0 +1,156 @@
+package search_test
+
+import (
+	"github.com/me-next/menext-backend/party"
+	"github.com/me-next/menext-backend/search"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var songs = []party.SongInfo{
+	{ID: "1", Title: "Africa", Artist: "Toto", Album: "Toto IV"},
+	{ID: "2", Title: "Rosanna", Artist: "Toto", Album: "Toto IV"},
+	{ID: "3", Title: "Don't Stop Believin'", Artist: "Journey", Album: "Escape"},
+	{ID: "4", Title: "Separate Ways", Artist: "Journey", Album: "Frontiers"},
+	{ID: "5", Title: "Toto", Artist: "Someone Else", Album: "Covers"},
+}
+
+// ids of the songs found
+func ids(results search.Results) []party.SongUID {
+	found := make([]party.SongUID, len(results.Songs))
+	for i, info := range results.Songs {
+		found[i] = info.ID
+	}
+
+	return found
+}
+
+func TestSearch(t *testing.T) {
+	idx := search.New(songs)
+	assert.Equal(t, 5, idx.Len())
+
+	results, err := idx.Search("africa", 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []party.SongUID{"1"}, ids(results))
+
+	// titles count more than artists, then it's by title
+	results, err = idx.Search("TOTO", 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []party.SongUID{"5", "1", "2"}, ids(results))
+
+	// every word has to match
+	results, err = idx.Search("journey ways", 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []party.SongUID{"4"}, ids(results))
+
+	// prefixes
+	results, err = idx.Search("dont sto", 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []party.SongUID{"3"}, ids(results))
+
+	// typos
+	results, err = idx.Search("rosana", 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []party.SongUID{"2"}, ids(results))
+
+	results, err = idx.Search("jurney seperate", 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []party.SongUID{"4"}, ids(results))
+
+	// short words have to be right
+	results, err = idx.Search("tot", 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, results.Songs, 3)
+
+	results, err = idx.Search("iv", 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []party.SongUID{"1", "2"}, ids(results))
+
+	results, err = idx.Search("xyzzy", 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, results.Total)
+	assert.Empty(t, results.Songs)
+
+	_, err = idx.Search("  !! ", 0, 10)
+	assert.NotNil(t, err)
+}
+
+func TestSearchPages(t *testing.T) {
+	idx := search.New(songs)
+
+	results, err := idx.Search("toto", 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, results.Total)
+	assert.Equal(t, []party.SongUID{"1"}, ids(results))
+
+	results, err = idx.Search("toto", 3, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, results.Total)
+	assert.Empty(t, results.Songs)
+
+	_, err = idx.Search("toto", -1, 1)
+	assert.NotNil(t, err)
+	_, err = idx.Search("toto", 0, 0)
+	assert.NotNil(t, err)
+}
+
+func TestIndexCatalog(t *testing.T) {
+	idx := search.New(songs)
+
+	info, err := idx.Lookup("1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Africa", info.Title)
+
+	idx.Replace(songs[2:])
+	_, err = idx.Lookup("1")
+	assert.NotNil(t, err)
+
+	results, err := idx.Search("africa", 0, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, results.Songs)
+}
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "search")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	csvPath := filepath.Join(dir, "tracks.csv")
+	raw := "id,title,artist,durationMs\n1,Africa,Toto,295000\n2,\"Hold the Line\",Toto,\n"
+	assert.Nil(t, ioutil.WriteFile(csvPath, []byte(raw), 0600))
+
+	idx, err := search.LoadFile(csvPath)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, idx.Len())
+
+	info, err := idx.Lookup("1")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 295000, info.DurationMs)
+
+	results, err := idx.Search("hold line", 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []party.SongUID{"2"}, ids(results))
+
+	jsonPath := filepath.Join(dir, "tracks.json")
+	raw = `[{"id": "1", "title": "Africa", "artist": "Toto"}]`
+	assert.Nil(t, ioutil.WriteFile(jsonPath, []byte(raw), 0600))
+
+	idx, err = search.LoadFile(jsonPath)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, idx.Len())
+
+	// bad files
+	assert.Nil(t, ioutil.WriteFile(csvPath, []byte("title\nAfrica\n"), 0600))
+	_, err = search.LoadFile(csvPath)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(jsonPath, []byte(`[{"title": "Africa"}]`), 0600))
+	_, err = search.LoadFile(jsonPath)
+	assert.NotNil(t, err)
+
+	_, err = search.LoadFile(filepath.Join(dir, "tracks.txt"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,98 @@
+package search
+
+// this file splits text into tokens and matches them loosely
+
+import (
+	"strings"
+	"unicode"
+)
+
+// tokenize lowercases text and splits it into words.
+// Apostrophes are dropped so "don't" matches "dont".
+func tokenize(text string) []string {
+	text = strings.Map(func(r rune) rune {
+		if r == '\'' || r == '’' {
+			return -1
+		}
+
+		return unicode.ToLower(r)
+	}, text)
+
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// maxEdits allowed for a query token to still match.
+// Short words have to be exact or they match everything.
+func maxEdits(token []rune) int {
+	switch {
+	case len(token) < 4:
+		return 0
+	case len(token) < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// withinEdits checks if the Levenshtein distance between a and b is at most limit
+func withinEdits(a []rune, b []rune, limit int) bool {
+	if abs(len(a)-len(b)) > limit {
+		return false
+	}
+
+	// one row of the edit distance table at a time
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+
+		// every path through this row is already too far
+		if rowMin > limit {
+			return false
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)] <= limit
+}
+
+// abs of an int
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+// minInt of ints
+func minInt(first int, rest ...int) int {
+	m := first
+	for _, n := range rest {
+		if n < m {
+			m = n
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,239 @@
+package server
+
+// contains the API for syncing client clocks to the server's, so devices
+// in a party can play in step
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/me-next/menext-backend/party"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// consts for clock sync
+const (
+	// samples kept per client, the best of them is the estimate
+	clockSamples = 8
+
+	// clients that stop syncing are forgotten after this
+	clockStateTTL = 10 * time.Minute
+)
+
+// clockClient is a user syncing from a party
+type clockClient struct {
+	pid PartyUUID
+	uid party.UserUUID
+}
+
+// clockSample is one round trip.
+// offset is how far the server's clock is ahead of the client's.
+type clockSample struct {
+	offset float64
+	rtt    float64
+}
+
+// clockState of a client.
+// The last exchange is kept until the client says when the reply arrived.
+type clockState struct {
+	// ms, t0 on the client's clock and t1, t2 on the server's
+	t0, t1, t2 float64
+
+	samples  []clockSample
+	lastSeen time.Time
+}
+
+// clockSync estimates each client's clock offset NTP style.
+// A client sends its time t0, the server replies with when the request
+// arrived t1 and when the reply left t2, and the client notes when the
+// reply arrived t3. t3 comes with the next request, which completes a sample.
+type clockSync struct {
+	clients   map[clockClient]*clockState
+	lastPrune time.Time
+	mux       *sync.Mutex
+
+	// the server's clock, tests replace it
+	clock func() time.Time
+}
+
+// clockEstimate from a client's samples, in ms
+type clockEstimate struct {
+	OffsetMs float64
+	RttMs    float64
+	Samples  int
+}
+
+func newClockSync() *clockSync {
+	return &clockSync{
+		clients: make(map[clockClient]*clockState),
+		mux:     &sync.Mutex{},
+		clock:   time.Now,
+	}
+}
+
+// now in ms with sub ms precision
+func (cs *clockSync) now() float64 {
+	return float64(cs.clock().UnixNano()) / float64(time.Millisecond)
+}
+
+// exchange the client's times for the server's.
+// t3 is when the last reply arrived, 0 if there wasn't one.
+// Returns t1 and the estimate so far, t2 is taken as late as possible.
+func (cs *clockSync) exchange(client clockClient, t0 float64, t3 float64) (float64, clockEstimate) {
+	t1 := cs.now()
+
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+
+	cs.prune()
+
+	state, has := cs.clients[client]
+	if !has {
+		state = &clockState{}
+		cs.clients[client] = state
+	}
+
+	// t3 finishes the last exchange
+	if t3 != 0 && state.t2 != 0 {
+		sample := clockSample{
+			offset: ((state.t1 - state.t0) + (state.t2 - t3)) / 2,
+			rtt:    (t3 - state.t0) - (state.t2 - state.t1),
+		}
+
+		// negative round trips are from bad times
+		if sample.rtt >= 0 {
+			state.samples = append(state.samples, sample)
+			if len(state.samples) > clockSamples {
+				state.samples = state.samples[1:]
+			}
+		}
+	}
+
+	state.t0 = t0
+	state.t1 = t1
+	state.t2 = 0
+	state.lastSeen = cs.clock()
+
+	return t1, state.estimate()
+}
+
+// sent the reply at t2
+func (cs *clockSync) sent(client clockClient, t2 float64) {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+
+	if state, has := cs.clients[client]; has {
+		state.t2 = t2
+	}
+}
+
+// estimate the offset from the sample with the shortest round trip.
+// Queueing only ever makes a trip longer and skews it, so the fastest
+// trip is the most accurate.
+func (state *clockState) estimate() clockEstimate {
+	est := clockEstimate{Samples: len(state.samples)}
+	for i, sample := range state.samples {
+		if i == 0 || sample.rtt < est.RttMs {
+			est.OffsetMs = sample.offset
+			est.RttMs = sample.rtt
+		}
+	}
+
+	return est
+}
+
+// prune clients that stopped syncing, at most once per clockStateTTL
+func (cs *clockSync) prune() {
+	now := cs.clock()
+	if now.Sub(cs.lastPrune) < clockStateTTL {
+		return
+	}
+
+	cs.lastPrune = now
+	for client, state := range cs.clients {
+		if now.Sub(state.lastSeen) > clockStateTTL {
+			delete(cs.clients, client)
+		}
+	}
+}
+
+// Clock is one round of syncing a client's clock with the server's.
+// Path is /clock/{pid}/{uid}?t0={ms}&t3={ms}
+// t0 is the client's time when sending, t3 is the client's time when the
+// last reply arrived. Times are ms and can have fractions.
+// Returns {t0, t1, t2} with t1 and t2 on the server's clock, plus the
+// estimate of the offset and round trip from the samples so far.
+// Server time is client time + offsetMs. Pulls give times on the
+// server's clock, so clients need the offset to play in step.
+func (s *Server) Clock(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+
+	if !ufound || !pfound {
+		urlerror(w)
+		return
+	}
+
+	parseTime := func(key string) (float64, error) {
+		raw := r.URL.Query().Get(key)
+		if raw == "" {
+			return 0, nil
+		}
+
+		t, err := strconv.ParseFloat(raw, 64)
+		if err != nil || t <= 0 {
+			return 0, fmt.Errorf("bad %s", key)
+		}
+
+		return t, nil
+	}
+
+	t0, err := parseTime("t0")
+	if err == nil && t0 == 0 {
+		err = fmt.Errorf("missing t0")
+	}
+
+	var t3 float64
+	if err == nil {
+		t3, err = parseTime("t3")
+	}
+
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	client := clockClient{pid: PartyUUID(pidStr), uid: party.UserUUID(uidStr)}
+	t1, est := s.clocks.exchange(client, t0, t3)
+
+	data := map[string]interface{}{
+		"t0":       t0,
+		"t1":       t1,
+		"offsetMs": est.OffsetMs,
+		"rttMs":    est.RttMs,
+		"samples":  est.Samples,
+	}
+
+	// t2 as close to the reply leaving as we can get
+	t2 := s.clocks.now()
+	data["t2"] = t2
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		errMsg := jsonError("failed to serialize data")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	s.clocks.sent(client, t2)
+	w.Write(raw)
+}
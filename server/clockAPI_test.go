@@ -0,0 +1,99 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"github.com/me-next/menext-backend/server"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClockSync(t *testing.T) {
+	s := newTestServer()
+	ouid := party.UserUUID("1")
+
+	pid, err := s.createParty(ouid, "bob")
+	assert.Nil(t, err)
+
+	// this client's clock is 5 seconds behind the server's
+	clientNow := func() float64 {
+		return float64(time.Now().Add(-5*time.Second).UnixNano()) / float64(time.Millisecond)
+	}
+
+	resp := s.getAuthedResponse(fmt.Sprintf("/clock/%s/%s", pid, ouid), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	resp = s.getAuthedResponse(fmt.Sprintf("/clock/%s/%s?t0=soon", pid, ouid), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	var t3 float64
+	var data map[string]float64
+	for i := 0; i < 4; i++ {
+		url := fmt.Sprintf("/clock/%s/%s?t0=%f", pid, ouid, clientNow())
+		if t3 != 0 {
+			url += fmt.Sprintf("&t3=%f", t3)
+		}
+
+		resp = s.getAuthedResponse(url, ouid)
+		t3 = clientNow()
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		data = make(map[string]float64)
+		assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &data))
+		assert.True(t, data["t1"] <= data["t2"])
+	}
+
+	// every round after the first adds a sample. Real round trips are
+	// timed here, so only check the estimate is in the right ballpark
+	assert.EqualValues(t, 3, data["samples"])
+	assert.InDelta(t, 5000, data["offsetMs"], 1000)
+	assert.True(t, data["rttMs"] >= 0)
+}
+
+func TestClockSyncEstimate(t *testing.T) {
+	base := time.Unix(1000, 0)
+	now := base
+	cs := server.NewFakeClockSync(func() time.Time {
+		return now
+	})
+
+	// ms on the server's clock, the client's is 5 seconds behind
+	serverMs := func(ms int) float64 {
+		return float64(base.Add(time.Duration(ms)*time.Millisecond).UnixNano()) / float64(time.Millisecond)
+	}
+	clientMs := func(ms int) float64 {
+		return serverMs(ms) - 5000
+	}
+
+	// 3ms each way, 1ms on the server
+	now = base.Add(3 * time.Millisecond)
+	t1, est := cs.Exchange("p", "1", clientMs(0), 0)
+	assert.Equal(t, serverMs(3), t1)
+	assert.Equal(t, 0, est.Samples)
+	cs.Sent("p", "1", serverMs(4))
+
+	// a slow trip out skews the offset
+	now = base.Add(30 * time.Millisecond)
+	_, est = cs.Exchange("p", "1", clientMs(10), clientMs(7))
+	assert.Equal(t, 1, est.Samples)
+	assert.InDelta(t, 5000, est.OffsetMs, 0.001)
+	assert.InDelta(t, 6, est.RttMs, 0.001)
+	cs.Sent("p", "1", serverMs(31))
+
+	// so the fastest trip is still the estimate
+	now = base.Add(40 * time.Millisecond)
+	_, est = cs.Exchange("p", "1", clientMs(40), clientMs(34))
+	assert.Equal(t, 2, est.Samples)
+	assert.InDelta(t, 5000, est.OffsetMs, 0.001)
+	assert.InDelta(t, 6, est.RttMs, 0.001)
+	cs.Sent("p", "1", serverMs(41))
+
+	// clients that go quiet are forgotten
+	now = base.Add(20 * time.Minute)
+	cs.Exchange("p", "2", clientMs(0), 0)
+	_, est = cs.Exchange("p", "1", clientMs(20*60*1000), clientMs(44))
+	assert.Equal(t, 0, est.Samples)
+}
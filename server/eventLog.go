@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// extensions for the log files in the data directory
+const (
+	eventLogExt = ".log"
+	auditLogExt = ".audit"
+)
+
+// EventLog is an append-only log of party events on local disk.
+// Each party gets a write-ahead log of events since its last snapshot.
+// Compacting the log moves the events covered by a snapshot to the
+// party's audit log, so the full history of the party is kept.
+type EventLog struct {
+	dir string
+
+	// open write-ahead logs
+	files map[PartyUUID]*os.File
+	mux   *sync.Mutex
+}
+
+// NewEventLog in dir, creating the directory if needed.
+func NewEventLog(dir string) (*EventLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data dir: %s", err.Error())
+	}
+
+	return &EventLog{
+		dir:   dir,
+		files: make(map[PartyUUID]*os.File),
+		mux:   &sync.Mutex{},
+	}, nil
+}
+
+// Append an event to a party's log.
+// The event is synced to disk before Append returns. If the write fails
+// the log is truncated back so a partial event doesn't hide later ones.
+func (el *EventLog) Append(pid PartyUUID, e party.Event) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	el.mux.Lock()
+	defer el.mux.Unlock()
+
+	file, err := el.file(pid)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(append(raw, '\n')); err == nil {
+		err = file.Sync()
+	}
+
+	if err != nil {
+		// reopen next time in case the file is what's broken
+		file.Truncate(info.Size())
+		el.close(pid)
+	}
+
+	return err
+}
+
+// Events in a party's write-ahead log, in the order they were appended.
+func (el *EventLog) Events(pid PartyUUID) ([]party.Event, error) {
+	el.mux.Lock()
+	defer el.mux.Unlock()
+
+	events, _, err := readEvents(el.path(pid, eventLogExt))
+	return events, err
+}
+
+// Compact drops all events up to and including seq from a party's
+// write-ahead log. Call this after saving a snapshot that covers seq.
+// The dropped events are appended to the party's audit log.
+func (el *EventLog) Compact(pid PartyUUID, seq uint64) error {
+	el.mux.Lock()
+	defer el.mux.Unlock()
+
+	events, clean, err := readEvents(el.path(pid, eventLogExt))
+	if err != nil {
+		return err
+	}
+
+	var compacted, kept []party.Event
+	for _, e := range events {
+		if e.Seq <= seq {
+			compacted = append(compacted, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+
+	// nothing to do unless the log needs to be cleaned up
+	if len(compacted) == 0 && clean {
+		return nil
+	}
+
+	if len(compacted) > 0 {
+		if err = appendEvents(el.path(pid, auditLogExt), compacted); err != nil {
+			return err
+		}
+	}
+
+	// close the log, write the events we kept to a new log and swap it in
+	el.close(pid)
+
+	tmp := el.path(pid, eventLogExt) + ".tmp"
+	os.Remove(tmp)
+	if err = appendEvents(tmp, kept); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, el.path(pid, eventLogExt))
+}
+
+// Remove a party's write-ahead and audit logs.
+func (el *EventLog) Remove(pid PartyUUID) error {
+	el.mux.Lock()
+	defer el.mux.Unlock()
+
+	el.close(pid)
+
+	for _, ext := range []string{eventLogExt, auditLogExt} {
+		if err := os.Remove(el.path(pid, ext)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// file for appending to a party's log, opening it if needed.
+// el.mux must be held.
+func (el *EventLog) file(pid PartyUUID) (*os.File, error) {
+	if file, found := el.files[pid]; found {
+		return file, nil
+	}
+
+	file, err := os.OpenFile(el.path(pid, eventLogExt), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	el.files[pid] = file
+	return file, nil
+}
+
+// close a party's log if it's open. el.mux must be held.
+func (el *EventLog) close(pid PartyUUID) {
+	if file, found := el.files[pid]; found {
+		file.Close()
+		delete(el.files, pid)
+	}
+}
+
+// path to one of a party's logs
+func (el *EventLog) path(pid PartyUUID, ext string) string {
+	return filepath.Join(el.dir, string(pid)+ext)
+}
+
+// readEvents from a log file, no events if the file doesn't exist.
+// A partially written last line (ie from a crash) is ignored,
+// clean is false if that happened.
+func readEvents(path string) (events []party.Event, clean bool, err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e party.Event
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return events, false, nil
+		}
+
+		events = append(events, e)
+	}
+
+	return events, true, scanner.Err()
+}
+
+// appendEvents to a log file, creating it if needed
+func appendEvents(path string, events []party.Event) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, e := range events {
+		raw, err := json.Marshal(e)
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		writer.Write(append(raw, '\n'))
+	}
+
+	if err = writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,91 @@
+package server_test
+
+import (
+	"github.com/me-next/menext-backend/party"
+	"github.com/me-next/menext-backend/server"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEventLogCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "menext")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	el, err := server.NewEventLog(dir)
+	assert.Nil(t, err)
+
+	// no log yet
+	events, err := el.Events("a")
+	assert.Nil(t, err)
+	assert.Empty(t, events)
+
+	for seq := uint64(1); seq <= 5; seq++ {
+		assert.Nil(t, el.Append("a", party.Event{Seq: seq, Type: party.EventSuggest, Song: "x"}))
+	}
+
+	events, err = el.Events("a")
+	assert.Nil(t, err)
+	assert.Len(t, events, 5)
+
+	// drop what a snapshot covers
+	assert.Nil(t, el.Compact("a", 3))
+
+	events, err = el.Events("a")
+	assert.Nil(t, err)
+	assert.Len(t, events, 2)
+	assert.EqualValues(t, 4, events[0].Seq)
+
+	// can keep appending after a compaction
+	assert.Nil(t, el.Append("a", party.Event{Seq: 6, Type: party.EventSkip}))
+
+	events, err = el.Events("a")
+	assert.Nil(t, err)
+	assert.Len(t, events, 3)
+	assert.Equal(t, party.EventSkip, events[2].Type)
+
+	// compacted events are kept in the audit log
+	audit, err := ioutil.ReadFile(filepath.Join(dir, "a.audit"))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, audit)
+
+	assert.Nil(t, el.Remove("a"))
+	events, err = el.Events("a")
+	assert.Nil(t, err)
+	assert.Empty(t, events)
+}
+
+func TestEventLogPartialWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "menext")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	el, err := server.NewEventLog(dir)
+	assert.Nil(t, err)
+
+	assert.Nil(t, el.Append("a", party.Event{Seq: 1, Type: party.EventSuggest}))
+
+	// simulate a crash part way through a write
+	file, err := os.OpenFile(filepath.Join(dir, "a.log"), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	file.Write([]byte(`{"seq":2,"ty`))
+	file.Close()
+
+	el, err = server.NewEventLog(dir)
+	assert.Nil(t, err)
+
+	events, err := el.Events("a")
+	assert.Nil(t, err)
+	assert.Len(t, events, 1)
+
+	// compacting cleans up the bad write so new events can be read
+	assert.Nil(t, el.Compact("a", 0))
+	assert.Nil(t, el.Append("a", party.Event{Seq: 2, Type: party.EventSkip}))
+
+	events, err = el.Events("a")
+	assert.Nil(t, err)
+	assert.Len(t, events, 2)
+}
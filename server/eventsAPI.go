@@ -0,0 +1,205 @@
+package server
+
+// contains the server-sent events API for streaming party changes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/me-next/menext-backend/party"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// how often a comment is sent to keep proxies from closing the stream
+const eventsKeepAlivePeriod = 30 * time.Second
+
+// EventPartyEnded is sent as the last event when the party ends
+const EventPartyEnded = "partyEnded"
+
+// maps can't be const in go
+var (
+	// the pull sections sent with each kind of event
+	eventSections = map[string][]string{
+		party.ChangeSong:  {party.PullPlayingKey, party.PullHistoryKey},
+		party.ChangeQueue: {party.PullSuggestKey, party.PullPlayNextKey, party.PullRadioKey},
+		party.ChangeVote:  {party.PullSuggestKey},
+		party.ChangePermissions: {
+			party.PullPermissionKey,
+			party.PullMyPermissionsKey,
+			party.PullUserPermissionsKey,
+			party.PullMyRoleKey,
+			party.PullRolesKey,
+			party.PullBansKey,
+			party.PullJoinKey,
+			party.PullSkipThresholdKey,
+		},
+		party.ChangeZones: {party.PullZonesKey},
+		party.ChangeVeto:  {party.PullSuggestKey, party.PullVetoedKey},
+	}
+
+	// event order when everything changed
+	allChangeKinds = []string{
+		party.ChangeSong,
+		party.ChangeQueue,
+		party.ChangePermissions,
+		party.ChangeZones,
+		party.ChangeVeto,
+	}
+)
+
+// Events streams typed party changes as server-sent events.
+// Path is /events/{pid}/{uid}.
+// Each event's id is the party change id, and its data holds the pull
+// sections for that kind of change. Reconnecting with Last-Event-ID only
+// sends what changed since then. The stream closes when the user leaves,
+// and a partyEnded event is sent when the party ends.
+func (s *Server) Events(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+
+	if !ufound || !pfound {
+		urlerror(w)
+		return
+	}
+
+	pid := PartyUUID(pidStr)
+	uid := party.UserUUID(uidStr)
+
+	p, err := s.pm.Party(pid)
+	if err != nil {
+		errMsg := jsonError("no such party %s", pid)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// resume from the last event the client saw
+	var cid uint64
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		cid, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			errMsg := jsonError("failed to parse Last-Event-ID")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errMsg)
+
+			return
+		}
+	}
+
+	if !p.HasUser(uid) {
+		errMsg := jsonError("user %s not in the party", uid)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errMsg := jsonError("streaming not supported")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// reuse the push subscriptions so leaving closes the stream
+	sub := s.push.subscribe(pid, uid)
+	defer s.push.unsubscribe(sub)
+
+	ticker := time.NewTicker(eventsKeepAlivePeriod)
+	defer ticker.Stop()
+
+	for {
+		// wait on the channel from before the pull so nothing is missed
+		changed := p.Changed()
+
+		data, err := p.Pull(uid, cid)
+		if err != nil {
+			writeEvent(w, "error", cid, map[string]string{"error": err.Error()})
+			flusher.Flush()
+			return
+		}
+
+		if data != nil {
+			// checked after the pull so the kinds cover everything pulled
+			kinds, ok := p.ChangesSince(cid)
+			if !ok {
+				kinds = allChangeKinds
+			}
+
+			cid = pulledChangeID(data)
+			pulled := data.(map[string]interface{})
+
+			for _, kind := range kinds {
+				if err = writeEvent(w, kind, cid, eventData(pulled, kind)); err != nil {
+					return
+				}
+			}
+
+			flusher.Flush()
+		}
+
+		select {
+		case <-changed:
+			if p.Ended() {
+				writeEvent(w, EventPartyEnded, cid, map[string]interface{}{})
+				flusher.Flush()
+				return
+			}
+
+		case <-sub.done:
+			return
+
+		case <-r.Context().Done():
+			return
+
+		case <-ticker.C:
+			if _, err = fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+
+			flusher.Flush()
+
+			// an open stream counts as the client being around
+			p.Heartbeat(uid)
+		}
+	}
+}
+
+// eventData picks the pull sections that go with a kind of event
+func eventData(pulled map[string]interface{}, kind string) map[string]interface{} {
+	data := map[string]interface{}{
+		party.PullChangeKey: pulled[party.PullChangeKey],
+	}
+
+	for _, section := range eventSections[kind] {
+		if value, has := pulled[section]; has {
+			data[section] = value
+		}
+	}
+
+	return data
+}
+
+// writeEvent in the text/event-stream format
+func writeEvent(w http.ResponseWriter, event string, id uint64, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, raw)
+	return err
+}
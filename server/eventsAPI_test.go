@@ -0,0 +1,152 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"github.com/me-next/menext-backend/server"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent is a parsed server-sent event
+type sseEvent struct {
+	id    uint64
+	event string
+	data  map[string]interface{}
+}
+
+// opens an event stream and parses events into a channel.
+// The token goes in the query like an EventSource would send it.
+func openEvents(t *testing.T, hs *httptest.Server, pid server.PartyUUID, uid party.UserUUID, token, lastID string) (*http.Response, chan sseEvent) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/events/%s/%s?token=%s", hs.URL, pid, uid, token), nil)
+	assert.Nil(t, err)
+
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+
+	events := make(chan sseEvent, 100)
+	go func() {
+		defer close(events)
+
+		reader := bufio.NewReader(resp.Body)
+		var current sseEvent
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "":
+				if current.event != "" {
+					events <- current
+				}
+				current = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				current.id, _ = strconv.ParseUint(line[4:], 10, 64)
+			case strings.HasPrefix(line, "event: "):
+				current.event = line[7:]
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(line[6:]), &current.data)
+			}
+		}
+	}()
+
+	return resp, events
+}
+
+// waits for the next event
+func nextEvent(t *testing.T, events chan sseEvent) sseEvent {
+	select {
+	case e, ok := <-events:
+		assert.True(t, ok, "stream closed")
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+
+	return sseEvent{}
+}
+
+func TestEventStream(t *testing.T) {
+	ts := newTestServer()
+	hs := httptest.NewServer(ts.s.GetAPI())
+	defer hs.Close()
+
+	ouid := party.UserUUID("1")
+	pid, err := ts.createParty(ouid, "bob")
+	assert.Nil(t, err)
+
+	// no session
+	resp, _ := openEvents(t, hs, pid, "nobody", "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+
+	// bad session
+	resp, _ = openEvents(t, hs, pid, ouid, "bad."+ts.tokens[ouid], "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, events := openEvents(t, hs, pid, ouid, ts.tokens[ouid], "")
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// new clients get everything with the first change
+	assert.Nil(t, ts.suggestSong(pid, ouid, "a"))
+
+	var e sseEvent
+	for _, expected := range []string{party.ChangeSong, party.ChangeQueue, party.ChangePermissions, party.ChangeZones, party.ChangeVeto} {
+		e = nextEvent(t, events)
+		assert.Equal(t, expected, e.event)
+		assert.EqualValues(t, 1, e.id)
+	}
+
+	assert.Nil(t, ts.suggestSong(pid, ouid, "b"))
+	e = nextEvent(t, events)
+	assert.Equal(t, party.ChangeQueue, e.event)
+	assert.EqualValues(t, 2, e.id)
+	assert.Contains(t, e.data, party.PullSuggestKey)
+
+	assert.Nil(t, ts.suggestDownvote(pid, ouid, "b"))
+	e = nextEvent(t, events)
+	assert.Equal(t, party.ChangeVote, e.event)
+	assert.EqualValues(t, 3, e.id)
+
+	resp2 := ts.getAuthedResponse(fmt.Sprintf("/setPermission/%s/%s/%s/false", pid, ouid, party.UserCanSeekPermission), ouid)
+	assert.Equal(t, http.StatusOK, resp2.Code)
+	e = nextEvent(t, events)
+	assert.Equal(t, party.ChangePermissions, e.event)
+	assert.Contains(t, e.data, party.PullPermissionKey)
+	assert.NotContains(t, e.data, party.PullSuggestKey)
+
+	// reconnecting only gets what was missed
+	resumed, resumedEvents := openEvents(t, hs, pid, ouid, ts.tokens[ouid], "3")
+	defer resumed.Body.Close()
+
+	e = nextEvent(t, resumedEvents)
+	assert.Equal(t, party.ChangePermissions, e.event)
+	assert.EqualValues(t, 4, e.id)
+
+	// ending the party ends the streams
+	resp2 = ts.getAuthedResponse(fmt.Sprintf("/removeParty/%s/%s", ouid, pid), ouid)
+	assert.Equal(t, http.StatusOK, resp2.Code)
+
+	e = nextEvent(t, events)
+	assert.Equal(t, server.EventPartyEnded, e.event)
+
+	e = nextEvent(t, resumedEvents)
+	assert.Equal(t, server.EventPartyEnded, e.event)
+}
@@ -0,0 +1,34 @@
+package server
+
+// exposes internals to the server_test package
+
+import (
+	"github.com/me-next/menext-backend/party"
+	"time"
+)
+
+// ClockEstimate is clockEstimate for tests
+type ClockEstimate = clockEstimate
+
+// FakeClockSync is clock syncing on a clock the test controls
+type FakeClockSync struct {
+	cs *clockSync
+}
+
+// NewFakeClockSync on clock
+func NewFakeClockSync(clock func() time.Time) FakeClockSync {
+	cs := newClockSync()
+	cs.clock = clock
+
+	return FakeClockSync{cs: cs}
+}
+
+// Exchange is clockSync.exchange for uid in pid
+func (f FakeClockSync) Exchange(pid PartyUUID, uid party.UserUUID, t0 float64, t3 float64) (float64, ClockEstimate) {
+	return f.cs.exchange(clockClient{pid: pid, uid: uid}, t0, t3)
+}
+
+// Sent is clockSync.sent for uid in pid
+func (f FakeClockSync) Sent(pid PartyUUID, uid party.UserUUID, t2 float64) {
+	f.cs.sent(clockClient{pid: pid, uid: uid}, t2)
+}
@@ -0,0 +1,181 @@
+package server
+
+// this file contains the API for controlling who can join a party
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/me-next/menext-backend/party"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SetPassword users need to join the party.
+// Path is /setPassword/{pid}/{uid}, POSTed with a password form field so the
+// password stays out of urls and logs. /clearPassword/{pid}/{uid} removes it.
+func (s *Server) SetPassword(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+
+	// no password clears it
+	password := r.PostFormValue("password")
+
+	if !ufound || !pfound {
+		urlerror(w)
+		return
+	}
+
+	p, err := s.pm.Party(PartyUUID(pidStr))
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	err = p.SetPassword(party.UserUUID(uidStr), password)
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status code
+}
+
+// SetInviteOnly so users need an invite or the password to join.
+// Path is /setInviteOnly/{pid}/{uid}/{val}
+// val == "true" when trying to set to true, otherwise "false"
+func (s *Server) SetInviteOnly(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	valStr, vfound := vars["val"]
+
+	if !ufound || !pfound || !vfound {
+		urlerror(w)
+		return
+	}
+
+	p, err := s.pm.Party(PartyUUID(pidStr))
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	err = p.SetInviteOnly(party.UserUUID(uidStr), valStr == "true")
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status code
+}
+
+// CreateInvite that can be used {uses} times in the next {minutes}.
+// Returns {"invite": <code>}, pass the code to joinParty with ?invite={code}.
+// Path is /createInvite/{pid}/{uid}/{uses}/{minutes}
+func (s *Server) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	usesStr, usesFound := vars["uses"]
+	minutesStr, mfound := vars["minutes"]
+
+	if !ufound || !pfound || !usesFound || !mfound {
+		urlerror(w)
+		return
+	}
+
+	p, err := s.pm.Party(PartyUUID(pidStr))
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	uses, err := strconv.ParseUint(usesStr, 10, 31)
+	if err != nil {
+		errMsg := jsonError("failed to parse uses")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	minutes, err := strconv.ParseUint(minutesStr, 10, 31)
+	if err != nil {
+		errMsg := jsonError("failed to parse minutes")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	code, err := p.CreateInvite(party.UserUUID(uidStr), int(uses), time.Duration(minutes)*time.Minute)
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	raw, err := json.Marshal(map[string]string{"invite": code})
+	if err != nil {
+		errMsg := jsonError("failed to serialize")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	w.Write(raw)
+}
+
+// RevokeInvite so it can't be used anymore.
+// Path is /revokeInvite/{pid}/{uid}/{code}
+func (s *Server) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	code, cfound := vars["code"]
+
+	if !ufound || !pfound || !cfound {
+		urlerror(w)
+		return
+	}
+
+	p, err := s.pm.Party(PartyUUID(pidStr))
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	err = p.RevokeInvite(party.UserUUID(uidStr), code)
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status code
+}
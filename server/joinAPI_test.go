@@ -0,0 +1,85 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// postPassword to url as a form, authed as uid if they have a token
+func (ts *testServer) postPassword(path string, uid party.UserUUID, password string) *httptest.ResponseRecorder {
+	form := url.Values{"password": {password}}
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token, has := ts.tokens[uid]; has {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	ts.s.GetAPI().ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func TestJoinLockedParty(t *testing.T) {
+	ts := newTestServer()
+
+	ouid := party.UserUUID("1")
+	pid, err := ts.createParty(ouid, "bob")
+	assert.Nil(t, err)
+
+	// passwords aren't taken in the url
+	resp := ts.getAuthedResponse(fmt.Sprintf("/setPassword/%s/%s/hunter2", pid, ouid), ouid)
+	assert.NotEqual(t, http.StatusOK, resp.Code)
+
+	resp = ts.postPassword(fmt.Sprintf("/setPassword/%s/%s", pid, ouid), ouid, "hunter2")
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = ts.getHTTPResponse(fmt.Sprintf("/joinParty/%s/%s/%s", pid, "2", "fred"))
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	resp = ts.getHTTPResponse(fmt.Sprintf("/joinParty/%s/%s/%s?password=hunter2", pid, "2", "fred"))
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	resp = ts.postPassword(fmt.Sprintf("/joinParty/%s/%s/%s", pid, "2", "fred"), "2", "hunter2")
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// invites
+	resp = ts.getAuthedResponse(fmt.Sprintf("/createInvite/%s/%s/1/60", pid, ouid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	data := make(map[string]string)
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &data))
+	invite := data["invite"]
+	assert.NotEqual(t, "", invite)
+
+	resp = ts.getHTTPResponse(fmt.Sprintf("/joinParty/%s/%s/%s?invite=%s", pid, "3", "greg", invite))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = ts.getHTTPResponse(fmt.Sprintf("/joinParty/%s/%s/%s?invite=%s", pid, "4", "hank", invite))
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	// revoke
+	resp = ts.getAuthedResponse(fmt.Sprintf("/createInvite/%s/%s/5/60", pid, ouid), ouid)
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &data))
+	resp = ts.getAuthedResponse(fmt.Sprintf("/revokeInvite/%s/%s/%s", pid, ouid, data["invite"]), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = ts.getHTTPResponse(fmt.Sprintf("/joinParty/%s/%s/%s?invite=%s", pid, "4", "hank", data["invite"]))
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	// open it back up
+	resp = ts.getAuthedResponse(fmt.Sprintf("/clearPassword/%s/%s", pid, ouid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Nil(t, ts.joinEvent(pid, "4", "hank"))
+
+	resp = ts.getAuthedResponse(fmt.Sprintf("/setInviteOnly/%s/%s/true", pid, ouid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotNil(t, ts.joinEvent(pid, "5", "ivan"))
+}
@@ -0,0 +1,119 @@
+package server
+
+// this file contains the API for kicking and banning users
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/me-next/menext-backend/party"
+	"net/http"
+)
+
+// Kick a user out of a party. They can join again.
+// Path is /kick/{pid}/{uid}/{target}
+func (s *Server) Kick(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	targetStr, tfound := vars["target"]
+
+	if !ufound || !pfound || !tfound {
+		urlerror(w)
+		return
+	}
+
+	pid := PartyUUID(pidStr)
+	p, err := s.pm.Party(pid)
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	err = p.Kick(party.UserUUID(uidStr), party.UserUUID(targetStr))
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// stop pushing to the user
+	s.push.closeUser(pid, party.UserUUID(targetStr))
+
+	// exit with OK status code
+}
+
+// Ban a user from a party, removing them and their songs and votes.
+// Path is /ban/{pid}/{uid}/{target}
+func (s *Server) Ban(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	targetStr, tfound := vars["target"]
+
+	if !ufound || !pfound || !tfound {
+		urlerror(w)
+		return
+	}
+
+	pid := PartyUUID(pidStr)
+	p, err := s.pm.Party(pid)
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	err = p.Ban(party.UserUUID(uidStr), party.UserUUID(targetStr))
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// stop pushing to the user
+	s.push.closeUser(pid, party.UserUUID(targetStr))
+
+	// exit with OK status code
+}
+
+// Unban a user so they can join the party again.
+// Path is /unban/{pid}/{uid}/{target}
+func (s *Server) Unban(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	targetStr, tfound := vars["target"]
+
+	if !ufound || !pfound || !tfound {
+		urlerror(w)
+		return
+	}
+
+	p, err := s.pm.Party(PartyUUID(pidStr))
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	err = p.Unban(party.UserUUID(uidStr), party.UserUUID(targetStr))
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status code
+}
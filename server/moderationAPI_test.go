@@ -0,0 +1,40 @@
+package server_test
+
+import (
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestKickBan(t *testing.T) {
+	ts := newTestServer()
+
+	ouid := party.UserUUID("1")
+	fuid := party.UserUUID("2")
+
+	pid, err := ts.createParty(ouid, "bob")
+	assert.Nil(t, err)
+	assert.Nil(t, ts.joinEvent(pid, fuid, "fred"))
+
+	// guests can't kick
+	resp := ts.getAuthedResponse(fmt.Sprintf("/kick/%s/%s/%s", pid, fuid, ouid), fuid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	resp = ts.getAuthedResponse(fmt.Sprintf("/kick/%s/%s/%s", pid, ouid, fuid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// kicked users can't act, but can come back
+	assert.NotNil(t, ts.suggestSong(pid, fuid, "a"))
+	assert.Nil(t, ts.joinEvent(pid, fuid, "fred"))
+	assert.Nil(t, ts.suggestSong(pid, fuid, "a"))
+
+	resp = ts.getAuthedResponse(fmt.Sprintf("/ban/%s/%s/%s", pid, ouid, fuid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotNil(t, ts.joinEvent(pid, fuid, "fred"))
+
+	resp = ts.getAuthedResponse(fmt.Sprintf("/unban/%s/%s/%s", pid, ouid, fuid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Nil(t, ts.joinEvent(pid, fuid, "fred"))
+}
@@ -3,14 +3,33 @@ package server
 // contains the API functions for the playback features
 
 import (
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/me-next/menext-backend/party"
 	"net/http"
 	"strconv"
+	"time"
 )
 
+// songDuration from the optional ?duration={ms} on requests that add songs,
+// so the party knows when the song ends. 0 if it isn't there.
+func songDuration(r *http.Request) (time.Duration, error) {
+	durationStr := r.URL.Query().Get("duration")
+	if durationStr == "" {
+		return 0, nil
+	}
+
+	ms, err := strconv.ParseInt(durationStr, 10, 64)
+	if err != nil || ms <= 0 {
+		return 0, fmt.Errorf("bad duration")
+	}
+
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
 // Seek to a position in the song.
 // The path is /{pid}/{uid}/seek/{pos}.
+// ?zone={name} seeks in an ungrouped zone instead.
 // The client must validate that the seek is correct.
 // The party checks that the seek is valid
 func (s *Server) Seek(w http.ResponseWriter, r *http.Request) {
@@ -45,7 +64,11 @@ func (s *Server) Seek(w http.ResponseWriter, r *http.Request) {
 
 	// try to seek
 	// error could be from invalid user or bad seek
-	err = p.Seek(party.UserUUID(uidStr), float32(seekPosition))
+	if zone := r.URL.Query().Get("zone"); zone != "" {
+		err = p.ZoneSeek(party.UserUUID(uidStr), zone, float32(seekPosition))
+	} else {
+		err = p.Seek(party.UserUUID(uidStr), float32(seekPosition))
+	}
 	if err != nil {
 		errMsg := jsonError("%s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -59,6 +82,7 @@ func (s *Server) Seek(w http.ResponseWriter, r *http.Request) {
 
 // SongFinished notifies the server to play the next song
 // The path is /songFinished/{pid}/{uid}/{sid}
+// sid has to be playing, so late calls for a song that already ended do nothing.
 func (s *Server) SongFinished(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	uidStr, ufound := vars["uid"]
@@ -95,6 +119,7 @@ func (s *Server) SongFinished(w http.ResponseWriter, r *http.Request) {
 
 // Skip the currently playing song.
 // The path is /skip/{pid}/{uid}/{sid}
+// sid has to be playing, so double skips only skip once.
 func (s *Server) Skip(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	uidStr, ufound := vars["uid"]
@@ -168,6 +193,7 @@ func (s *Server) Previous(w http.ResponseWriter, r *http.Request) {
 
 // SetVolume changes the current volume
 // The path is /setVolume/{pid}/{uid}/{volume}
+// ?zone={name} sets a zone's volume instead of the party's.
 func (s *Server) SetVolume(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	uidStr, ufound := vars["uid"]
@@ -201,7 +227,7 @@ func (s *Server) SetVolume(w http.ResponseWriter, r *http.Request) {
 
 	// try to change the volume
 	// err could be bad uid or bad volume
-	err = p.SetVolume(party.UserUUID(uidStr), uint32(volume))
+	err = p.SetVolume(party.UserUUID(uidStr), r.URL.Query().Get("zone"), uint32(volume))
 	if err != nil {
 		errMsg := jsonError("%s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -215,6 +241,7 @@ func (s *Server) SetVolume(w http.ResponseWriter, r *http.Request) {
 
 // Play song if a song is playing. This is just a play/pause control.
 // path is /play/{pid}/{uid}
+// ?zone={name} plays in an ungrouped zone instead.
 func (s *Server) Play(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	uidStr, ufound := vars["uid"]
@@ -236,7 +263,11 @@ func (s *Server) Play(w http.ResponseWriter, r *http.Request) {
 
 	// try to play
 	// error could be from bad user, nothing to play
-	err = p.Play(party.UserUUID(uidStr))
+	if zone := r.URL.Query().Get("zone"); zone != "" {
+		err = p.ZonePlay(party.UserUUID(uidStr), zone)
+	} else {
+		err = p.Play(party.UserUUID(uidStr))
+	}
 	if err != nil {
 		errMsg := jsonError("%s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -250,6 +281,7 @@ func (s *Server) Play(w http.ResponseWriter, r *http.Request) {
 
 // Pause the song at a certain position.
 // path is /pause/{pid}/{uid}/pos
+// ?zone={name} pauses an ungrouped zone instead.
 func (s *Server) Pause(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	uidStr, ufound := vars["uid"]
@@ -282,7 +314,11 @@ func (s *Server) Pause(w http.ResponseWriter, r *http.Request) {
 
 	// try to pause
 	// err could be bad uid or song state not changing
-	err = p.Pause(party.UserUUID(uidStr), float32(pos))
+	if zone := r.URL.Query().Get("zone"); zone != "" {
+		err = p.ZonePause(party.UserUUID(uidStr), zone, float32(pos))
+	} else {
+		err = p.Pause(party.UserUUID(uidStr), float32(pos))
+	}
 	if err != nil {
 		errMsg := jsonError("%s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -295,6 +331,8 @@ func (s *Server) Pause(w http.ResponseWriter, r *http.Request) {
 }
 
 // PlayNow plays a song right now.
+// ?duration={ms} tells the party how long the song is.
+// ?zone={name} plays it in an ungrouped zone without touching the queues.
 func (s *Server) PlayNow(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	uidStr, ufound := vars["uid"]
@@ -315,8 +353,22 @@ func (s *Server) PlayNow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	duration, err := songDuration(r)
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
 	// try to play the song
-	err = p.PlayNow(party.UserUUID(uidStr), party.SongUID(sidStr))
+	if zone := r.URL.Query().Get("zone"); zone != "" {
+		err = p.ZonePlayNowWithDuration(party.UserUUID(uidStr), zone, party.SongUID(sidStr), duration)
+	} else {
+		err = p.PlayNowWithDuration(party.UserUUID(uidStr), party.SongUID(sidStr), duration)
+	}
+
 	if err != nil {
 		errMsg := jsonError("%s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -14,6 +14,9 @@ import (
 // testServer provides helpful testing facilities for the server
 type testServer struct {
 	s *server.Server
+
+	// session tokens from creating and joining
+	tokens map[party.UserUUID]string
 }
 
 func (ts *testServer) getHTTPResponse(url string) *httptest.ResponseRecorder {
@@ -24,6 +27,16 @@ func (ts *testServer) getHTTPResponse(url string) *httptest.ResponseRecorder {
 	return recorder
 }
 
+// fires a request with the user's session token
+func (ts *testServer) getAuthedResponse(url string, uid party.UserUUID) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Authorization", "Bearer "+ts.tokens[uid])
+	ts.s.GetAPI().ServeHTTP(recorder, req)
+
+	return recorder
+}
+
 func (ts *testServer) createParty(ouid party.UserUUID, oname string) (server.PartyUUID, error) {
 
 	resp := ts.getHTTPResponse(fmt.Sprintf("/createParty/%s/%s",
@@ -43,13 +56,15 @@ func (ts *testServer) createParty(ouid party.UserUUID, oname string) (server.Par
 		return "", err
 	}
 
+	ts.tokens[ouid] = data["token"]
+
 	return server.PartyUUID(pid), nil
 }
 
 func (ts *testServer) pull(ouid party.UserUUID,
 	pid server.PartyUUID, cid uint64) (map[string]interface{}, error) {
-	resp := ts.getHTTPResponse(
-		fmt.Sprintf("/pull/%s/%s/%d", ouid, pid, cid))
+	resp := ts.getAuthedResponse(
+		fmt.Sprintf("/pull/%s/%s/%d", ouid, pid, cid), ouid)
 
 	// check response
 	if resp.Code != http.StatusOK {
@@ -74,8 +89,8 @@ func (ts *testServer) pull(ouid party.UserUUID,
 func (ts *testServer) seek(ouid party.UserUUID,
 	pid server.PartyUUID, pos uint32) error {
 
-	resp := ts.getHTTPResponse(
-		fmt.Sprintf("/seek/%s/%s/%d", pid, ouid, pos))
+	resp := ts.getAuthedResponse(
+		fmt.Sprintf("/seek/%s/%s/%d", pid, ouid, pos), ouid)
 
 	// check response
 	if resp.Code != http.StatusOK {
@@ -87,7 +102,8 @@ func (ts *testServer) seek(ouid party.UserUUID,
 
 func newTestServer() *testServer {
 	return &testServer{
-		s: server.New(),
+		s:      server.New(),
+		tokens: make(map[party.UserUUID]string),
 	}
 }
 
@@ -141,3 +157,29 @@ func TestSeek(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Empty(t, data)
 }
+
+func TestPlayNowDuration(t *testing.T) {
+	s := newTestServer()
+	ouid := party.UserUUID("1")
+
+	pid, err := s.createParty(ouid, "bob")
+	assert.Nil(t, err)
+
+	// bad durations don't add the song
+	resp := s.getAuthedResponse(fmt.Sprintf("/playNow/%s/%s/a?duration=soon", pid, ouid), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	resp = s.getAuthedResponse(fmt.Sprintf("/playNow/%s/%s/a?duration=180000", pid, ouid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	data, err := s.pull(ouid, pid, 0)
+	assert.Nil(t, err)
+
+	playing := data[party.PullPlayingKey].(map[string]interface{})
+	assert.Equal(t, "a", playing[party.KCurrentSongID])
+	assert.EqualValues(t, 180000, playing[party.KDurationMs])
+
+	// a stale skip from before a started does nothing
+	resp = s.getAuthedResponse(fmt.Sprintf("/skip/%s/%s/b", pid, ouid), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+}
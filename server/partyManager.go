@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"github.com/me-next/menext-backend/party"
+	"log"
 	"math/rand"
 	"sync"
 	"time"
@@ -15,13 +16,24 @@ type PartyUUID string
 type PartyManager struct {
 	parties map[PartyUUID]*party.Party
 	mux     *sync.RWMutex
+
+	// nil if parties aren't persisted
+	store  *FileStore
+	events *EventLog
+
+	// how long an owner can be silent before someone else takes over
+	ownerTimeout time.Duration
+
+	// where parties look up songs, nil for no metadata
+	catalog party.Catalog
 }
 
 // NewPartyManager from nothing.
 func NewPartyManager() *PartyManager {
 	pm := &PartyManager{
-		parties: make(map[PartyUUID]*party.Party),
-		mux:     &sync.RWMutex{},
+		parties:      make(map[PartyUUID]*party.Party),
+		mux:          &sync.RWMutex{},
+		ownerTimeout: ownerTimeoutMinutes * time.Minute,
 	}
 
 	// spin up the cleanup thread in the background
@@ -32,9 +44,87 @@ func NewPartyManager() *PartyManager {
 		}
 	}(pm)
 
+	// spin up the failover thread in the background
+	go func(pm *PartyManager) {
+		ticker := time.NewTicker(failoverPeriodSeconds * time.Second)
+		for _ = range ticker.C {
+			pm.Failover()
+		}
+	}(pm)
+
+	// spin up the playback clock in the background
+	go func(pm *PartyManager) {
+		ticker := time.NewTicker(advancePeriodSeconds * time.Second)
+		for _ = range ticker.C {
+			pm.Advance()
+		}
+	}(pm)
+
 	return pm
 }
 
+// NewPersistentPartyManager restores all of the parties saved in the store.
+// Each party is rebuilt from its snapshot plus the events logged since,
+// looking songs up in catalog like it did when the events happened.
+// Every change to a party is logged, and the logs are periodically
+// compacted into new snapshots.
+func NewPersistentPartyManager(store *FileStore, events *EventLog, catalog party.Catalog) (*PartyManager, error) {
+	snaps, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	pm := NewPartyManager()
+	pm.store = store
+	pm.events = events
+	pm.catalog = catalog
+
+	for pid, snap := range snaps {
+		p := party.Restore(snap)
+		p.SetCatalog(catalog)
+
+		logged, err := events.Events(pid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read events for %s: %s", pid, err.Error())
+		}
+
+		// replay everything after the snapshot. Events are logged or
+		// covered by a snapshot before the call that made them returns,
+		// so a gap means the log is damaged and compacting would lose events.
+		next := snap.EventSeq + 1
+		for _, e := range logged {
+			if e.Seq < next {
+				continue
+			} else if e.Seq > next {
+				return nil, fmt.Errorf("events for %s skip from %d to %d", pid, next, e.Seq)
+			}
+
+			// a party that ends up somewhere else would hand clients
+			// change ids that mean something different, so don't start
+			next++
+			if err = p.Apply(e); err != nil {
+				return nil, fmt.Errorf("failed to replay events for %s: %s", pid, err.Error())
+			}
+		}
+
+		pm.parties[pid] = p
+		pm.logEvents(pid, p)
+	}
+
+	// start everyone from a fresh snapshot
+	pm.Compact()
+
+	// spin up the compaction thread in the background
+	go func(pm *PartyManager) {
+		ticker := time.NewTicker(compactPeriodMinutes * time.Minute)
+		for _ = range ticker.C {
+			pm.Compact()
+		}
+	}(pm)
+
+	return pm, nil
+}
+
 // CreateParty with a unique identifier
 // TODO: should this have a check to see if the owner is in another party?
 func (pm *PartyManager) CreateParty(owner party.UserUUID, ownerName string) (PartyUUID, error) {
@@ -46,6 +136,8 @@ func (pm *PartyManager) CreateParty(owner party.UserUUID, ownerName string) (Par
 	pm.mux.Lock()
 	defer pm.mux.Unlock()
 
+	p.SetCatalog(pm.catalog)
+
 	// uuid
 	pid := pm.generateUUID()
 
@@ -54,6 +146,8 @@ func (pm *PartyManager) CreateParty(owner party.UserUUID, ownerName string) (Par
 	}
 
 	pm.parties[pid] = p
+	pm.logEvents(pid, p)
+	pm.save(pid, p)
 
 	return pid, nil
 }
@@ -73,10 +167,13 @@ func (pm *PartyManager) CreatePartyWithName(ouid party.UserUUID, oname string, p
 		pm.mux.Lock()
 
 		p := party.New(ouid, oname)
+		p.SetCatalog(pm.catalog)
 
 		// double check that our desired name is still available
 		if _, found = pm.parties[PartyUUID(pid)]; !found {
 			pm.parties[PartyUUID(pid)] = p
+			pm.logEvents(PartyUUID(pid), p)
+			pm.save(PartyUUID(pid), p)
 			pm.mux.Unlock()
 
 			return PartyUUID(pid), "", nil
@@ -149,16 +246,168 @@ func (pm *PartyManager) Remove(pid PartyUUID) error {
 	}
 
 	// NOTE: disbanding a party is the same as it not existing
+	p := pm.parties[pid]
 	delete(pm.parties, pid)
+
+	// wake anyone waiting on the party
+	p.End()
+
+	if pm.store != nil {
+		// stop logging first so nothing in flight recreates the log
+		p.SetEventHandler(nil, nil)
+
+		if err := pm.store.Remove(pid); err != nil {
+			log.Printf("failed to remove snapshot for %s: %s", pid, err.Error())
+		}
+
+		if err := pm.events.Remove(pid); err != nil {
+			log.Printf("failed to remove events for %s: %s", pid, err.Error())
+		}
+	}
+
 	return nil
 }
 
+// Compact saves a snapshot of every party to the store and drops the
+// events the snapshot covers from the party's log.
+// Does nothing if the manager isn't persistent.
+func (pm *PartyManager) Compact() {
+	if pm.store == nil {
+		return
+	}
+
+	// grab the keys so other people can get the lock between saves
+	pm.mux.RLock()
+	pids := make([]PartyUUID, 0, len(pm.parties))
+	for pid := range pm.parties {
+		pids = append(pids, pid)
+	}
+	pm.mux.RUnlock()
+
+	for _, pid := range pids {
+		// hold the read lock while saving so a concurrent Remove can't
+		// delete the files before we write them back
+		pm.mux.RLock()
+		if p, found := pm.parties[pid]; found {
+			pm.save(pid, p)
+		}
+		pm.mux.RUnlock()
+	}
+}
+
+// save a snapshot of a single party then compact its log, logging failures.
+// A failed save isn't fatal, the events are still in the log.
+func (pm *PartyManager) save(pid PartyUUID, p *party.Party) {
+	if pm.store == nil {
+		return
+	}
+
+	snap := p.Snapshot()
+	if err := pm.store.Save(pid, snap); err != nil {
+		log.Printf("failed to save party %s: %s", pid, err.Error())
+		return
+	}
+
+	if err := pm.events.Compact(pid, snap.EventSeq); err != nil {
+		log.Printf("failed to compact events for %s: %s", pid, err.Error())
+	}
+}
+
+// logEvents from the party to the event log. If an event can't be logged
+// the party is snapshotted instead, calls fail if that doesn't work either.
+func (pm *PartyManager) logEvents(pid PartyUUID, p *party.Party) {
+	if pm.events == nil {
+		return
+	}
+
+	p.SetEventHandler(func(e party.Event) error {
+		err := pm.events.Append(pid, e)
+		if err != nil {
+			log.Printf("failed to log event for %s: %s", pid, err.Error())
+		}
+
+		return err
+	}, func(snap party.Snapshot) error {
+		if err := pm.store.Save(pid, snap); err != nil {
+			log.Printf("failed to save party %s: %s", pid, err.Error())
+			return err
+		}
+
+		// the log may have a partial event, and everything in it is covered
+		if err := pm.events.Compact(pid, snap.EventSeq); err != nil {
+			log.Printf("failed to compact events for %s: %s", pid, err.Error())
+		}
+
+		return nil
+	})
+}
+
+// SetOwnerTimeout sets how long an owner can go without pulling or sending a
+// heartbeat before ownership fails over. 0 turns failover off.
+func (pm *PartyManager) SetOwnerTimeout(timeout time.Duration) {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+
+	pm.ownerTimeout = timeout
+}
+
+// SetCatalog for every party to look songs up in, including the ones
+// already running. nil turns song metadata off.
+func (pm *PartyManager) SetCatalog(catalog party.Catalog) {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+
+	pm.catalog = catalog
+	for _, p := range pm.parties {
+		p.SetCatalog(catalog)
+	}
+}
+
+// Failover hands each party whose owner went quiet to the next in line.
+// It is called by a background thread every <failoverPeriodSeconds>.
+func (pm *PartyManager) Failover() {
+	pm.mux.RLock()
+	defer pm.mux.RUnlock()
+
+	for pid, p := range pm.parties {
+		if owner, changed := p.FailoverOwner(pm.ownerTimeout); changed {
+			log.Printf("party %s: owner went quiet, %s took over", pid, owner)
+		}
+	}
+}
+
+// Advance each party whose song is over to the next song, then let the
+// radio fill in for parties that ran out of songs.
+// It is called by a background thread every <advancePeriodSeconds>.
+func (pm *PartyManager) Advance() {
+	pm.mux.RLock()
+	defer pm.mux.RUnlock()
+
+	for _, p := range pm.parties {
+		// most parties are still playing their song, or have the radio off
+		p.Advance()
+		p.FillRadio()
+	}
+}
+
+// how often parties check if their song is over or the radio should play
+const advancePeriodSeconds = 1
+
+// consts for owner failover
+const (
+	ownerTimeoutMinutes   = 5
+	failoverPeriodSeconds = 15
+)
+
 // consts for party cleanup
 const (
 	cleanupPeriodHours  = 6
 	partyExpirationTime = 48
 )
 
+// how often party event logs are compacted into snapshots
+const compactPeriodMinutes = 10
+
 // Cleanup removes all events older than expirationTime.
 // It is called by a background thread every <cleanupPeriodHours>.
 func (pm *PartyManager) Cleanup(expirationTime time.Duration) {
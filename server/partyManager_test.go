@@ -1,6 +1,7 @@
 package server_test
 
 import (
+	"github.com/me-next/menext-backend/party"
 	"github.com/me-next/menext-backend/server"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -121,3 +122,88 @@ func TestCustomNames(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.NotEqual(t, server.PartyUUID(""), alts)
 }
+
+func TestCleanupWakesWaiters(t *testing.T) {
+	pm := server.NewPartyManager()
+
+	pid, err := pm.CreateParty("1", "a")
+	assert.Nil(t, err)
+
+	p, err := pm.Party(pid)
+	assert.Nil(t, err)
+
+	woke := make(chan bool)
+	go func() {
+		woke <- p.WaitForChange(0, 10*time.Second)
+	}()
+
+	// everything is expired
+	time.Sleep(10 * time.Millisecond)
+	pm.Cleanup(0)
+
+	select {
+	case changed := <-woke:
+		assert.False(t, changed)
+		assert.True(t, p.Ended())
+	case <-time.After(time.Second):
+		t.Error("cleanup didn't wake the waiter")
+	}
+}
+
+func TestManagerFailover(t *testing.T) {
+	pm := server.NewPartyManager()
+
+	pid, err := pm.CreateParty("1", "ted")
+	assert.Nil(t, err)
+
+	p, err := pm.Party(pid)
+	assert.Nil(t, err)
+	assert.Nil(t, p.AddUser("2", "bob"))
+
+	// owner is still around
+	pm.Failover()
+	assert.True(t, p.CanUserEndParty("1"))
+
+	// turned off
+	pm.SetOwnerTimeout(0)
+	time.Sleep(10 * time.Millisecond)
+	pm.Failover()
+	assert.True(t, p.CanUserEndParty("1"))
+
+	pm.SetOwnerTimeout(5 * time.Millisecond)
+	assert.Nil(t, p.Heartbeat("2"))
+	pm.Failover()
+	assert.True(t, p.CanUserEndParty("2"))
+}
+
+func TestManagerCatalog(t *testing.T) {
+	pm := server.NewPartyManager()
+
+	before, err := pm.CreateParty("1", "ted")
+	assert.Nil(t, err)
+
+	p, err := pm.Party(before)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Suggest("1", "a"))
+
+	// running parties pick up the catalog
+	pm.SetCatalog(party.NewMemoryCatalog(party.SongInfo{ID: "a", Title: "Africa"}))
+
+	raw, err := p.Pull("1", 0)
+	assert.Nil(t, err)
+	playing := raw.(map[string]interface{})[party.PullPlayingKey].(map[string]interface{})
+	assert.Equal(t, "Africa", playing[party.KCurrentSongInfo].(party.SongInfo).Title)
+
+	// and so do new ones
+	after, err := pm.CreateParty("2", "bob")
+	assert.Nil(t, err)
+
+	p, err = pm.Party(after)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Suggest("2", "a"))
+
+	raw, err = p.Pull("2", 0)
+	assert.Nil(t, err)
+	playing = raw.(map[string]interface{})[party.PullPlayingKey].(map[string]interface{})
+	assert.Equal(t, "Africa", playing[party.KCurrentSongInfo].(party.SongInfo).Title)
+}
@@ -0,0 +1,241 @@
+package server
+
+// contains the websocket API for pushing party changes to clients
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/me-next/menext-backend/party"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// consts for websocket keepalive
+const (
+	// time allowed to write a message
+	pushWriteWait = 10 * time.Second
+
+	// time allowed between pongs from the client
+	pushPongWait = 60 * time.Second
+
+	// must be less than pushPongWait
+	pushPingPeriod = (pushPongWait * 9) / 10
+
+	// clients only send control messages
+	pushMaxMessageSize = 512
+)
+
+// clients are apps, so don't check the origin
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// pushSubscription is a single client connection.
+// done is closed when the client should be disconnected.
+type pushSubscription struct {
+	pid  PartyUUID
+	uid  party.UserUUID
+	done chan struct{}
+}
+
+// pushHub tracks push connections so they can be closed when users leave.
+type pushHub struct {
+	subs map[PartyUUID]map[party.UserUUID]map[*pushSubscription]struct{}
+	mux  *sync.Mutex
+}
+
+func newPushHub() *pushHub {
+	return &pushHub{
+		subs: make(map[PartyUUID]map[party.UserUUID]map[*pushSubscription]struct{}),
+		mux:  &sync.Mutex{},
+	}
+}
+
+// subscribe a user's connection
+func (h *pushHub) subscribe(pid PartyUUID, uid party.UserUUID) *pushSubscription {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	sub := &pushSubscription{
+		pid:  pid,
+		uid:  uid,
+		done: make(chan struct{}),
+	}
+
+	users, found := h.subs[pid]
+	if !found {
+		users = make(map[party.UserUUID]map[*pushSubscription]struct{})
+		h.subs[pid] = users
+	}
+
+	if _, found = users[uid]; !found {
+		users[uid] = make(map[*pushSubscription]struct{})
+	}
+
+	users[uid][sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe a connection once it's closed
+func (h *pushHub) unsubscribe(sub *pushSubscription) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	users := h.subs[sub.pid]
+	delete(users[sub.uid], sub)
+
+	if len(users[sub.uid]) == 0 {
+		delete(users, sub.uid)
+	}
+
+	if len(users) == 0 {
+		delete(h.subs, sub.pid)
+	}
+}
+
+// closeUser disconnects all of a user's connections to a party
+func (h *pushHub) closeUser(pid PartyUUID, uid party.UserUUID) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	for sub := range h.subs[pid][uid] {
+		close(sub.done)
+	}
+
+	delete(h.subs[pid], uid)
+}
+
+// Push opens a websocket that sends the same data as pull whenever the party changes.
+// Path is /push/{pid}/{uid}/{cid}, where cid is the last change the client has.
+// The socket is closed when the user leaves or the party ends.
+func (s *Server) Push(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	cidStr, cfound := vars["cid"]
+
+	if !ufound || !pfound || !cfound {
+		urlerror(w)
+		return
+	}
+
+	pid := PartyUUID(pidStr)
+	uid := party.UserUUID(uidStr)
+
+	p, err := s.pm.Party(pid)
+	if err != nil {
+		errMsg := jsonError("no such party %s", pid)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// base 10, want a u64
+	cid, err := strconv.ParseUint(cidStr, 10, 64)
+	if err != nil {
+		errMsg := jsonError("failed to parse changeID")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// check before upgrading so the client gets a normal error
+	if !p.HasUser(uid) {
+		errMsg := jsonError("user %s not in the party", uid)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// upgrade writes its own error
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub := s.push.subscribe(pid, uid)
+	defer s.push.unsubscribe(sub)
+
+	// the reader handles pongs and notices when the client goes away
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+
+		conn.SetReadLimit(pushMaxMessageSize)
+		conn.SetReadDeadline(time.Now().Add(pushPongWait))
+		conn.SetPongHandler(func(string) error {
+			conn.SetReadDeadline(time.Now().Add(pushPongWait))
+
+			// an open socket counts as the client being around
+			p.Heartbeat(uid)
+			return nil
+		})
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pushPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		// wait on the channel from before the pull so nothing is missed
+		changed := p.Changed()
+
+		data, err := p.Pull(uid, cid)
+		if err != nil {
+			pushClose(conn, websocket.CloseInternalServerErr, err.Error())
+			return
+		}
+
+		if data != nil {
+			conn.SetWriteDeadline(time.Now().Add(pushWriteWait))
+			if err = conn.WriteJSON(data); err != nil {
+				return
+			}
+
+			cid = pulledChangeID(data)
+		}
+
+		select {
+		case <-changed:
+			if p.Ended() {
+				pushClose(conn, websocket.CloseNormalClosure, "party ended")
+				return
+			}
+
+		case <-sub.done:
+			pushClose(conn, websocket.CloseNormalClosure, "left party")
+			return
+
+		case <-readDone:
+			return
+
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(pushWriteWait))
+			if err = conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// pushClose tells the client why the socket is closing
+func pushClose(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(pushWriteWait))
+}
+
+// pulledChangeID gets the change id out of pull data
+func pulledChangeID(data interface{}) uint64 {
+	return data.(map[string]interface{})[party.PullChangeKey].(uint64)
+}
@@ -0,0 +1,92 @@
+package server_test
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/me-next/menext-backend/party"
+	"github.com/me-next/menext-backend/server"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dials the push socket for a user with their session token
+func dialPush(hs *httptest.Server, pid server.PartyUUID, uid party.UserUUID, token string, cid uint64) (*websocket.Conn, *http.Response, error) {
+	url := strings.Replace(hs.URL, "http", "ws", 1)
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+
+	return websocket.DefaultDialer.Dial(fmt.Sprintf("%s/push/%s/%s/%d", url, pid, uid, cid), header)
+}
+
+// reads the next pushed message, fails on timeout
+func readPush(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	data := make(map[string]interface{})
+	assert.Nil(t, conn.ReadJSON(&data))
+	return data
+}
+
+func TestPushChanges(t *testing.T) {
+	ts := newTestServer()
+	hs := httptest.NewServer(ts.s.GetAPI())
+	defer hs.Close()
+
+	ouid := party.UserUUID("1")
+	fuid := party.UserUUID("2")
+
+	pid, err := ts.createParty(ouid, "bob")
+	assert.Nil(t, err)
+	assert.Nil(t, ts.joinEvent(pid, fuid, "fred"))
+
+	// users need a session
+	_, resp, err := dialPush(hs, pid, "nobody", "", 0)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// and can't use someone else's
+	_, resp, err = dialPush(hs, pid, ouid, ts.tokens[fuid], 0)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	conn, _, err := dialPush(hs, pid, fuid, ts.tokens[fuid], 0)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// changes get pushed
+	assert.Nil(t, ts.suggestSong(pid, ouid, "a"))
+	data := readPush(t, conn)
+	assert.EqualValues(t, 1, data[party.PullChangeKey])
+
+	assert.Nil(t, ts.suggestSong(pid, ouid, "b"))
+	data = readPush(t, conn)
+	assert.EqualValues(t, 2, data[party.PullChangeKey])
+
+	// a second socket that's behind gets caught up right away
+	other, _, err := dialPush(hs, pid, ouid, ts.tokens[ouid], 1)
+	assert.Nil(t, err)
+	defer other.Close()
+
+	data = readPush(t, other)
+	assert.EqualValues(t, 2, data[party.PullChangeKey])
+
+	// leaving closes the user's socket
+	resp2 := ts.getAuthedResponse(fmt.Sprintf("/leaveParty/%s/%s", pid, fuid), fuid)
+	assert.Equal(t, http.StatusOK, resp2.Code)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+
+	// removing the party closes everyone else
+	resp2 = ts.getAuthedResponse(fmt.Sprintf("/removeParty/%s/%s", ouid, pid), ouid)
+	assert.Equal(t, http.StatusOK, resp2.Code)
+
+	other.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = other.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+}
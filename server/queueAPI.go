@@ -3,14 +3,21 @@ package server
 // this file contains the API for running queue operations
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/me-next/menext-backend/party"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Suggest a song to a party's suggestion queue.
 // Path is /suggest/{pid}/{uid}/{sid}
-// The client must verify that the song id is good.
+// Songs are checked against the server's library if it has one.
+// ?duration={ms} tells the party how long the song is.
+// Suggestions over the party's limits are a 429, see jsonQuotaError.
 func (s *Server) Suggest(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	uidStr, ufound := vars["uid"]
@@ -31,8 +38,24 @@ func (s *Server) Suggest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	duration, err := songDuration(r)
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
 	// try to suggest teh song
-	err = p.Suggest(party.UserUUID(uidStr), party.SongUID(sidStr))
+	err = p.SuggestWithDuration(party.UserUUID(uidStr), party.SongUID(sidStr), duration)
+	if quotaErr, over := err.(*party.QuotaError); over {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write(jsonQuotaError(quotaErr))
+
+		return
+	}
+
 	if err != nil {
 		errMsg := jsonError("%s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -146,7 +169,8 @@ func (s *Server) SuggestionClearvote(w http.ResponseWriter, r *http.Request) {
 
 // AddPlayNext adds a song to play next queue
 // Path is /addPlayNext/{pid}/{uid}/{sid}
-// The client must verify that the song id is good.
+// Songs are checked against the server's library if it has one.
+// ?duration={ms} tells the party how long the song is.
 func (s *Server) AddPlayNext(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	uidStr, ufound := vars["uid"]
@@ -167,8 +191,18 @@ func (s *Server) AddPlayNext(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	duration, err := songDuration(r)
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
 	// try to suggest teh song
-	err = p.PlayNext(party.UserUUID(uidStr), party.SongUID(sidStr))
+	err = p.PlayNextWithDuration(party.UserUUID(uidStr), party.SongUID(sidStr), duration)
+
 	if err != nil {
 		errMsg := jsonError("%s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -179,6 +213,7 @@ func (s *Server) AddPlayNext(w http.ResponseWriter, r *http.Request) {
 }
 
 // AddTopPlayNext adds a song to the top of the play next queue.
+// ?duration={ms} tells the party how long the song is.
 func (s *Server) AddTopPlayNext(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	uidStr, ufound := vars["uid"]
@@ -199,8 +234,18 @@ func (s *Server) AddTopPlayNext(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	duration, err := songDuration(r)
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
 	// try to add the song
-	err = p.AddTopPlayNext(party.UserUUID(uidStr), party.SongUID(sidStr))
+	err = p.AddTopPlayNextWithDuration(party.UserUUID(uidStr), party.SongUID(sidStr), duration)
+
 	if err != nil {
 		errMsg := jsonError("%s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -242,3 +287,262 @@ func (s *Server) RemovePlayNext(w http.ResponseWriter, r *http.Request) {
 
 	// exit with OK status code
 }
+
+// SetQueueOrder picks how the suggestion queue decides what plays next.
+// Path is /setQueueOrder/{pid}/{uid}/{order}
+func (s *Server) SetQueueOrder(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	orderStr, ofound := vars["order"]
+
+	if !ufound || !pfound || !ofound {
+		urlerror(w)
+		return
+	}
+
+	p, err := s.pm.Party(PartyUUID(pidStr))
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	err = p.SetQueueOrder(party.UserUUID(uidStr), party.QueueOrder(orderStr))
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status code
+}
+
+// jsonQuotaError says why a suggestion was over the limits and when the
+// user can suggest again:
+// {"error":<message>, "reason":<pending|cooldown|hourly>, "limit":<n>, "retryAtMs":<ms>}
+// retryAtMs is left out if it depends on the user's songs playing.
+func jsonQuotaError(quotaErr *party.QuotaError) []byte {
+	data := map[string]interface{}{
+		"error":  quotaErr.Error(),
+		"reason": quotaErr.Reason,
+	}
+
+	if quotaErr.Limit > 0 {
+		data["limit"] = quotaErr.Limit
+	}
+
+	if !quotaErr.RetryAt.IsZero() {
+		data["retryAtMs"] = quotaErr.RetryAt.UnixNano() / int64(time.Millisecond)
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return jsonError("%s", quotaErr.Error())
+	}
+
+	return raw
+}
+
+// SetSuggestionLimits for guests of a party. 0 is no limit.
+// Path is /setSuggestionLimits/{pid}/{uid}/{pending}/{cooldown}/{hourly}
+// pending is how many songs a guest can have waiting, cooldown is the ms
+// between their suggestions, and hourly is how many can play an hour.
+func (s *Server) SetSuggestionLimits(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	pendingStr, pendfound := vars["pending"]
+	cooldownStr, cfound := vars["cooldown"]
+	hourlyStr, hfound := vars["hourly"]
+
+	if !ufound || !pfound || !pendfound || !cfound || !hfound {
+		urlerror(w)
+		return
+	}
+
+	pending, perr := strconv.ParseUint(pendingStr, 10, 31)
+	cooldown, cerr := strconv.ParseUint(cooldownStr, 10, 63)
+	hourly, herr := strconv.ParseUint(hourlyStr, 10, 31)
+	if perr != nil || cerr != nil || herr != nil {
+		errMsg := jsonError("failed to parse limits")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	p, err := s.pm.Party(PartyUUID(pidStr))
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	err = p.SetSuggestionLimits(party.UserUUID(uidStr), party.SuggestionLimits{
+		MaxPending: int(pending),
+		CooldownMs: int64(cooldown),
+		MaxPerHour: int(hourly),
+	})
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status code
+}
+
+// SetVetoRule for the party's suggestion queue. 0 turns a threshold off.
+// Path is /setVetoRule/{pid}/{uid}/{net}/{fraction}/{ban}
+// Songs are vetoed when their net score gets down to -net, or when fraction
+// of the active users downvoted them. Vetoed songs can't be suggested again
+// for ban ms.
+func (s *Server) SetVetoRule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	netStr, nfound := vars["net"]
+	fractionStr, ffound := vars["fraction"]
+	banStr, bfound := vars["ban"]
+
+	if !ufound || !pfound || !nfound || !ffound || !bfound {
+		urlerror(w)
+		return
+	}
+
+	net, nerr := strconv.ParseUint(netStr, 10, 31)
+	fraction, ferr := strconv.ParseFloat(fractionStr, 64)
+	ban, berr := strconv.ParseUint(banStr, 10, 63)
+	if nerr != nil || ferr != nil || berr != nil {
+		errMsg := jsonError("failed to parse veto rule")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	p, err := s.pm.Party(PartyUUID(pidStr))
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	err = p.SetVetoRule(party.UserUUID(uidStr), party.VetoRule{
+		NetDownvotes:     int(net),
+		DownvoteFraction: fraction,
+		BanMs:            int64(ban),
+	})
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status code
+}
+
+// SetScoring for the party's suggestion queue, leaving a value out turns it off.
+// Path is /setScoring/{pid}/{uid}?halfLife={ms}&waitBoost={n}&roleWeights={role}:{n},{role}:{n}
+// Votes lose half their weight every halfLife, songs gain waitBoost for
+// every song suggested after them, and votes count by the voter's role.
+func (s *Server) SetScoring(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+
+	if !ufound || !pfound {
+		urlerror(w)
+		return
+	}
+
+	scoring, err := parseScoring(r)
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	p, err := s.pm.Party(PartyUUID(pidStr))
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	err = p.SetScoring(party.UserUUID(uidStr), scoring)
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status code
+}
+
+// parseScoring from SetScoring's query
+func parseScoring(r *http.Request) (party.Scoring, error) {
+	var scoring party.Scoring
+	query := r.URL.Query()
+
+	if halfLifeStr := query.Get("halfLife"); halfLifeStr != "" {
+		halfLife, err := strconv.ParseInt(halfLifeStr, 10, 64)
+		if err != nil {
+			return scoring, fmt.Errorf("failed to parse halfLife")
+		}
+
+		scoring.HalfLifeMs = halfLife
+	}
+
+	if boostStr := query.Get("waitBoost"); boostStr != "" {
+		boost, err := strconv.ParseFloat(boostStr, 64)
+		if err != nil {
+			return scoring, fmt.Errorf("failed to parse waitBoost")
+		}
+
+		scoring.WaitBoost = boost
+	}
+
+	for _, pair := range strings.Split(query.Get("roleWeights"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return scoring, fmt.Errorf("role weights are {role}:{weight}")
+		}
+
+		weight, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return scoring, fmt.Errorf("failed to parse the weight for %s", parts[0])
+		}
+
+		if scoring.RoleWeights == nil {
+			scoring.RoleWeights = make(map[party.Role]float64)
+		}
+
+		scoring.RoleWeights[party.Role(parts[0])] = weight
+	}
+
+	return scoring, nil
+}
@@ -1,6 +1,7 @@
 package server_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/me-next/menext-backend/party"
 	"github.com/me-next/menext-backend/server"
@@ -11,8 +12,8 @@ import (
 
 func (ts *testServer) suggestSong(pid server.PartyUUID, uid party.UserUUID, sid party.SongUID) error {
 
-	resp := ts.getHTTPResponse(
-		fmt.Sprintf("/suggest/%s/%s/%s", pid, uid, sid))
+	resp := ts.getAuthedResponse(
+		fmt.Sprintf("/suggest/%s/%s/%s", pid, uid, sid), uid)
 
 	// treat all the errors the same
 	if resp.Code != http.StatusOK {
@@ -24,8 +25,8 @@ func (ts *testServer) suggestSong(pid server.PartyUUID, uid party.UserUUID, sid
 
 func (ts *testServer) suggestDownvote(pid server.PartyUUID, uid party.UserUUID, sid party.SongUID) error {
 
-	resp := ts.getHTTPResponse(
-		fmt.Sprintf("/suggestDown/%s/%s/%s", pid, uid, sid))
+	resp := ts.getAuthedResponse(
+		fmt.Sprintf("/suggestDown/%s/%s/%s", pid, uid, sid), uid)
 
 	// treat all the errors the same
 	if resp.Code != http.StatusOK {
@@ -45,6 +46,13 @@ func (ts *testServer) joinEvent(pid server.PartyUUID, uid party.UserUUID, name s
 		return fmt.Errorf("error: %d %s", resp.Code, resp.Body.String())
 	}
 
+	data := make(map[string]string)
+	if err := json.Unmarshal(resp.Body.Bytes(), &data); err != nil {
+		return err
+	}
+
+	ts.tokens[uid] = data["token"]
+
 	return nil
 }
 
@@ -101,3 +109,103 @@ func TestSuggestUpvote(t *testing.T) {
 	assert.Nil(t, s.suggestDownvote(pid, ouid, songs[1]))
 	fmt.Println(s.pull(ouid, pid, 1))
 }
+
+func TestSetQueueOrder(t *testing.T) {
+	s := newTestServer()
+	ouid := party.UserUUID("1")
+
+	pid, err := s.createParty(ouid, "bob")
+	assert.Nil(t, err)
+
+	resp := s.getAuthedResponse(fmt.Sprintf("/setQueueOrder/%s/%s/shuffle", pid, ouid), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	resp = s.getAuthedResponse(fmt.Sprintf("/setQueueOrder/%s/%s/roundRobin", pid, ouid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	data, err := s.pull(ouid, pid, 0)
+	assert.Nil(t, err)
+
+	suggestions := data[party.PullSuggestKey].(map[string]interface{})
+	assert.Equal(t, "roundRobin", suggestions["order"])
+}
+
+func TestSuggestionLimits(t *testing.T) {
+	s := newTestServer()
+	ouid := party.UserUUID("1")
+	guid := party.UserUUID("2")
+
+	pid, err := s.createParty(ouid, "bob")
+	assert.Nil(t, err)
+	assert.Nil(t, s.joinEvent(pid, guid, "gary"))
+
+	resp := s.getAuthedResponse(fmt.Sprintf("/setSuggestionLimits/%s/%s/1/0/x", pid, ouid), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	resp = s.getAuthedResponse(fmt.Sprintf("/setSuggestionLimits/%s/%s/1/0/0", pid, guid), guid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	resp = s.getAuthedResponse(fmt.Sprintf("/setSuggestionLimits/%s/%s/1/60000/0", pid, ouid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	assert.Nil(t, s.suggestSong(pid, guid, "a"))
+
+	// too soon, the error says when to try again
+	resp = s.getAuthedResponse(fmt.Sprintf("/suggest/%s/%s/b", pid, guid), guid)
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+
+	data := make(map[string]interface{})
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &data))
+	assert.Equal(t, party.QuotaCooldown, data["reason"])
+	assert.Contains(t, data, "retryAtMs")
+	assert.Contains(t, data, "error")
+}
+
+func TestSetVetoRule(t *testing.T) {
+	s := newTestServer()
+	ouid := party.UserUUID("1")
+
+	pid, err := s.createParty(ouid, "bob")
+	assert.Nil(t, err)
+
+	resp := s.getAuthedResponse(fmt.Sprintf("/setVetoRule/%s/%s/2/most/0", pid, ouid), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	resp = s.getAuthedResponse(fmt.Sprintf("/setVetoRule/%s/%s/1/0/60000", pid, ouid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	assert.Nil(t, s.suggestSong(pid, ouid, "a"))
+	assert.Nil(t, s.suggestSong(pid, ouid, "b"))
+	assert.Nil(t, s.suggestDownvote(pid, ouid, "b"))
+
+	data, err := s.pull(ouid, pid, 0)
+	assert.Nil(t, err)
+	assert.Len(t, parseSuggestionQueue(data[party.PullSuggestKey]), 0)
+	assert.Len(t, data[party.PullVetoedKey], 1)
+
+	// vetoed songs can't come back yet
+	assert.NotNil(t, s.suggestSong(pid, ouid, "b"))
+}
+
+func TestSetScoring(t *testing.T) {
+	s := newTestServer()
+	ouid := party.UserUUID("1")
+
+	pid, err := s.createParty(ouid, "bob")
+	assert.Nil(t, err)
+
+	resp := s.getAuthedResponse(fmt.Sprintf("/setScoring/%s/%s?roleWeights=owner", pid, ouid), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	resp = s.getAuthedResponse(fmt.Sprintf("/setScoring/%s/%s?halfLife=soon", pid, ouid), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	resp = s.getAuthedResponse(
+		fmt.Sprintf("/setScoring/%s/%s?halfLife=600000&waitBoost=0.5&roleWeights=owner:3,cohost:2", pid, ouid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	data, err := s.pull(ouid, pid, 0)
+	assert.Nil(t, err)
+
+	suggestions := data[party.PullSuggestKey].(map[string]interface{})
+	scoring := suggestions[party.KScoring].(map[string]interface{})
+	assert.EqualValues(t, 600000, scoring["halfLifeMs"])
+	assert.EqualValues(t, 0.5, scoring["waitBoost"])
+	assert.EqualValues(t, 3, scoring["roleWeights"].(map[string]interface{})["owner"])
+}
@@ -0,0 +1,53 @@
+package server
+
+// contains the API for the radio, which plays when the queues are empty
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/me-next/menext-backend/party"
+	"net/http"
+	"strings"
+)
+
+// SetRadio picks where songs come from when a party's queues are empty.
+// Path is /setRadio/{pid}/{uid}/{mode}?songs={sid},{sid}
+// mode is off, playlist, history or artists. songs is the seed playlist,
+// it's needed for playlist mode.
+func (s *Server) SetRadio(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	modeStr, mfound := vars["mode"]
+
+	if !ufound || !pfound || !mfound {
+		urlerror(w)
+		return
+	}
+
+	p, err := s.pm.Party(PartyUUID(pidStr))
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	var songs []party.SongUID
+	for _, sid := range strings.Split(r.URL.Query().Get("songs"), ",") {
+		if sid = strings.TrimSpace(sid); sid != "" {
+			songs = append(songs, party.SongUID(sid))
+		}
+	}
+
+	err = p.SetRadio(party.UserUUID(uidStr), party.RadioMode(modeStr), songs)
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status code
+}
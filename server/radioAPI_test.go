@@ -0,0 +1,30 @@
+package server_test
+
+import (
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestSetRadio(t *testing.T) {
+	s := newTestServer()
+	ouid := party.UserUUID("1")
+
+	pid, err := s.createParty(ouid, "bob")
+	assert.Nil(t, err)
+
+	resp := s.getAuthedResponse(fmt.Sprintf("/setRadio/%s/%s/playlist", pid, ouid), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	resp = s.getAuthedResponse(fmt.Sprintf("/setRadio/%s/%s/playlist?songs=r1,r2", pid, ouid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	data, err := s.pull(ouid, pid, 0)
+	assert.Nil(t, err)
+
+	radio := data[party.PullRadioKey].(map[string]interface{})
+	assert.Equal(t, "playlist", radio[party.KRadioMode])
+	assert.Len(t, radio[party.KRadioSongs], 2)
+}
@@ -0,0 +1,127 @@
+package server
+
+// this file contains the API for finding songs
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/me-next/menext-backend/party"
+	"github.com/me-next/menext-backend/search"
+	"net/http"
+	"strconv"
+)
+
+// consts for search pages
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// searchResult is a song that was found and where it is in the party
+type searchResult struct {
+	Song party.SongInfo `json:"song"`
+	party.SongState
+}
+
+// SetSearchIndex songs are searched in. Parties should usually use it as
+// their catalog too, so the songs guests find have metadata.
+func (s *Server) SetSearchIndex(idx *search.Index) {
+	s.songIndex = idx
+}
+
+// Search for songs by title, artist and album.
+// Path is /search/{pid}?q={query}&offset={offset}&limit={limit}
+// Returns {"total": <matches>, "offset": <offset>, "limit": <limit>,
+// "results": [{"song": <SongInfo>, "suggested": <bool>, "playNext": <bool>, "playing": <bool>}]}
+// where the flags say where the song already is in the party.
+func (s *Server) Search(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pidStr, pfound := vars["pid"]
+
+	if !pfound {
+		urlerror(w)
+		return
+	}
+
+	if s.songIndex == nil {
+		errMsg := jsonError("search isn't available")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	p, err := s.pm.Party(PartyUUID(pidStr))
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	query := r.URL.Query()
+
+	offset := 0
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		if offset, err = strconv.Atoi(offsetStr); err != nil {
+			errMsg := jsonError("failed to parse offset")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errMsg)
+
+			return
+		}
+	}
+
+	limit := defaultSearchLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil {
+			errMsg := jsonError("failed to parse limit")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errMsg)
+
+			return
+		}
+	}
+
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	found, err := s.songIndex.Search(query.Get("q"), offset, limit)
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	sids := make([]party.SongUID, len(found.Songs))
+	for i, info := range found.Songs {
+		sids[i] = info.ID
+	}
+
+	results := make([]searchResult, len(found.Songs))
+	for i, state := range p.SongStates(sids...) {
+		results[i] = searchResult{Song: found.Songs[i], SongState: state}
+	}
+
+	data := map[string]interface{}{
+		"total":   found.Total,
+		"offset":  offset,
+		"limit":   limit,
+		"results": results,
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		errMsg := jsonError("failed to serialize")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	w.Write(raw)
+}
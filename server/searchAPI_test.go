@@ -0,0 +1,79 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"github.com/me-next/menext-backend/search"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	ts := newTestServer()
+
+	ouid := party.UserUUID("1")
+	pid, err := ts.createParty(ouid, "bob")
+	assert.Nil(t, err)
+
+	// nothing to search yet
+	resp := ts.getHTTPResponse(fmt.Sprintf("/search/%s?q=toto", pid))
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	idx := search.New([]party.SongInfo{
+		{ID: "a", Title: "Africa", Artist: "Toto"},
+		{ID: "b", Title: "Rosanna", Artist: "Toto"},
+		{ID: "c", Title: "Hold the Line", Artist: "Toto"},
+	})
+	ts.s.SetSearchIndex(idx)
+	ts.s.SetCatalog(idx)
+
+	assert.Nil(t, ts.suggestSong(pid, ouid, "a"))
+	assert.Nil(t, ts.suggestSong(pid, ouid, "b"))
+
+	type result struct {
+		Song      party.SongInfo `json:"song"`
+		Suggested bool           `json:"suggested"`
+		PlayNext  bool           `json:"playNext"`
+		Playing   bool           `json:"playing"`
+	}
+
+	var data struct {
+		Total   int      `json:"total"`
+		Limit   int      `json:"limit"`
+		Results []result `json:"results"`
+	}
+
+	resp = ts.getHTTPResponse(fmt.Sprintf("/search/%s?q=toto", pid))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &data))
+	assert.Equal(t, 3, data.Total)
+	assert.Equal(t, 20, data.Limit)
+
+	// the first suggestion plays right away
+	assert.Equal(t, "Africa", data.Results[0].Song.Title)
+	assert.True(t, data.Results[0].Playing)
+	assert.False(t, data.Results[0].Suggested)
+	assert.Equal(t, "Hold the Line", data.Results[1].Song.Title)
+	assert.False(t, data.Results[1].Suggested)
+	assert.Equal(t, "Rosanna", data.Results[2].Song.Title)
+	assert.True(t, data.Results[2].Suggested)
+
+	// pages
+	resp = ts.getHTTPResponse(fmt.Sprintf("/search/%s?q=toto&offset=2&limit=5", pid))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &data))
+	assert.Equal(t, 3, data.Total)
+	assert.Len(t, data.Results, 1)
+
+	// bad requests
+	resp = ts.getHTTPResponse(fmt.Sprintf("/search/%s?q=toto&limit=lots", pid))
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	resp = ts.getHTTPResponse(fmt.Sprintf("/search/%s", pid))
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	resp = ts.getHTTPResponse("/search/nope?q=toto")
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+}
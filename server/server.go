@@ -5,22 +5,80 @@ import (
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/me-next/menext-backend/party"
+	"github.com/me-next/menext-backend/search"
 	"net/http"
 	"strconv"
+	"time"
 )
 
+// longest a pull can wait for a change
+const maxPullWaitSeconds = 60
+
 // Server for the backend.
 // format of requests is <stuff to id command>/<command>/<params>.
 // ie to add a song to a party queue: /partyid/userid/addsong/songid
 type Server struct {
-	pm *PartyManager
+	pm       *PartyManager
+	push     *pushHub
+	sessions *SessionManager
+	clocks   *clockSync
+
+	// nil if songs can't be searched
+	songIndex *search.Index
 }
 
-// New server
+// New server. Parties and sessions only live in memory.
 func New() *Server {
 	return &Server{
-		pm: NewPartyManager(),
+		pm:       NewPartyManager(),
+		push:     newPushHub(),
+		sessions: NewRandomSessionManager(),
+		clocks:   newClockSync(),
+	}
+}
+
+// NewWithDataDir creates a server that saves parties to dataDir.
+// Any parties already saved there are restored, and the session key is kept
+// there so tokens stay valid across restarts. Parties look songs up in
+// catalog, nil for no metadata.
+func NewWithDataDir(dataDir string, catalog party.Catalog) (*Server, error) {
+	store, err := NewFileStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := NewEventLog(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := LoadSessionManager(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	pm, err := NewPersistentPartyManager(store, events, catalog)
+	if err != nil {
+		return nil, err
 	}
+
+	return &Server{
+		pm:       pm,
+		push:     newPushHub(),
+		sessions: sessions,
+		clocks:   newClockSync(),
+	}, nil
+}
+
+// SetOwnerTimeout sets how long a party's owner can be silent before
+// ownership fails over to someone else. 0 turns failover off.
+func (s *Server) SetOwnerTimeout(timeout time.Duration) {
+	s.pm.SetOwnerTimeout(timeout)
+}
+
+// SetCatalog parties look song metadata up in.
+func (s *Server) SetCatalog(catalog party.Catalog) {
+	s.pm.SetCatalog(catalog)
 }
 
 // just for testing, no error checking or anything
@@ -30,6 +88,7 @@ func (s *Server) sayHello(w http.ResponseWriter, r *http.Request) {
 
 // CreatePartyWithName allows a user to create a party with a custom name.
 // If the event name is taken, suggests an alternate name.
+// Returns the pid and the owner's session token.
 // Path is: /createPartyWithName/{uid}/{uname}/{pid}
 func (s *Server) CreatePartyWithName(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -69,7 +128,8 @@ func (s *Server) CreatePartyWithName(w http.ResponseWriter, r *http.Request) {
 
 	// write the json
 	data := map[string]string{
-		"pid": string(pid),
+		"pid":           string(pid),
+		sessionTokenKey: s.sessions.Mint(pid, party.UserUUID(uidStr)),
 	}
 
 	raw, err := json.Marshal(data)
@@ -96,6 +156,7 @@ func (s *Server) CreatePartyWithName(w http.ResponseWriter, r *http.Request) {
 }
 
 // CreateParty with uname and owner uuid.
+// Returns the pid and the owner's session token.
 // URL is /createParty/{uuid}/{uname}
 func (s *Server) CreateParty(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -122,7 +183,8 @@ func (s *Server) CreateParty(w http.ResponseWriter, r *http.Request) {
 
 	// write the json
 	data := map[string]string{
-		"pid": string(pid),
+		"pid":           string(pid),
+		sessionTokenKey: s.sessions.Mint(pid, party.UserUUID(uid)),
 	}
 
 	raw, err := json.Marshal(data)
@@ -149,6 +211,9 @@ func (s *Server) CreateParty(w http.ResponseWriter, r *http.Request) {
 }
 
 // JoinParty with owner uuid ownerName and party uuid
+// Returns the user's session token.
+// Locked parties need ?invite={code}, or the password POSTed in a password
+// form field so it stays out of urls and logs.
 // url is /{pid}/joinParty/{uuid}/{uname}
 func (s *Server) JoinParty(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -172,7 +237,8 @@ func (s *Server) JoinParty(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// verify that we can join the party
-	if err = p.AddUser(party.UserUUID(uidStr), uname); err != nil {
+	query := r.URL.Query()
+	if err = p.Join(party.UserUUID(uidStr), uname, r.PostFormValue("password"), query.Get("invite")); err != nil {
 		errMsg := jsonError("%s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write(errMsg)
@@ -180,7 +246,7 @@ func (s *Server) JoinParty(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// just exit with OK status code
+	s.writeToken(w, pid, party.UserUUID(uidStr))
 }
 
 // LeaveParty removes the user from the party
@@ -214,6 +280,77 @@ func (s *Server) LeaveParty(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// stop pushing to the user
+	s.push.closeUser(pid, party.UserUUID(uidStr))
+
+	// just exit with OK status code
+}
+
+// TransferOwnership from the owner to another user.
+// url is /transferOwnership/{pid}/{uid}/{target}
+func (s *Server) TransferOwnership(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	targetStr, tfound := vars["target"]
+
+	if !ufound || !pfound || !tfound {
+		urlerror(w)
+		return
+	}
+
+	pid := PartyUUID(pidStr)
+	p, err := s.pm.Party(pid)
+	if err != nil {
+		errMsg := jsonError("no such party %s", pid)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	if err = p.TransferOwnership(party.UserUUID(uidStr), party.UserUUID(targetStr)); err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// just exit with OK status code
+}
+
+// Heartbeat lets the party know the user's client is still around.
+// Pulling does the same thing, this is for clients that stopped pulling.
+// url is /heartbeat/{pid}/{uid}
+func (s *Server) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+
+	if !ufound || !pfound {
+		urlerror(w)
+		return
+	}
+
+	pid := PartyUUID(pidStr)
+	p, err := s.pm.Party(pid)
+	if err != nil {
+		errMsg := jsonError("no such party %s", pid)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	if err = p.Heartbeat(party.UserUUID(uidStr)); err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
 	// just exit with OK status code
 }
 
@@ -270,7 +407,9 @@ func (s *Server) RemoveParty(w http.ResponseWriter, r *http.Request) {
 }
 
 // Pull all of the data for the client if there is a recent change. This is the most frequent getter.
-// URL is /{pid}/{uid}/pull/{cid}
+// URL is /pull/{uid}/{pid}/{cid}
+// Long-poll by adding ?wait={seconds}, the request blocks until there is a change
+// after cid or the wait is up. Waits are capped at maxPullWaitSeconds.
 func (s *Server) Pull(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	uidStr, ufound := vars["uid"]
@@ -305,10 +444,37 @@ func (s *Server) Pull(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// optionally wait for a change
+	if waitStr := r.URL.Query().Get("wait"); waitStr != "" {
+		wait, err := strconv.ParseUint(waitStr, 10, 32)
+		if err != nil {
+			errMsg := jsonError("failed to parse wait")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errMsg)
+
+			return
+		}
+
+		if wait > maxPullWaitSeconds {
+			wait = maxPullWaitSeconds
+		}
+
+		p.WaitForChange(cid, time.Duration(wait)*time.Second)
+
+		// the party may have ended while we waited
+		if p.Ended() {
+			errMsg := jsonError("no such party %s", pid)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errMsg)
+
+			return
+		}
+	}
+
 	// need to get specifics for the user
 	data, err := p.Pull(party.UserUUID(uidStr), cid)
 	if err != nil {
-		errMsg := jsonError("err pulling from event:", err.Error())
+		errMsg := jsonError("err pulling from event: %s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write(errMsg)
 
@@ -392,7 +558,144 @@ func (s *Server) SetPermissions(w http.ResponseWriter, r *http.Request) {
 	// exit with OK status
 }
 
+// SetUserPermission overrides a permission for one user.
+// path is /setUserPermission/{pid}/{uid}/{target}/{perm}/{val}.
+// val == "true" when trying to set to true, otherwise "false"
+func (s *Server) SetUserPermission(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pidStr, pfound := vars["pid"]
+	uidStr, ufound := vars["uid"]
+	targetStr, tfound := vars["target"]
+	permStr, permFound := vars["perm"]
+	valStr, valFound := vars["val"]
+
+	if !ufound || !pfound || !tfound || !permFound || !valFound {
+		urlerror(w)
+		return
+	}
+
+	// get the party
+	pid := PartyUUID(pidStr)
+
+	p, err := s.pm.Party(pid)
+	if err != nil {
+		errMsg := jsonError("no such party %s", pid)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// try to set the permission
+	err = p.SetUserPermission(party.UserUUID(uidStr), party.UserUUID(targetStr), permStr, valStr == "true")
+	if err != nil {
+		errMsg := jsonError("error setting permission: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status
+}
+
+// ClearUserPermission removes a user's override so the party's permission applies.
+// path is /clearUserPermission/{pid}/{uid}/{target}/{perm}.
+func (s *Server) ClearUserPermission(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pidStr, pfound := vars["pid"]
+	uidStr, ufound := vars["uid"]
+	targetStr, tfound := vars["target"]
+	permStr, permFound := vars["perm"]
+
+	if !ufound || !pfound || !tfound || !permFound {
+		urlerror(w)
+		return
+	}
+
+	// get the party
+	pid := PartyUUID(pidStr)
+
+	p, err := s.pm.Party(pid)
+	if err != nil {
+		errMsg := jsonError("no such party %s", pid)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// try to clear the permission
+	err = p.ClearUserPermission(party.UserUUID(uidStr), party.UserUUID(targetStr), permStr)
+	if err != nil {
+		errMsg := jsonError("error clearing permission: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status
+}
+
+// Roles returns a map of roles to what they grant
+// Path is /roles
+func (s *Server) Roles(w http.ResponseWriter, r *http.Request) {
+	data := party.GetRoleDescriptions()
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		errMsg := jsonError("failed to serialize")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// write and exit
+	w.Write(raw)
+}
+
+// SetRole promotes or demotes a user.
+// path is /setRole/{pid}/{uid}/{target}/{role}
+func (s *Server) SetRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pidStr, pfound := vars["pid"]
+	uidStr, ufound := vars["uid"]
+	targetStr, tfound := vars["target"]
+	roleStr, rfound := vars["role"]
+
+	if !ufound || !pfound || !tfound || !rfound {
+		urlerror(w)
+		return
+	}
+
+	// get the party
+	pid := PartyUUID(pidStr)
+
+	p, err := s.pm.Party(pid)
+	if err != nil {
+		errMsg := jsonError("no such party %s", pid)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	err = p.SetRole(party.UserUUID(uidStr), party.UserUUID(targetStr), party.Role(roleStr))
+	if err != nil {
+		errMsg := jsonError("error setting role: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status
+}
+
 // GetAPI provides the server router. This is broken off from Start to make testing easier.
+// Routes acting as a user need that user's session token.
 func (s *Server) GetAPI() http.Handler {
 	router := mux.NewRouter()
 
@@ -402,35 +705,80 @@ func (s *Server) GetAPI() http.Handler {
 	// general party management
 	router.Path("/createParty/{uid}/{uname}").HandlerFunc(s.CreateParty).Methods("GET")
 	router.Path("/createPartyWithName/{uid}/{uname}/{pid}").HandlerFunc(s.CreatePartyWithName).Methods("GET")
-	router.Path("/removeParty/{uid}/{pid}").HandlerFunc(s.RemoveParty).Methods("GET")
-	router.Path("/pull/{uid}/{pid}/{cid}").HandlerFunc(s.Pull).Methods("GET")
-	router.Path("/joinParty/{pid}/{uid}/{uname}").HandlerFunc(s.JoinParty).Methods("GET")
-	router.Path("/leaveParty/{pid}/{uid}").HandlerFunc(s.LeaveParty).Methods("GET")
+	router.Path("/removeParty/{uid}/{pid}").HandlerFunc(s.authed(s.RemoveParty)).Methods("GET")
+	router.Path("/pull/{uid}/{pid}/{cid}").HandlerFunc(s.authed(s.Pull)).Methods("GET")
+	router.Path("/joinParty/{pid}/{uid}/{uname}").HandlerFunc(s.JoinParty).Methods("GET", "POST")
+	router.Path("/search/{pid}").HandlerFunc(s.Search).Methods("GET")
+	router.Path("/leaveParty/{pid}/{uid}").HandlerFunc(s.authed(s.LeaveParty)).Methods("GET")
+	router.Path("/refreshSession/{pid}/{uid}").HandlerFunc(s.authed(s.RefreshSession)).Methods("GET")
+	router.Path("/transferOwnership/{pid}/{uid}/{target}").HandlerFunc(s.authed(s.TransferOwnership)).Methods("GET")
+	router.Path("/heartbeat/{pid}/{uid}").HandlerFunc(s.authed(s.Heartbeat)).Methods("GET")
+	router.Path("/clock/{pid}/{uid}").HandlerFunc(s.authed(s.Clock)).Methods("GET")
+
+	// push
+	router.Path("/push/{pid}/{uid}/{cid}").HandlerFunc(s.authed(s.Push)).Methods("GET")
+	router.Path("/events/{pid}/{uid}").HandlerFunc(s.authed(s.Events)).Methods("GET")
 
 	// permissions
 	router.Path("/permissions").HandlerFunc(s.Permissions).Methods("GET")
-	router.Path("/setPermission/{pid}/{uid}/{perm}/{val}").HandlerFunc(s.SetPermissions).Methods("GET")
+	router.Path("/setPermission/{pid}/{uid}/{perm}/{val}").HandlerFunc(s.authed(s.SetPermissions)).Methods("GET")
+	router.Path("/setUserPermission/{pid}/{uid}/{target}/{perm}/{val}").HandlerFunc(s.authed(s.SetUserPermission)).Methods("GET")
+	router.Path("/clearUserPermission/{pid}/{uid}/{target}/{perm}").HandlerFunc(s.authed(s.ClearUserPermission)).Methods("GET")
+
+	// joining
+	router.Path("/setPassword/{pid}/{uid}").HandlerFunc(s.authed(s.SetPassword)).Methods("POST")
+	router.Path("/clearPassword/{pid}/{uid}").HandlerFunc(s.authed(s.SetPassword)).Methods("GET")
+	router.Path("/setInviteOnly/{pid}/{uid}/{val}").HandlerFunc(s.authed(s.SetInviteOnly)).Methods("GET")
+	router.Path("/createInvite/{pid}/{uid}/{uses}/{minutes}").HandlerFunc(s.authed(s.CreateInvite)).Methods("GET")
+	router.Path("/revokeInvite/{pid}/{uid}/{code}").HandlerFunc(s.authed(s.RevokeInvite)).Methods("GET")
+
+	// moderation
+	router.Path("/kick/{pid}/{uid}/{target}").HandlerFunc(s.authed(s.Kick)).Methods("GET")
+	router.Path("/ban/{pid}/{uid}/{target}").HandlerFunc(s.authed(s.Ban)).Methods("GET")
+	router.Path("/unban/{pid}/{uid}/{target}").HandlerFunc(s.authed(s.Unban)).Methods("GET")
+
+	// roles
+	router.Path("/roles").HandlerFunc(s.Roles).Methods("GET")
+	router.Path("/setRole/{pid}/{uid}/{target}/{role}").HandlerFunc(s.authed(s.SetRole)).Methods("GET")
 
 	// nowPlaying
-	router.Path("/seek/{pid}/{uid}/{pos}").HandlerFunc(s.Seek).Methods("GET")
-	router.Path("/songFinished/{pid}/{uid}/{sid}").HandlerFunc(s.SongFinished).Methods("GET")
-	router.Path("/setVolume/{pid}/{uid}/{volume}").HandlerFunc(s.SetVolume).Methods("GET")
-	router.Path("/play/{pid}/{uid}").HandlerFunc(s.Play).Methods("GET")
-	router.Path("/pause/{pid}/{uid}/{pos}").HandlerFunc(s.Pause).Methods("GET")
-
-	router.Path("/skip/{pid}/{uid}/{sid}").HandlerFunc(s.Skip).Methods("GET")
-	router.Path("/previous/{pid}/{uid}/{sid}").HandlerFunc(s.Previous).Methods("GET")
-	router.Path("/playNow/{pid}/{uid}/{sid}").HandlerFunc(s.PlayNow).Methods("GET")
+	router.Path("/seek/{pid}/{uid}/{pos}").HandlerFunc(s.authed(s.Seek)).Methods("GET")
+	router.Path("/songFinished/{pid}/{uid}/{sid}").HandlerFunc(s.authed(s.SongFinished)).Methods("GET")
+	router.Path("/setVolume/{pid}/{uid}/{volume}").HandlerFunc(s.authed(s.SetVolume)).Methods("GET")
+	router.Path("/play/{pid}/{uid}").HandlerFunc(s.authed(s.Play)).Methods("GET")
+	router.Path("/pause/{pid}/{uid}/{pos}").HandlerFunc(s.authed(s.Pause)).Methods("GET")
+
+	router.Path("/skip/{pid}/{uid}/{sid}").HandlerFunc(s.authed(s.Skip)).Methods("GET")
+	router.Path("/previous/{pid}/{uid}/{sid}").HandlerFunc(s.authed(s.Previous)).Methods("GET")
+	router.Path("/playNow/{pid}/{uid}/{sid}").HandlerFunc(s.authed(s.PlayNow)).Methods("GET")
+
+	// vote skipping
+	router.Path("/voteSkip/{pid}/{uid}/{sid}").HandlerFunc(s.authed(s.VoteSkip)).Methods("GET")
+	router.Path("/setSkipThreshold/{pid}/{uid}/{fraction}/{count}").HandlerFunc(s.authed(s.SetSkipThreshold)).Methods("GET")
+
+	// radio
+	router.Path("/setRadio/{pid}/{uid}/{mode}").HandlerFunc(s.authed(s.SetRadio)).Methods("GET")
+
+	// zones
+	router.Path("/addZone/{pid}/{uid}/{zone}").HandlerFunc(s.authed(s.AddZone)).Methods("GET")
+	router.Path("/removeZone/{pid}/{uid}/{zone}").HandlerFunc(s.authed(s.RemoveZone)).Methods("GET")
+	router.Path("/setMute/{pid}/{uid}/{zone}/{val}").HandlerFunc(s.authed(s.SetMute)).Methods("GET")
+	router.Path("/groupZone/{pid}/{uid}/{zone}").HandlerFunc(s.authed(s.GroupZone)).Methods("GET")
+	router.Path("/ungroupZone/{pid}/{uid}/{zone}").HandlerFunc(s.authed(s.UngroupZone)).Methods("GET")
 
 	// queues
-	router.Path("/suggest/{pid}/{uid}/{sid}").HandlerFunc(s.Suggest).Methods("GET")
-	router.Path("/suggestDown/{pid}/{uid}/{sid}").HandlerFunc(s.SuggestionDownvote).Methods("GET")
-	router.Path("/suggestUp/{pid}/{uid}/{sid}").HandlerFunc(s.SuggestionUpvote).Methods("GET")
-	router.Path("/suggestClearvote/{pid}/{uid}/{sid}").HandlerFunc(s.SuggestionClearvote).Methods("GET")
-
-	router.Path("/addPlayNext/{pid}/{uid}/{sid}").HandlerFunc(s.AddPlayNext).Methods("GET")
-	router.Path("/addTopPlayNext/{pid}/{uid}/{sid}").HandlerFunc(s.AddTopPlayNext).Methods("GET")
-	router.Path("/removePlayNext/{pid}/{uid}/{sid}").HandlerFunc(s.RemovePlayNext).Methods("GET")
+	router.Path("/suggest/{pid}/{uid}/{sid}").HandlerFunc(s.authed(s.Suggest)).Methods("GET")
+	router.Path("/suggestDown/{pid}/{uid}/{sid}").HandlerFunc(s.authed(s.SuggestionDownvote)).Methods("GET")
+	router.Path("/suggestUp/{pid}/{uid}/{sid}").HandlerFunc(s.authed(s.SuggestionUpvote)).Methods("GET")
+	router.Path("/suggestClearvote/{pid}/{uid}/{sid}").HandlerFunc(s.authed(s.SuggestionClearvote)).Methods("GET")
+	router.Path("/setQueueOrder/{pid}/{uid}/{order}").HandlerFunc(s.authed(s.SetQueueOrder)).Methods("GET")
+	router.Path("/setSuggestionLimits/{pid}/{uid}/{pending}/{cooldown}/{hourly}").HandlerFunc(s.authed(s.SetSuggestionLimits)).Methods("GET")
+	router.Path("/setVetoRule/{pid}/{uid}/{net}/{fraction}/{ban}").HandlerFunc(s.authed(s.SetVetoRule)).Methods("GET")
+	router.Path("/setScoring/{pid}/{uid}").HandlerFunc(s.authed(s.SetScoring)).Methods("GET")
+
+	router.Path("/addPlayNext/{pid}/{uid}/{sid}").HandlerFunc(s.authed(s.AddPlayNext)).Methods("GET")
+	router.Path("/addTopPlayNext/{pid}/{uid}/{sid}").HandlerFunc(s.authed(s.AddTopPlayNext)).Methods("GET")
+	router.Path("/removePlayNext/{pid}/{uid}/{sid}").HandlerFunc(s.authed(s.RemovePlayNext)).Methods("GET")
 
 	return router
 }
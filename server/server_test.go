@@ -3,11 +3,13 @@ package server_test
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/me-next/menext-backend/party"
 	"github.com/me-next/menext-backend/server"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 // fires a request using the response recorder
@@ -19,6 +21,16 @@ func getHTTPResponse(url string, s *server.Server) *httptest.ResponseRecorder {
 	return recorder
 }
 
+// fires a request with a session token
+func getAuthedHTTPResponse(url, token string, s *server.Server) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	s.GetAPI().ServeHTTP(recorder, req)
+
+	return recorder
+}
+
 // parses the PID out of a string
 func parsePID(str string) (string, error) {
 	data := make(map[string]string)
@@ -32,6 +44,19 @@ func parsePID(str string) (string, error) {
 	return pid, nil
 }
 
+// parses the session token out of a string
+func parseToken(str string) (string, error) {
+	data := make(map[string]string)
+
+	err := json.Unmarshal([]byte(str), &data)
+	token, found := data["token"]
+	if err != nil || !found {
+		return "", err
+	}
+
+	return token, nil
+}
+
 func TestServerSingleUser(t *testing.T) {
 	s := server.New()
 
@@ -47,26 +72,35 @@ func TestServerSingleUser(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotEqual(t, "", pid)
 
-	// do a bad remove
-	resp = getHTTPResponse(fmt.Sprintf("/removeParty/%s/%s", "2", pid), s)
-	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	token, err := parseToken(pidJson)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", token)
+
+	// do a remove without a session
+	resp = getHTTPResponse(fmt.Sprintf("/removeParty/%s/%s", ouid, pid), s)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.NotEqual(t, "", resp.Body.String())
+
+	// do a bad remove as someone else
+	resp = getAuthedHTTPResponse(fmt.Sprintf("/removeParty/%s/%s", "2", pid), token, s)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
 	assert.NotEqual(t, "", resp.Body.String())
 
 	// remove the party
-	resp = getHTTPResponse(fmt.Sprintf("/removeParty/%s/%s", ouid, pid), s)
+	resp = getAuthedHTTPResponse(fmt.Sprintf("/removeParty/%s/%s", ouid, pid), token, s)
 	assert.Equal(t, http.StatusOK, resp.Code)
 	assert.Equal(t, "", resp.Body.String())
 
 	// check that we can't still remove party
-	resp = getHTTPResponse(fmt.Sprintf("/removeParty/%s/%s", ouid, pid), s)
+	resp = getAuthedHTTPResponse(fmt.Sprintf("/removeParty/%s/%s", ouid, pid), token, s)
 	assert.Equal(t, 500, resp.Code)
 	assert.NotEqual(t, "", resp.Body.String())
 }
 
 // helper function for creating a party on the server
 // needs the owner name and id, plus the server and testing objects
-// parses the pid
-func createParty(ouid, oname string, s *server.Server, t *testing.T) string {
+// parses the pid and the owner's token
+func createParty(ouid, oname string, s *server.Server, t *testing.T) (string, string) {
 	resp := getHTTPResponse(fmt.Sprintf("/createParty/%s/%s", ouid, oname), s)
 	assert.Equal(t, http.StatusOK, resp.Code)
 	pidJson := resp.Body.String()
@@ -75,7 +109,10 @@ func createParty(ouid, oname string, s *server.Server, t *testing.T) string {
 	assert.Nil(t, err)
 	assert.NotEqual(t, "", pid)
 
-	return pid
+	token, err := parseToken(pidJson)
+	assert.Nil(t, err)
+
+	return pid, token
 }
 
 func TestServerMultiUser(t *testing.T) {
@@ -83,7 +120,7 @@ func TestServerMultiUser(t *testing.T) {
 
 	ownerName := "bob"
 	ouid := "1"
-	pid := createParty(ouid, ownerName, s, t)
+	pid, token := createParty(ouid, ownerName, s, t)
 
 	// create a party
 
@@ -91,7 +128,10 @@ func TestServerMultiUser(t *testing.T) {
 	fid := "2"
 	resp := getHTTPResponse(fmt.Sprintf("/joinParty/%s/%s/%s", pid, fid, "fred"), s)
 	assert.Equal(t, http.StatusOK, resp.Code)
-	assert.Equal(t, "", resp.Body.String())
+
+	ftoken, err := parseToken(resp.Body.String())
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", ftoken)
 
 	// double add the user
 	resp = getHTTPResponse(fmt.Sprintf("/joinParty/%s/%s/%s", pid, fid, "fred"), s)
@@ -99,21 +139,25 @@ func TestServerMultiUser(t *testing.T) {
 	assert.NotEqual(t, "", resp.Body.String())
 
 	// have the user violate permissions
-	resp = getHTTPResponse(fmt.Sprintf("/removeParty/%s/%s", fid, pid), s)
+	resp = getAuthedHTTPResponse(fmt.Sprintf("/removeParty/%s/%s", fid, pid), ftoken, s)
 	assert.Equal(t, http.StatusInternalServerError, resp.Code)
 	assert.NotEqual(t, "", resp.Body.String())
 
+	// have the user act as the owner
+	resp = getAuthedHTTPResponse(fmt.Sprintf("/removeParty/%s/%s", ouid, pid), ftoken, s)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
 	// remove the party
-	resp = getHTTPResponse(fmt.Sprintf("/removeParty/%s/%s", ouid, pid), s)
+	resp = getAuthedHTTPResponse(fmt.Sprintf("/removeParty/%s/%s", ouid, pid), token, s)
 	assert.Equal(t, http.StatusOK, resp.Code)
 	assert.Equal(t, "", resp.Body.String())
 }
 
 // helper function to pull from the client
-// need to provide the uid, event id, change id plus the server and testing objects
+// need to provide the uid, event id, token, change id plus the server and testing objects
 // expects that the pulls are good, and will test as such
-func pull(ouid, pid string, change uint64, s *server.Server, t *testing.T) map[string]interface{} {
-	resp := getHTTPResponse(fmt.Sprintf("/pull/%s/%s/%d", ouid, pid, change), s)
+func pull(ouid, pid, token string, change uint64, s *server.Server, t *testing.T) map[string]interface{} {
+	resp := getAuthedHTTPResponse(fmt.Sprintf("/pull/%s/%s/%d", ouid, pid, change), token, s)
 
 	// check response
 	assert.Equal(t, http.StatusOK, resp.Code)
@@ -137,15 +181,149 @@ func TestServerPullUser(t *testing.T) {
 
 	ouid := "1"
 	oname := "bob"
-	pid := createParty(ouid, oname, s, t)
+	pid, token := createParty(ouid, oname, s, t)
 
 	// should be empty
-	data := pull(ouid, pid, 0, s, t)
+	data := pull(ouid, pid, token, 0, s, t)
 	assert.Empty(t, data)
 
 	// check a bad pull
-	resp := getHTTPResponse(fmt.Sprintf("/pull/%s/%s/%d", ouid, pid, 1), s)
+	resp := getAuthedHTTPResponse(fmt.Sprintf("/pull/%s/%s/%d", ouid, pid, 1), token, s)
 	assert.Equal(t, http.StatusInternalServerError, resp.Code)
 
 	assert.Contains(t, resp.Body.String(), "bad pull id")
 }
+
+func TestServerLongPoll(t *testing.T) {
+	s := server.New()
+
+	ouid := "1"
+	pid, token := createParty(ouid, "bob", s, t)
+
+	// nothing changes, wait times out with an empty pull
+	start := time.Now()
+	resp := getAuthedHTTPResponse(fmt.Sprintf("/pull/%s/%s/%d?wait=1", ouid, pid, 0), token, s)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "", resp.Body.String())
+	assert.True(t, time.Since(start) >= time.Second)
+
+	// bad wait
+	resp = getAuthedHTTPResponse(fmt.Sprintf("/pull/%s/%s/%d?wait=soon", ouid, pid, 0), token, s)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	// a change while waiting wakes the pull
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		getAuthedHTTPResponse(fmt.Sprintf("/suggest/%s/%s/%s", pid, ouid, "a"), token, s)
+	}()
+
+	resp = getAuthedHTTPResponse(fmt.Sprintf("/pull/%s/%s/%d?wait=10", ouid, pid, 0), token, s)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	data := make(map[string]interface{})
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &data))
+	assert.EqualValues(t, 1, data["change"])
+
+	// removing the party wakes the pull
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		getAuthedHTTPResponse(fmt.Sprintf("/removeParty/%s/%s", ouid, pid), token, s)
+	}()
+
+	start = time.Now()
+	resp = getAuthedHTTPResponse(fmt.Sprintf("/pull/%s/%s/%d?wait=10", ouid, pid, 1), token, s)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	assert.True(t, time.Since(start) < 10*time.Second)
+}
+
+func TestServerUserPermissions(t *testing.T) {
+	ts := newTestServer()
+
+	ouid := party.UserUUID("1")
+	fuid := party.UserUUID("2")
+
+	pid, err := ts.createParty(ouid, "bob")
+	assert.Nil(t, err)
+	assert.Nil(t, ts.joinEvent(pid, fuid, "fred"))
+
+	// only the owner can set overrides
+	resp := ts.getAuthedResponse(fmt.Sprintf("/setUserPermission/%s/%s/%s/%s/false", pid, fuid, fuid, party.UserCanSeekPermission), fuid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	resp = ts.getAuthedResponse(fmt.Sprintf("/setUserPermission/%s/%s/%s/%s/false", pid, ouid, fuid, party.UserCanSeekPermission), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// the user sees what they can do
+	data, err := ts.pull(fuid, pid, 0)
+	assert.Nil(t, err)
+	perms := data[party.PullMyPermissionsKey].(map[string]interface{})
+	assert.Equal(t, false, perms[party.UserCanSeekPermission])
+	assert.Equal(t, true, perms[party.UserCanSkipPermission])
+	assert.NotNil(t, ts.seek(fuid, pid, 5))
+
+	resp = ts.getAuthedResponse(fmt.Sprintf("/clearUserPermission/%s/%s/%s/%s", pid, ouid, fuid, party.UserCanSeekPermission), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Nil(t, ts.seek(fuid, pid, 5))
+
+	// nothing left to clear
+	resp = ts.getAuthedResponse(fmt.Sprintf("/clearUserPermission/%s/%s/%s/%s", pid, ouid, fuid, party.UserCanSeekPermission), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+}
+
+func TestServerRoles(t *testing.T) {
+	ts := newTestServer()
+
+	ouid := party.UserUUID("1")
+	fuid := party.UserUUID("2")
+
+	pid, err := ts.createParty(ouid, "bob")
+	assert.Nil(t, err)
+	assert.Nil(t, ts.joinEvent(pid, fuid, "fred"))
+
+	resp := ts.getHTTPResponse("/roles")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), string(party.RoleCoHost))
+
+	// guests can't promote themselves
+	resp = ts.getAuthedResponse(fmt.Sprintf("/setRole/%s/%s/%s/%s", pid, fuid, fuid, party.RoleCoHost), fuid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	resp = ts.getAuthedResponse(fmt.Sprintf("/setRole/%s/%s/%s/%s", pid, ouid, fuid, party.RoleCoHost), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	data, err := ts.pull(fuid, pid, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, string(party.RoleCoHost), data[party.PullMyRoleKey])
+
+	// co-hosts can set permissions
+	resp = ts.getAuthedResponse(fmt.Sprintf("/setPermission/%s/%s/%s/false", pid, fuid, party.UserCanSeekPermission), fuid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestServerTransferOwnership(t *testing.T) {
+	ts := newTestServer()
+
+	ouid := party.UserUUID("1")
+	fuid := party.UserUUID("2")
+
+	pid, err := ts.createParty(ouid, "bob")
+	assert.Nil(t, err)
+	assert.Nil(t, ts.joinEvent(pid, fuid, "fred"))
+
+	resp := ts.getAuthedResponse(fmt.Sprintf("/transferOwnership/%s/%s/%s", pid, fuid, fuid), fuid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	resp = ts.getAuthedResponse(fmt.Sprintf("/transferOwnership/%s/%s/%s", pid, ouid, fuid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = ts.getAuthedResponse(fmt.Sprintf("/heartbeat/%s/%s", pid, fuid), fuid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// the new owner leaving hands it back
+	resp = ts.getAuthedResponse(fmt.Sprintf("/leaveParty/%s/%s", pid, fuid), fuid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	data, err := ts.pull(ouid, pid, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, string(party.RoleOwner), data[party.PullMyRoleKey])
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/me-next/menext-backend/party"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// consts for sessions
+const (
+	sessionTTLHours  = partyExpirationTime
+	sessionKeySize   = 32
+	sessionKeyFile   = "session.key"
+	sessionTokenKey  = "token"
+	sessionAuthRealm = "Bearer "
+)
+
+// Session binds a user to a party until it expires.
+type Session struct {
+	Party   PartyUUID      `json:"p"`
+	User    party.UserUUID `json:"u"`
+	Expires int64          `json:"e"`
+}
+
+// SessionManager mints and verifies signed session tokens.
+// A token is the base64 session followed by a base64 HMAC of it.
+type SessionManager struct {
+	key []byte
+	ttl time.Duration
+}
+
+// NewSessionManager signing with key. Tokens expire after ttl.
+func NewSessionManager(key []byte, ttl time.Duration) *SessionManager {
+	return &SessionManager{
+		key: key,
+		ttl: ttl,
+	}
+}
+
+// NewRandomSessionManager with a fresh key.
+// Tokens don't survive a restart.
+func NewRandomSessionManager() *SessionManager {
+	key := make([]byte, sessionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		panic("oh nose! couldn't generate a session key")
+	}
+
+	return NewSessionManager(key, sessionTTLHours*time.Hour)
+}
+
+// LoadSessionManager with the key saved in dir, creating the key if needed.
+// This keeps tokens valid across restarts.
+func LoadSessionManager(dir string) (*SessionManager, error) {
+	path := filepath.Join(dir, sessionKeyFile)
+
+	key, err := ioutil.ReadFile(path)
+	if err == nil && len(key) == sessionKeySize {
+		return NewSessionManager(key, sessionTTLHours*time.Hour), nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	sm := NewRandomSessionManager()
+	if err = ioutil.WriteFile(path, sm.key, 0600); err != nil {
+		return nil, err
+	}
+
+	return sm, nil
+}
+
+// Mint a token for a user in a party.
+func (sm *SessionManager) Mint(pid PartyUUID, uid party.UserUUID) string {
+	session := Session{
+		Party:   pid,
+		User:    uid,
+		Expires: time.Now().Add(sm.ttl).Unix(),
+	}
+
+	// can't fail, the session is just strings and ints
+	raw, _ := json.Marshal(session)
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sm.sign(payload))
+}
+
+// Verify a token, returning the session if it's good.
+func (sm *SessionManager) Verify(token string) (Session, error) {
+	var session Session
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return session, fmt.Errorf("malformed token")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, sm.sign(parts[0])) {
+		return session, fmt.Errorf("bad token signature")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return session, fmt.Errorf("malformed token")
+	}
+
+	if err = json.Unmarshal(raw, &session); err != nil {
+		return session, fmt.Errorf("malformed token")
+	}
+
+	if time.Now().Unix() > session.Expires {
+		return session, fmt.Errorf("token expired")
+	}
+
+	return session, nil
+}
+
+// sign a token payload
+func (sm *SessionManager) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, sm.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// requestToken from the Authorization header or the token query param.
+// The query param is for clients that can't set headers, like EventSource.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, sessionAuthRealm) {
+		return strings.TrimPrefix(auth, sessionAuthRealm)
+	}
+
+	return r.URL.Query().Get(sessionTokenKey)
+}
+
+// authed wraps a handler so it only runs for requests with a session
+// for the {pid} and {uid} in the path.
+// 401 if the token is missing or bad, 403 if it's for someone else.
+func (s *Server) authed(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := requestToken(r)
+		if token == "" {
+			errMsg := jsonError("missing session token")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(errMsg)
+
+			return
+		}
+
+		session, err := s.sessions.Verify(token)
+		if err != nil {
+			errMsg := jsonError("%s", err.Error())
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(errMsg)
+
+			return
+		}
+
+		vars := mux.Vars(r)
+		if PartyUUID(vars["pid"]) != session.Party || party.UserUUID(vars["uid"]) != session.User {
+			errMsg := jsonError("session is for a different user or party")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(errMsg)
+
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// RefreshSession mints a new token for a user with a valid session.
+// Path is /refreshSession/{pid}/{uid}
+func (s *Server) RefreshSession(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+
+	if !ufound || !pfound {
+		urlerror(w)
+		return
+	}
+
+	s.writeToken(w, PartyUUID(pidStr), party.UserUUID(uidStr))
+}
+
+// writeToken mints a token and writes it as json
+func (s *Server) writeToken(w http.ResponseWriter, pid PartyUUID, uid party.UserUUID) {
+	data := map[string]string{
+		sessionTokenKey: s.sessions.Mint(pid, uid),
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		errMsg := jsonError("failed to serialize")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	w.Write(raw)
+}
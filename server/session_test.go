@@ -0,0 +1,54 @@
+package server_test
+
+import (
+	"github.com/me-next/menext-backend/server"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSessionTokens(t *testing.T) {
+	sm := server.NewSessionManager([]byte("secret"), time.Hour)
+
+	token := sm.Mint("party", "1")
+	session, err := sm.Verify(token)
+	assert.Nil(t, err)
+	assert.Equal(t, server.PartyUUID("party"), session.Party)
+	assert.EqualValues(t, "1", session.User)
+
+	// tampered or garbage tokens
+	_, err = sm.Verify(token + "a")
+	assert.NotNil(t, err)
+
+	_, err = sm.Verify("garbage")
+	assert.NotNil(t, err)
+
+	// signed with a different key
+	other := server.NewSessionManager([]byte("other"), time.Hour)
+	_, err = other.Verify(token)
+	assert.NotNil(t, err)
+
+	// expired
+	expired := server.NewSessionManager([]byte("secret"), -time.Minute)
+	_, err = sm.Verify(expired.Mint("party", "1"))
+	assert.NotNil(t, err)
+}
+
+func TestSessionKeyPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	sm, err := server.LoadSessionManager(dir)
+	assert.Nil(t, err)
+	token := sm.Mint("party", "1")
+
+	// reloading keeps old tokens valid
+	reloaded, err := server.LoadSessionManager(dir)
+	assert.Nil(t, err)
+
+	_, err = reloaded.Verify(token)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extension for snapshot files in the data directory
+const snapshotExt = ".json"
+
+// FileStore saves party snapshots to a local data directory.
+// Each party is stored in its own file named after the party uuid.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore in dir, creating the directory if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data dir: %s", err.Error())
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// Save a snapshot of a party.
+// Writes to a temp file then renames so a crash never leaves a partial snapshot.
+// The file is synced before the rename, so a saved snapshot survives a crash.
+func (fs *FileStore) Save(pid PartyUUID, snap party.Snapshot) error {
+	raw, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp := fs.path(pid) + ".tmp"
+	if err = writeSynced(tmp, raw); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fs.path(pid))
+}
+
+// writeSynced writes a file and syncs it to disk
+func writeSynced(path string, raw []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(raw); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Remove the snapshot for a party. Not an error if there isn't one.
+func (fs *FileStore) Remove(pid PartyUUID) error {
+	err := os.Remove(fs.path(pid))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Load all of the snapshots in the data directory.
+// Files that aren't snapshots Save wrote are skipped.
+func (fs *FileStore) Load() (map[PartyUUID]party.Snapshot, error) {
+	files, err := ioutil.ReadDir(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snaps := make(map[PartyUUID]party.Snapshot)
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, snapshotExt) {
+			continue
+		}
+
+		raw, err := ioutil.ReadFile(filepath.Join(fs.dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		var snap party.Snapshot
+		if err = json.Unmarshal(raw, &snap); err != nil {
+			return nil, fmt.Errorf("bad snapshot %s: %s", name, err.Error())
+		}
+
+		// other json in the directory isn't a party
+		if snap.Version < 1 || snap.Version > party.SnapshotVersion {
+			log.Printf("skipping %s, it isn't a party snapshot", name)
+			continue
+		}
+
+		snaps[PartyUUID(strings.TrimSuffix(name, snapshotExt))] = snap
+	}
+
+	return snaps, nil
+}
+
+// path to the snapshot for a party
+func (fs *FileStore) path(pid PartyUUID) string {
+	return filepath.Join(fs.dir, string(pid)+snapshotExt)
+}
@@ -0,0 +1,186 @@
+package server_test
+
+import (
+	"github.com/me-next/menext-backend/party"
+	"github.com/me-next/menext-backend/server"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPersistentManagerRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "menext")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := server.NewFileStore(dir)
+	assert.Nil(t, err)
+
+	events, err := server.NewEventLog(dir)
+	assert.Nil(t, err)
+
+	pm, err := server.NewPersistentPartyManager(store, events, nil)
+	assert.Nil(t, err)
+
+	pid, err := pm.CreateParty("1", "bob")
+	assert.Nil(t, err)
+
+	removed, err := pm.CreateParty("2", "fred")
+	assert.Nil(t, err)
+
+	p, err := pm.Party(pid)
+	assert.Nil(t, err)
+	assert.Nil(t, p.AddUser("3", "ted"))
+	assert.Nil(t, p.Suggest("1", "a"))
+
+	// some changes are in the snapshot, the rest are only in the log
+	pm.Compact()
+	assert.Nil(t, p.Suggest("3", "b"))
+	assert.Nil(t, p.Suggest("3", "c"))
+	assert.Nil(t, p.SuggestionUpvote("1", "c"))
+	assert.Nil(t, p.Seek("3", 12))
+
+	// removed parties shouldn't come back
+	assert.Nil(t, pm.Remove(removed))
+
+	// "restart" by loading a new manager from the same directory
+	restored, err := server.NewPersistentPartyManager(store, events, nil)
+	assert.Nil(t, err)
+
+	_, err = restored.Party(removed)
+	assert.NotNil(t, err)
+
+	rp, err := restored.Party(pid)
+	assert.Nil(t, err)
+
+	// clients can keep pulling with their change ids
+	rawExpected, err := p.Pull("3", 0)
+	assert.Nil(t, err)
+	rawActual, err := rp.Pull("3", 0)
+	assert.Nil(t, err)
+
+	expected := rawExpected.(map[string]interface{})
+	actual := rawActual.(map[string]interface{})
+	for _, key := range []string{party.PullChangeKey, party.PullSuggestKey, party.PullPlayNextKey, party.PullPermissionKey} {
+		assert.Equal(t, expected[key], actual[key])
+	}
+
+	// seek replayed at the time it happened
+	expectedPlaying := expected[party.PullPlayingKey].(map[string]interface{})
+	actualPlaying := actual[party.PullPlayingKey].(map[string]interface{})
+	for _, key := range []string{party.KCurrentSongID, party.KSongStartTimeMs, party.KSongPosition} {
+		assert.Equal(t, expectedPlaying[key], actualPlaying[key])
+	}
+
+	data, err := rp.Pull("3", 5)
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+
+	// users and owner survive
+	assert.NotNil(t, rp.AddUser("3", "ted"))
+	assert.True(t, rp.CanUserEndParty("1"))
+}
+
+func TestFileStoreLoadEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "menext")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := server.NewFileStore(dir)
+	assert.Nil(t, err)
+
+	snaps, err := store.Load()
+	assert.Nil(t, err)
+	assert.Empty(t, snaps)
+
+	// removing a party that was never saved is fine
+	assert.Nil(t, store.Remove("nope"))
+}
+
+func TestPersistentManagerLogGap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "menext")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := server.NewFileStore(dir)
+	assert.Nil(t, err)
+
+	events, err := server.NewEventLog(dir)
+	assert.Nil(t, err)
+
+	pm, err := server.NewPersistentPartyManager(store, events, nil)
+	assert.Nil(t, err)
+
+	pid, err := pm.CreateParty("1", "bob")
+	assert.Nil(t, err)
+
+	p, err := pm.Party(pid)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Suggest("1", "a"))
+
+	// an event went missing, replaying past it would lose the rest
+	seq := p.Snapshot().EventSeq
+	assert.Nil(t, events.Append(pid, party.Event{Seq: seq + 2, Type: party.EventSuggest, Actor: "1", Song: "b"}))
+
+	_, err = server.NewPersistentPartyManager(store, events, nil)
+	assert.NotNil(t, err)
+}
+
+func TestFileStoreSkipsOtherFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "menext")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// json that isn't a party, ie a library index kept with the data
+	other := filepath.Join(dir, "library.json")
+	raw := []byte(`{"version":1,"files":[]}`)
+	assert.Nil(t, ioutil.WriteFile(other, raw, 0644))
+
+	store, err := server.NewFileStore(dir)
+	assert.Nil(t, err)
+
+	events, err := server.NewEventLog(dir)
+	assert.Nil(t, err)
+
+	pm, err := server.NewPersistentPartyManager(store, events, nil)
+	assert.Nil(t, err)
+
+	_, err = pm.Party("library")
+	assert.NotNil(t, err)
+
+	// compacting leaves it alone
+	pm.Compact()
+	actual, err := ioutil.ReadFile(other)
+	assert.Nil(t, err)
+	assert.Equal(t, raw, actual)
+}
+
+func TestPersistentManagerBadEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "menext")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := server.NewFileStore(dir)
+	assert.Nil(t, err)
+
+	events, err := server.NewEventLog(dir)
+	assert.Nil(t, err)
+
+	pm, err := server.NewPersistentPartyManager(store, events, nil)
+	assert.Nil(t, err)
+
+	pid, err := pm.CreateParty("1", "bob")
+	assert.Nil(t, err)
+
+	p, err := pm.Party(pid)
+	assert.Nil(t, err)
+
+	// the party would end up somewhere other than where clients think it is
+	seq := p.Snapshot().EventSeq
+	assert.Nil(t, events.Append(pid, party.Event{Seq: seq + 1, Type: party.EventSuggest, Actor: "nobody", Song: "a", ChangeID: 1}))
+
+	_, err = server.NewPersistentPartyManager(store, events, nil)
+	assert.NotNil(t, err)
+}
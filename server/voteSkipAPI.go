@@ -0,0 +1,99 @@
+package server
+
+// contains the API for voting to skip songs
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/me-next/menext-backend/party"
+	"net/http"
+	"strconv"
+)
+
+// VoteSkip the currently playing song.
+// The path is /voteSkip/{pid}/{uid}/{sid}
+// sid has to be playing, so votes for a song that ended don't count.
+func (s *Server) VoteSkip(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	sidStr, sfound := vars["sid"]
+
+	if !ufound || !pfound || !sfound {
+		urlerror(w)
+		return
+	}
+
+	p, err := s.pm.Party(PartyUUID(pidStr))
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	err = p.VoteSkip(party.UserUUID(uidStr), party.SongUID(sidStr))
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status code
+}
+
+// SetSkipThreshold for voting to skip.
+// The path is /setSkipThreshold/{pid}/{uid}/{fraction}/{count}
+// A count of 0 means fraction of the active users have to vote.
+func (s *Server) SetSkipThreshold(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uidStr, ufound := vars["uid"]
+	pidStr, pfound := vars["pid"]
+	fractionStr, ffound := vars["fraction"]
+	countStr, cfound := vars["count"]
+
+	if !ufound || !pfound || !ffound || !cfound {
+		urlerror(w)
+		return
+	}
+
+	fraction, err := strconv.ParseFloat(fractionStr, 64)
+	if err != nil {
+		errMsg := jsonError("failed to parse fraction")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	count, err := strconv.ParseUint(countStr, 10, 31)
+	if err != nil {
+		errMsg := jsonError("failed to parse count")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	p, err := s.pm.Party(PartyUUID(pidStr))
+	if err != nil {
+		errMsg := jsonError("no such party")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	err = p.SetSkipThreshold(party.UserUUID(uidStr), fraction, int(count))
+	if err != nil {
+		errMsg := jsonError("%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errMsg)
+
+		return
+	}
+
+	// exit with OK status code
+}
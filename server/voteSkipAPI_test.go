@@ -0,0 +1,44 @@
+package server_test
+
+import (
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestVoteSkip(t *testing.T) {
+	s := newTestServer()
+	ouid := party.UserUUID("1")
+
+	pid, err := s.createParty(ouid, "bob")
+	assert.Nil(t, err)
+
+	resp := s.getAuthedResponse(fmt.Sprintf("/addPlayNext/%s/%s/a", pid, ouid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = s.getAuthedResponse(fmt.Sprintf("/addPlayNext/%s/%s/b", pid, ouid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = s.getAuthedResponse(fmt.Sprintf("/setSkipThreshold/%s/%s/half/0", pid, ouid), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	resp = s.getAuthedResponse(fmt.Sprintf("/setSkipThreshold/%s/%s/0.5/-1", pid, ouid), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	resp = s.getAuthedResponse(fmt.Sprintf("/setSkipThreshold/%s/%s/0.5/2", pid, ouid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// stale votes don't count
+	resp = s.getAuthedResponse(fmt.Sprintf("/voteSkip/%s/%s/b", pid, ouid), ouid)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	resp = s.getAuthedResponse(fmt.Sprintf("/voteSkip/%s/%s/a", pid, ouid), ouid)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	data, err := s.pull(ouid, pid, 0)
+	assert.Nil(t, err)
+
+	playing := data[party.PullPlayingKey].(map[string]interface{})
+	assert.Equal(t, "a", playing[party.KCurrentSongID])
+	assert.EqualValues(t, 1, playing[party.KSkipVotes])
+	assert.EqualValues(t, 2, playing[party.KSkipVotesNeeded])
+	assert.Equal(t, true, playing[party.KMySkipVote])
+}
@@ -0,0 +1,55 @@
+package server_test
+
+import (
+	"fmt"
+	"github.com/me-next/menext-backend/party"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestZoneAPI(t *testing.T) {
+	s := newTestServer()
+	ouid := party.UserUUID("1")
+	guid := party.UserUUID("2")
+
+	pid, err := s.createParty(ouid, "bob")
+	assert.Nil(t, err)
+	assert.Nil(t, s.joinEvent(pid, guid, "gary"))
+
+	call := func(uid party.UserUUID, path string) int {
+		return s.getAuthedResponse(fmt.Sprintf(path, pid, uid), uid).Code
+	}
+
+	assert.Equal(t, http.StatusInternalServerError, call(guid, "/addZone/%s/%s/patio"))
+	assert.Equal(t, http.StatusOK, call(ouid, "/addZone/%s/%s/patio"))
+	assert.Equal(t, http.StatusOK, call(guid, "/setVolume/%s/%s/70?zone=patio"))
+	assert.Equal(t, http.StatusOK, call(guid, "/setMute/%s/%s/patio/true"))
+
+	// grouped zones can't play on their own
+	assert.Equal(t, http.StatusInternalServerError, call(ouid, "/playNow/%s/%s/a?zone=patio"))
+	assert.Equal(t, http.StatusOK, call(ouid, "/ungroupZone/%s/%s/patio"))
+	assert.Equal(t, http.StatusOK, call(ouid, "/playNow/%s/%s/a?zone=patio"))
+	assert.Equal(t, http.StatusOK, call(ouid, "/pause/%s/%s/5?zone=patio"))
+
+	data, err := s.pull(ouid, pid, 0)
+	assert.Nil(t, err)
+
+	zones := data[party.PullZonesKey].([]interface{})
+	assert.Len(t, zones, 1)
+	zone := zones[0].(map[string]interface{})
+	assert.Equal(t, "patio", zone[party.KZoneName])
+	assert.EqualValues(t, 70, zone[party.KVolume])
+	assert.Equal(t, true, zone[party.KZoneMuted])
+
+	playing := zone[party.KZonePlaying].(map[string]interface{})
+	assert.Equal(t, "a", playing[party.KCurrentSongID])
+	assert.Equal(t, false, playing[party.KPlaying])
+
+	// the party's own player didn't change
+	assert.Equal(t, false, data[party.PullPlayingKey].(map[string]interface{})[party.KHasSong])
+
+	assert.Equal(t, http.StatusOK, call(ouid, "/groupZone/%s/%s/patio"))
+	assert.Equal(t, http.StatusOK, call(ouid, "/removeZone/%s/%s/patio"))
+	assert.Equal(t, http.StatusInternalServerError, call(ouid, "/removeZone/%s/%s/patio"))
+}